@@ -38,6 +38,9 @@ func (eng *engine) accept(fd int, _ netpoll.IOEvent) error {
 		eng.opts.Logger.Errorf("Accept() failed due to error: %v", err)
 		return errors.ErrAcceptSocket
 	}
+	if eng.throttleAccept(nfd, sa) {
+		return nil
+	}
 	if err = os.NewSyscallError("fcntl nonblock", unix.SetNonblock(nfd, true)); err != nil {
 		return err
 	}
@@ -73,6 +76,9 @@ func (el *eventloop) accept(fd int, ev netpoll.IOEvent) error {
 		el.getLogger().Errorf("Accept() failed due to error: %v", err)
 		return os.NewSyscallError("accept", err)
 	}
+	if el.engine.throttleAccept(nfd, sa) {
+		return nil
+	}
 	if err = os.NewSyscallError("fcntl nonblock", unix.SetNonblock(nfd, true)); err != nil {
 		return err
 	}
@@ -90,3 +96,15 @@ func (el *eventloop) accept(fd int, ev netpoll.IOEvent) error {
 	el.connections[c.fd] = c
 	return el.open(c)
 }
+
+// throttleAccept closes the newly accepted nfd and reports true when the accept rate limit is exceeded.
+func (eng *engine) throttleAccept(nfd int, sa unix.Sockaddr) bool {
+	if eng.acceptLimit == nil || eng.acceptLimit.Allow() {
+		return false
+	}
+	_ = unix.Close(nfd)
+	if h, ok := eng.eventHandler.(AcceptThrottleHandler); ok {
+		h.OnAcceptThrottled(socket.SockaddrToTCPOrUnixAddr(sa))
+	}
+	return true
+}
@@ -21,8 +21,21 @@
 package gnet
 
 import (
+	"bytes"
+	"context"
 	"encoding/binary"
 	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"math/bits"
+	"net"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/walkon/wsgnet/pkg/errors"
+	bsPool "github.com/walkon/wsgnet/pkg/pool/byteslice"
 )
 
 type (
@@ -34,12 +47,51 @@ type (
 		Decode(c Conn) ([]byte, error)
 	}
 
+	// PooledDecoder is implemented by the codecs which are able to decode frames into pooled buffers,
+	// which saves allocating a new buffer for each frame.
+	PooledDecoder interface {
+		// DecodePooled decodes a frame into a pooled buffer, done must be called to recycle
+		// the buffer once the frame is no longer used.
+		DecodePooled(c Conn) (frame []byte, done func(), err error)
+	}
+
+	// CountingDecoder is implemented by the codecs which are able to report the number of bytes consumed
+	// from the connection, which makes it possible to keep track of the exact position in the stream.
+	CountingDecoder interface {
+		// DecodeN is like Decode but it also returns the number of bytes consumed from c by the call,
+		// including the header and the trailer of the frame, consumed may be non-zero even when no frame
+		// is returned, e.g. when a corrupted frame is discarded.
+		DecodeN(c Conn) (frame []byte, consumed int, err error)
+	}
+
+	// FramePeeker is implemented by the codecs which are able to find the end of the next complete frame without
+	// decoding it, which makes it possible to forward the frames as they are on the wire, see Conn.SpliceFrameTo.
+	FramePeeker interface {
+		// PeekFrameLength returns the length of the next complete message buffered in c, including the header
+		// and the trailer of the frame, without consuming it, or zero if the message is incomplete.
+		PeekFrameLength(c Conn) (msgLength int, err error)
+		// DiscardFrame consumes the complete message of msgLength bytes peeked by PeekFrameLength from c,
+		// along with the per-connection bookkeeping that decoding the frame would do.
+		DiscardFrame(c Conn, msgLength int) error
+	}
+
+	// BuffersEncoder is implemented by the codecs which are able to encode a frame into scattered buffers
+	// that reference the original payload, which saves copying large payloads when they are written by writev.
+	BuffersEncoder interface {
+		// EncodeBuffers encodes buf into a frame made up of multiple buffers.
+		EncodeBuffers(c Conn, buf []byte) (net.Buffers, error)
+	}
+
 	// LengthFieldBasedFrameCodec is the refactoring from
 	// https://github.com/smallnest/goframe/blob/master/length_field_based_frameconn.go, licensed by Apache License 2.0.
 	// It encodes/decodes frames into/from TCP stream with value of the length field in the message.
 	LengthFieldBasedFrameCodec struct {
 		encoderConfig EncoderConfig
 		decoderConfig DecoderConfig
+		// byteOrderCodecs are the big-endian and little-endian variants decoding the frames by ByteOrderMark.
+		byteOrderCodecs *[2]*LengthFieldBasedFrameCodec
+		// base is the codec a variant of byteOrderCodecs belongs to.
+		base *LengthFieldBasedFrameCodec
 	}
 )
 
@@ -47,111 +99,1231 @@ type (
 // It is the go implementation of netty LengthFieldBasedFrameecoder and LengthFieldPrepender.
 // you can see javadoc of them to learn more details.
 func NewLengthFieldBasedFrameCodec(ec EncoderConfig, dc DecoderConfig) *LengthFieldBasedFrameCodec {
-	return &LengthFieldBasedFrameCodec{encoderConfig: ec, decoderConfig: dc}
+	cc := &LengthFieldBasedFrameCodec{encoderConfig: ec, decoderConfig: dc}
+	if dc.ByteOrderMark {
+		cc.byteOrderCodecs = new([2]*LengthFieldBasedFrameCodec)
+		for i, byteOrder := range []binary.ByteOrder{binary.BigEndian, binary.LittleEndian} {
+			variant := &LengthFieldBasedFrameCodec{encoderConfig: ec, decoderConfig: dc, base: cc}
+			variant.decoderConfig.ByteOrder = byteOrder
+			cc.byteOrderCodecs[i] = variant
+		}
+	}
+	return cc
+}
+
+// CRCScope represents which part of the frame is covered by the trailing CRC32 checksum.
+type CRCScope int
+
+const (
+	// CRCNone indicates that frames carry no trailing checksum.
+	CRCNone CRCScope = iota
+
+	// CRCPayloadOnly indicates that the trailing CRC32 covers only the payload.
+	CRCPayloadOnly
+
+	// CRCIncludeHeader indicates that the trailing CRC32 covers both the length field and the payload.
+	CRCIncludeHeader
+)
+
+// crcLength is the length of the trailing CRC32 checksum.
+const crcLength = 4
+
+// byteOrderMarkLength is the length of the byte order mark of DecoderConfig.ByteOrderMark.
+const byteOrderMarkLength = 2
+
+// NewHeaderWrappedLengthFieldCodec instantiates and returns the codec of the 6th example in the javadoc of
+// netty LengthFieldBasedFrameDecoder, where the 2-byte big-endian length field is wrapped by two 1-byte headers
+// and only counts the content. The first header and the length field are stripped, the second header is kept:
+//
+//	BEFORE DECODE (16 bytes)                       AFTER DECODE (13 bytes)
+//	+------+--------+------+----------------+      +------+----------------+
+//	| HDR1 | Length | HDR2 | Actual Content |----->| HDR2 | Actual Content |
+//	| 0xCA | 0x000C | 0xFE | "HELLO, WORLD" |      | 0xFE | "HELLO, WORLD" |
+//	+------+--------+------+----------------+      +------+----------------+
+//
+// That is LengthFieldOffset=1, LengthFieldLength=2, LengthAdjustment=1 and InitialBytesToStrip=3.
+// Encode passes buffers through untouched, so the caller is expected to assemble HDR1, Length and HDR2.
+func NewHeaderWrappedLengthFieldCodec() *LengthFieldBasedFrameCodec {
+	return NewLengthFieldBasedFrameCodec(EncoderConfig{}, DecoderConfig{
+		ByteOrder:           binary.BigEndian,
+		LengthFieldOffset:   1,
+		LengthFieldLength:   2,
+		LengthAdjustment:    1,
+		InitialBytesToStrip: 3,
+	})
 }
 
 // EncoderConfig config for encoder.
 type EncoderConfig struct {
 	// ByteOrder is the ByteOrder of the length field.
 	ByteOrder binary.ByteOrder
-	// LengthFieldLength is the length of the length field.
+	// LengthFieldLength is the length of the length field, 1, 2, 3, 4 or 8 bytes unless LengthFormatter is set.
 	LengthFieldLength int
+	// LengthFormatter is an optional function that writes length into the LengthFieldLength bytes of lengthField
+	// in place of writing an unsigned integer with ByteOrder, for the length fields encoded otherwise,
+	// see ASCIILengthFormatter. Returning an error fails the encoding, and it doesn't apply to LengthDelta.
+	LengthFormatter func(lengthField []byte, length int) error
+	// CRCScope determines whether a CRC32 checksum is appended to the frame and which bytes it covers,
+	// the checksum is written with ByteOrder and is not counted by the value of the length field.
+	CRCScope CRCScope
+	// LengthDelta indicates whether the length field carries the signed delta of the length from the one of the
+	// previous frame in place of the length, which is kept per connection by Conn.SetCodecScratch, the first frame
+	// of a connection carries the length itself, see DecoderConfig.LengthDelta. The frames must be written in the
+	// order they are encoded, and a length whose delta doesn't fit into the length field fails to encode.
+	LengthDelta bool
 	// LengthAdjustment is the compensation value to add to the value of the length field
 	// LengthAdjustment int
-	// LengthIncludesLengthFieldLength is true, the length of the prepended length field is added to the value of
-	// the prepended length field
-	// LengthIncludesLengthFieldLength bool
+	// LengthIncludesLengthFieldLength indicates whether the value of the length field counts the length field itself,
+	// which is decoded by DecoderConfig.LengthIncludesLengthFieldLength, a payload whose length along with the length
+	// field doesn't fit into the length field fails to encode.
+	LengthIncludesLengthFieldLength bool
 }
 
+// StripNone can be assigned to DecoderConfig.InitialBytesToStrip to keep the whole frame,
+// including the bytes before and of the length field, in the decoded result.
+const StripNone = -1
+
 // DecoderConfig config for decoder.
 type DecoderConfig struct {
 	// ByteOrder is the ByteOrder of the length field.
 	ByteOrder binary.ByteOrder
 	// LengthFieldOffset is the offset of the length field
-	// LengthFieldOffset int
-	// LengthFieldLength is the length of the length field
+	LengthFieldOffset int
+	// LengthFieldLength is the length of the length field, 1, 2, 3, 4 or 8 bytes unless LengthParser is set.
 	LengthFieldLength int
-	// LengthAdjustment is the compensation value to add to the value of the length field
-	// LengthAdjustment int
-	// InitialBytesToStrip is the number of first bytes to strip out from the decoded frame
-	// InitialBytesToStrip int
+	// LengthIncludesLengthFieldLength indicates whether the value of the length field counts the length field itself.
+	LengthIncludesLengthFieldLength bool
+	// LengthFieldUnit is the number of bytes per unit of the value of the length field, 4 for the lengths counted in
+	// 4-byte words for instance, zero means 1. The value is multiplied by it ahead of LengthAdjustment and the other
+	// adjustments, which are all in bytes, see X11RequestCodec.
+	LengthFieldUnit int
+	// LengthIncludesTrailer indicates whether the value of the length field counts the trailer following the payload
+	// as well, i.e. the trailing fields of TrailerLength and the checksum of CRCScope. Along with
+	// LengthIncludesLengthFieldLength, it makes the common convention [total][payload][crc] decoded without any
+	// LengthAdjustment, where total counts everything, the length field and the checksum included.
+	LengthIncludesTrailer bool
+	// LengthParser is an optional function that parses the value of the length field from its LengthFieldLength
+	// bytes in place of reading an unsigned integer with ByteOrder, for the length fields encoded otherwise,
+	// see Float32LengthParser. Returning an error rejects the frame.
+	LengthParser func(lengthField []byte) (int, error)
+	// LengthFieldExtension is an optional function for the two-stage length fields, made up of a coarse part of
+	// LengthFieldLength bytes and a fine part whose presence or length depends on the coarse one. It returns the
+	// number of bytes of the fine part given the coarse one, so that the whole length field is peeked and passed to
+	// LengthParser, which is required along with it, and the InterHeaderSkip bytes follow the whole length field.
+	// For instance, the frames of a 1-byte size class, which is followed by a big-endian 4-byte exact length only
+	// for the large class 0xFF while it is the length by itself otherwise, are decoded with:
+	//
+	//	NewLengthFieldBasedFrameCodec(EncoderConfig{}, DecoderConfig{
+	//		LengthFieldLength: 1,
+	//		LengthFieldExtension: func(coarse []byte) (int, error) {
+	//			if coarse[0] == 0xFF {
+	//				return 4, nil
+	//			}
+	//			return 0, nil
+	//		},
+	//		LengthParser: func(lengthField []byte) (int, error) {
+	//			if lengthField[0] == 0xFF {
+	//				return int(binary.BigEndian.Uint32(lengthField[1:])), nil
+	//			}
+	//			return int(lengthField[0]), nil
+	//		},
+	//	})
+	LengthFieldExtension func(coarse []byte) (int, error)
+	// LengthAdjustment is the compensation value to add to the value of the length field, it's negative when
+	// the length field counts the bytes before the payload as well, -4 for a length field that counts the whole
+	// frame after a 2-byte header for instance. Decode fails rather than waits forever if the adjusted length
+	// is negative or overflows.
+	LengthAdjustment int
+	// InitialBytesToStrip is the number of first bytes to strip out from the decoded frame,
+	// zero strips the whole header, i.e. everything up to the end of the length field and
+	// the InterHeaderSkip bytes, while StripNone strips nothing.
+	InitialBytesToStrip int
+	// InterHeaderSkip is the number of bytes between the length field and the payload which are not counted
+	// by the value of the length field, a header checksum for instance.
+	InterHeaderSkip int
+	// VerifyInterHeader is an optional function to verify the InterHeaderSkip bytes as soon as the header
+	// is complete, lengthField holds the bytes from the start of the frame through the length field,
+	// returning an error rejects the frame before its payload is read.
+	VerifyInterHeader func(lengthField, skipped []byte) error
+	// CRCScope determines whether a trailing CRC32 checksum is expected after the payload and which
+	// bytes it covers, the checksum is read with ByteOrder and is not counted by the value of the length field.
+	CRCScope CRCScope
+	// AdjustmentField is an optional field whose value is added to the value of the length field on top of
+	// LengthAdjustment, it may be read with a ByteOrder other than the one of the length field.
+	// A field following the length field must be covered by InterHeaderSkip to be excluded from the payload.
+	AdjustmentField HeaderField
+	// TrailerLength is an optional function that returns the length of the trailing fields following the payload
+	// of the frame whose header is given, for the trailing fields that are only present under certain conditions
+	// like a flag in the header. The trailing fields are not counted by the value of the length field but kept
+	// at the end of the decoded frame, they are followed by the checksum if CRCScope is set, which covers them.
+	TrailerLength func(header []byte) (int, error)
+	// SequenceField is an optional field in the header carrying the sequence number of the frame, from 1 to 4 bytes,
+	// which increments by one per frame and wraps around, see OnSequenceGap.
+	SequenceField HeaderField
+	// OnSequenceGap is an optional function called when a decoded frame carries the sequence number got, which skips
+	// ahead of the expected one, to request retransmission or reset for instance, the frame is decoded anyway.
+	// The sequence number expected next is tracked per connection by Conn.SetCodecScratch, it starts from the one of
+	// the first frame and the frames going backwards, e.g. the duplicated ones, are not reported.
+	OnSequenceGap func(c Conn, expected, got uint32)
+	// PriorityField is an optional field in the header carrying the priority of the frame, from 1 to 4 bytes,
+	// which is returned by DecodeWithPriority, see PriorityDispatcher.
+	PriorityField HeaderField
+	// ValidateUTF8 indicates whether to reject the frames whose payload is not valid UTF-8 with
+	// errors.ErrInvalidUTF8, like the text frames of WebSocket, for the text protocols. The frame is
+	// discarded as the one failing the checksum, so the connection is supposed to be closed.
+	ValidateUTF8 bool
+	// ByteOrderMark indicates that every frame starts with a 2-byte byte order mark, 0xFE 0xFF for big-endian and
+	// 0xFF 0xFE for little-endian, which selects the ByteOrder of the length field and the other fields of that
+	// frame in place of ByteOrder, a frame starting with anything else is rejected with errors.ErrMalformedFrame.
+	// The offsets are counted from the start of the frame, so LengthFieldOffset is at least 2, and the byte order
+	// mark is stripped from the decoded frame even with StripNone.
+	ByteOrderMark bool
+	// LengthDelta indicates whether the value of the length field read with ByteOrder is the signed delta, in two's
+	// complement of LengthFieldLength bytes, of the value from the one of the previous frame rather than the value
+	// itself, except for the first frame of a connection, which carries the value itself. The previous value is
+	// kept per connection by Conn.SetCodecScratch once a frame is decoded, and a reconstructed value going
+	// negative rejects the frame with errors.ErrMalformedFrame. It doesn't apply to the lengths of LengthParser.
+	LengthDelta bool
+	// SkipInterFrameByte indicates whether the InterFrameByte bytes found where a frame is supposed to start are
+	// keepalives outside the framing, which are consumed before reading the header of the frame, so the first byte
+	// of a frame must never be InterFrameByte, i.e. the length field must not start the frame if InterFrameByte
+	// can start it, a big-endian length below 256 begins with 0x00 for instance.
+	SkipInterFrameByte bool
+	// InterFrameByte is the keepalive byte interleaved between the frames skipped by SkipInterFrameByte.
+	InterFrameByte byte
+	// NoCopy indicates whether Decode and DecodeN return the frames borrowed from the inbound buffer of the
+	// connection rather than the copies of them, which saves an allocation per frame. The frame is only valid
+	// until the next call of the codec or any other method reading from the connection, and the same rule with
+	// Conn.Peek() applies, so it must be copied to be retained, see also DecodeInto.
+	NoCopy bool
+	// MaxFrameLength is the limit of the length of the whole frame declared by its header, zero means 10MB.
+	// Decode fails with an *errors.FrameTooLargeError carrying the declared length, which is an
+	// errors.ErrFrameTooLarge, rather than waits for a frame going beyond it, which is never decoded,
+	// so the connection is supposed to be closed.
+	MaxFrameLength int
+	// OnFrameTooLarge is an optional function called when the length of the whole frame declared by its header,
+	// declaredLen, exceeds MaxFrameLength, ahead of the decoding failing with errors.ErrFrameTooLarge, it's called
+	// again on every attempt to decode the frame unless the connection is closed.
+	OnFrameTooLarge func(c Conn, declaredLen int)
+	// OnHeader is an optional function called once per frame as soon as its header, from the beginning of the frame
+	// through the length field and the InterHeaderSkip bytes, is buffered and validated, which may be before the
+	// payload arrives or along with it, for the early decisions upon the header such as opening the upstream
+	// connection of a proxy by the destination carried in the header. The header is only valid within the call,
+	// the same rule with Conn.Peek() applies.
+	OnHeader func(c Conn, header []byte)
+}
+
+// HeaderField describes a field in the header of a frame other than the length field.
+type HeaderField struct {
+	// Offset is the offset of the field from the start of the frame.
+	Offset int
+	// Length is the length of the field in bytes, from 1 to 4, zero means there is no such field.
+	Length int
+	// ByteOrder is the ByteOrder of the field, it defaults to DecoderConfig.ByteOrder if nil.
+	ByteOrder binary.ByteOrder
 }
 
 // Encode ...
 func (cc *LengthFieldBasedFrameCodec) Encode(c Conn, buf []byte) (out []byte, err error) {
 	length := len(buf)
 	offset := cc.encoderConfig.LengthFieldLength
-	out = make([]byte, offset+length)
-	switch offset {
+	trailer := 0
+	if cc.encoderConfig.CRCScope != CRCNone {
+		trailer = crcLength
+	}
+	out = make([]byte, offset+length+trailer)
+	if err = cc.putEncodedLength(c, out, length); err != nil {
+		return nil, err
+	}
+
+	copy(out[offset:], buf)
+	// out = append(out, buf...)
+
+	switch cc.encoderConfig.CRCScope {
+	case CRCPayloadOnly:
+		cc.encoderConfig.ByteOrder.PutUint32(out[offset+length:], crc32.ChecksumIEEE(buf))
+	case CRCIncludeHeader:
+		cc.encoderConfig.ByteOrder.PutUint32(out[offset+length:], crc32.ChecksumIEEE(out[:offset+length]))
+	}
+
+	return
+}
+
+// EncodeBuffers is like Encode but it doesn't copy buf into a combined frame, instead it returns
+// the length field, buf itself and the checksum (if any) as individual buffers to be written by writev.
+func (cc *LengthFieldBasedFrameCodec) EncodeBuffers(c Conn, buf []byte) (net.Buffers, error) {
+	header := make([]byte, cc.encoderConfig.LengthFieldLength)
+	if err := cc.putEncodedLength(c, header, len(buf)); err != nil {
+		return nil, err
+	}
+
+	var checksum uint32
+	switch cc.encoderConfig.CRCScope {
+	case CRCNone:
+		return net.Buffers{header, buf}, nil
+	case CRCPayloadOnly:
+		checksum = crc32.ChecksumIEEE(buf)
+	case CRCIncludeHeader:
+		checksum = crc32.Update(crc32.ChecksumIEEE(header), crc32.IEEETable, buf)
+	}
+	trailer := make([]byte, crcLength)
+	cc.encoderConfig.ByteOrder.PutUint32(trailer, checksum)
+	return net.Buffers{header, buf, trailer}, nil
+}
+
+// lengthDeltaKey is the key of the length of the previous frame of EncoderConfig.LengthDelta or
+// DecoderConfig.LengthDelta stored by Conn.SetCodecScratch.
+type lengthDeltaKey struct {
+	cc      *LengthFieldBasedFrameCodec
+	encoder bool
+}
+
+// putEncodedLength puts length into out, or its delta from the length of the previous frame of c
+// if EncoderConfig.LengthDelta is set.
+func (cc *LengthFieldBasedFrameCodec) putEncodedLength(c Conn, out []byte, length int) error {
+	if cc.encoderConfig.LengthIncludesLengthFieldLength {
+		if length > math.MaxInt-cc.encoderConfig.LengthFieldLength {
+			return fmt.Errorf("%w: %d along with the length field", errors.ErrEncodeLengthOverflow, length)
+		}
+		length += cc.encoderConfig.LengthFieldLength
+	}
+	if format := cc.encoderConfig.LengthFormatter; format != nil {
+		return format(out[:cc.encoderConfig.LengthFieldLength], length)
+	}
+	if !cc.encoderConfig.LengthDelta {
+		return cc.putFrameLength(out, length)
+	}
+	key := lengthDeltaKey{cc, true}
+	var previous interface{}
+	if c != nil {
+		previous = c.CodecScratch(key)
+	}
+	prev, ok := previous.(int)
+	if !ok {
+		if err := cc.putFrameLength(out, length); err != nil {
+			return err
+		}
+	} else {
+		bits := uint(8 * cc.encoderConfig.LengthFieldLength)
+		delta := int64(length) - int64(prev)
+		if bits == 0 || (bits < 64 && (delta < -(1<<(bits-1)) || delta >= 1<<(bits-1))) {
+			return fmt.Errorf("%w: delta %d into %d bytes", errors.ErrEncodeLengthOverflow, delta, cc.encoderConfig.LengthFieldLength)
+		}
+		// the delta in two's complement of the length field.
+		if err := cc.putFrameLength(out, int(uint64(delta)&(1<<bits-1))); err != nil {
+			return err
+		}
+	}
+	if c != nil {
+		c.SetCodecScratch(key, length)
+	}
+	return nil
+}
+
+func (cc *LengthFieldBasedFrameCodec) putFrameLength(out []byte, length int) error {
+	switch cc.encoderConfig.LengthFieldLength {
 	case 1:
 		if length >= 256 {
-			return nil, fmt.Errorf("length does not fit into a byte: %d", length)
+			return fmt.Errorf("%w: %d into a byte", errors.ErrEncodeLengthOverflow, length)
 		}
 		out[0] = byte(length)
 	case 2:
 		if length >= 65536 {
-			return nil, fmt.Errorf("length does not fit into a short integer: %d", length)
+			return fmt.Errorf("%w: %d into a short integer", errors.ErrEncodeLengthOverflow, length)
 		}
 		cc.encoderConfig.ByteOrder.PutUint16(out, uint16(length))
 	case 3:
 		if length >= 16777216 {
-			return nil, fmt.Errorf("length does not fit into a medium integer: %d", length)
+			return fmt.Errorf("%w: %d into a medium integer", errors.ErrEncodeLengthOverflow, length)
 		}
 		writeUint24(cc.encoderConfig.ByteOrder, length, out)
 	case 4:
+		if uint64(length) >= 1<<32 {
+			return fmt.Errorf("%w: %d into an integer", errors.ErrEncodeLengthOverflow, length)
+		}
 		cc.encoderConfig.ByteOrder.PutUint32(out, uint32(length))
+	case 8:
+		cc.encoderConfig.ByteOrder.PutUint64(out, uint64(length))
 	}
+	return nil
+}
 
-	copy(out[offset:], buf)
-	// out = append(out, buf...)
+// WriteFrame encodes buf with codec and writes the frame to c. When codec implements BuffersEncoder,
+// the frame is written by writev without copying buf, it falls back to Encode and Write otherwise.
+// If c doesn't support writev, e.g. UDP, the buffers already encoded are joined and written by Write
+// rather than encoded again, since encoding moves the per-connection state of codecs like the one of
+// EncoderConfig.LengthDelta on.
+//
+// Note that WriteFrame is not concurrency-safe, you must call it in the current event-loop goroutine.
+func WriteFrame(c Conn, codec ICodec, buf []byte) (err error) {
+	if be, ok := codec.(BuffersEncoder); ok {
+		var bs net.Buffers
+		if bs, err = be.EncodeBuffers(c, buf); err != nil {
+			return
+		}
+		if _, err = c.Writev(bs); err != errors.ErrUnsupportedOp {
+			return
+		}
+		_, err = c.Write(bytes.Join(bs, nil))
+		return
+	}
+
+	var out []byte
+	if out, err = codec.Encode(c, buf); err != nil {
+		return
+	}
+	_, err = c.Write(out)
+	return
+}
+
+// DecodeBatch decodes the complete frames buffered in c with codec, up to maxFramesPerRead frames, zero or negative
+// means no limit. When it stops at maxFramesPerRead with bytes still buffered, the remaining frames stay buffered
+// in c and c is woken by Conn.Wake, so that EventHandler.OnTraffic fires again to decode them. The frames decoded
+// before an error are returned along with the error.
+//
+// Note that the frames must be owned by the caller, so codec is supposed to copy the decoded frames.
+func DecodeBatch(c Conn, codec ICodec, maxFramesPerRead int) (frames [][]byte, err error) {
+	defer func() { countFrames(c, len(frames)) }()
+	for maxFramesPerRead <= 0 || len(frames) < maxFramesPerRead {
+		var frame []byte
+		frame, err = codec.Decode(c)
+		if err == io.ErrShortBuffer || (err == nil && frame == nil) {
+			return frames, nil
+		}
+		if err != nil {
+			return
+		}
+		frames = append(frames, frame)
+	}
+	if c.InboundBuffered() > 0 {
+		err = c.Wake(nil)
+	}
+	return
+}
+
+// DecodeContext decodes the next frame from c with codec like codec.Decode, but it gives up on an incomplete frame
+// once ctx is done: the error of ctx is returned if any byte of the next frame is buffered after ctx is done,
+// then the caller is supposed to close the connection. A complete frame is still decoded after ctx is done.
+//
+// Since no event fires on a connection whose peer stops sending in the middle of a frame, WakeOnDone should be
+// armed along with ctx to get EventHandler.OnTraffic fired, in which DecodeContext is called, when ctx is done.
+func DecodeContext(ctx context.Context, c Conn, codec ICodec) ([]byte, error) {
+	frame, err := codec.Decode(c)
+	if frame != nil {
+		countFrames(c, 1)
+	}
+	if frame != nil || (err != nil && err != io.ErrShortBuffer) {
+		return frame, err
+	}
+	if buffered := c.InboundBuffered(); buffered > 0 && ctx.Err() != nil {
+		return nil, fmt.Errorf("abandon the incomplete frame of %d bytes buffered: %w", buffered, ctx.Err())
+	}
+	return nil, err
+}
+
+// WakeOnDone wakes c up by Conn.Wake once ctx is done, until stop is called, see DecodeContext.
+func WakeOnDone(ctx context.Context, c Conn) (stop func()) {
+	stopCh := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = c.Wake(nil)
+		case <-stopCh:
+		}
+	}()
+	var stopped bool
+	return func() {
+		if !stopped {
+			stopped = true
+			close(stopCh)
+		}
+	}
+}
+
+// arrivalKey is the key of the arrivalMark of DecodeWithArrival stored by Conn.SetCodecScratch.
+type arrivalKey struct{}
 
+// arrivalMark tells that the first buffered bytes of the inbound buffer had arrived by at.
+type arrivalMark struct {
+	at       time.Time
+	buffered int
+}
+
+// DecodeWithArrival decodes the next frame from c with codec like codec.Decode, along with the time its last byte
+// arrived at, i.e. the Conn.ArrivedAt of the read completing the frame, which is zero unless
+// Options.ArrivalTimestamps is set. The arrival of the bytes left buffered is kept per connection by
+// Conn.SetCodecScratch, so that the frames completed by a read but decoded after another read, e.g. the ones held
+// over by DecodeBatch, keep their own arrival, which requires all the frames of c to be decoded by DecodeWithArrival.
+func DecodeWithArrival(c Conn, codec ICodec) (frame []byte, arrival time.Time, err error) {
+	before, latest := c.InboundBuffered(), c.ArrivedAt()
+	mark, ok := c.CodecScratch(arrivalKey{}).(arrivalMark)
+	if !ok || mark.buffered > before {
+		mark = arrivalMark{latest, before}
+	}
+	if frame, err = codec.Decode(c); frame != nil {
+		countFrames(c, 1)
+	}
+	consumed := before - c.InboundBuffered()
+	arrival = latest
+	if consumed <= mark.buffered {
+		// the frame had been buffered completely before the latest read.
+		arrival = mark.at
+	}
+	if mark.buffered -= consumed; mark.buffered <= 0 {
+		mark = arrivalMark{latest, c.InboundBuffered()}
+	}
+	c.SetCodecScratch(arrivalKey{}, mark)
 	return
 }
 
-// Decode ...
+// Decode decodes the next complete frame, a header-only frame whose payload is empty is decoded as an empty frame
+// rather than nil, which means that more bytes are required, so it's delivered as an event like any other frame.
 func (cc *LengthFieldBasedFrameCodec) Decode(c Conn) ([]byte, error) {
-	var (
-		in  []byte
-		err error
-	)
+	frame, msgLength, err := cc.peekFrame(c)
+	if frame == nil {
+		return nil, err
+	}
+	if cc.decoderConfig.NoCopy {
+		if err = cc.DiscardFrame(c, msgLength); err != nil {
+			return nil, err
+		}
+		return frame, nil
+	}
 
-	in, err = c.Peek(cc.decoderConfig.LengthFieldLength)
-	if err != nil || len(in) < cc.decoderConfig.LengthFieldLength {
+	fullMessage := make([]byte, len(frame))
+	copy(fullMessage, frame)
+	if err = cc.DiscardFrame(c, msgLength); err != nil {
 		return nil, err
 	}
 
-	frameLength := cc.getFrameLength(in)
-	// real message length
-	msgLength := int(frameLength) + int(cc.decoderConfig.LengthFieldLength)
-	// 10MB: 不处理，过一段时间之后会自动断线
-	if msgLength <= 0 || msgLength >= 10485760 {
-		return nil, nil
+	return fullMessage, nil
+}
+
+// DecodeInto is like Decode but it decodes the frame into dst, overwriting its content and growing it
+// if it's too small, and returns the slice of dst holding the frame, so that a buffer reused across the calls
+// saves an allocation per frame without borrowing from the inbound buffer like NoCopy does.
+func (cc *LengthFieldBasedFrameCodec) DecodeInto(c Conn, dst []byte) ([]byte, error) {
+	frame, msgLength, err := cc.peekFrame(c)
+	if frame == nil {
+		return nil, err
+	}
+
+	if dst == nil {
+		// an empty frame is never nil.
+		dst = make([]byte, 0, len(frame))
+	}
+	dst = append(dst[:0], frame...)
+	if err = cc.DiscardFrame(c, msgLength); err != nil {
+		return nil, err
+	}
+
+	return dst, nil
+}
+
+// DecodeN is like Decode but it also returns the number of bytes of the whole message consumed from c.
+func (cc *LengthFieldBasedFrameCodec) DecodeN(c Conn) ([]byte, int, error) {
+	frame, msgLength, err := cc.peekFrame(c)
+	if frame == nil {
+		// the corrupted message has been discarded by peekFrame.
+		return nil, msgLength, err
+	}
+	if cc.decoderConfig.NoCopy {
+		if err = cc.DiscardFrame(c, msgLength); err != nil {
+			return nil, 0, err
+		}
+		return frame, msgLength, nil
+	}
+
+	fullMessage := make([]byte, len(frame))
+	copy(fullMessage, frame)
+	if err = cc.DiscardFrame(c, msgLength); err != nil {
+		return nil, 0, err
+	}
+
+	return fullMessage, msgLength, nil
+}
+
+// PeekFrameLength implements FramePeeker, the message failing the checksum is discarded as Decode does.
+func (cc *LengthFieldBasedFrameCodec) PeekFrameLength(c Conn) (int, error) {
+	msg, _, _, msgLength, err := cc.peekMessage(c)
+	if msg == nil {
+		return 0, err
+	}
+	return msgLength, nil
+}
+
+// DiscardFrame implements FramePeeker, it tracks the SequenceField and the LengthDelta of the message
+// before consuming it, which is left buffered if the tracking fails.
+func (cc *LengthFieldBasedFrameCodec) DiscardFrame(c Conn, msgLength int) error {
+	if cc.decoderConfig.SequenceField.Length > 0 || cc.decoderConfig.LengthDelta {
+		tracker := cc
+		if cc.byteOrderCodecs != nil {
+			variant, err := cc.byteOrderCodec(c)
+			if variant == nil {
+				return err
+			}
+			tracker = variant
+		}
+		msg, err := c.Peek(msgLength)
+		if err != nil {
+			return err
+		}
+		if cc.decoderConfig.SequenceField.Length > 0 {
+			if err = tracker.trackSequence(c, msg); err != nil {
+				return err
+			}
+		}
+		if cc.decoderConfig.LengthDelta {
+			tracker.trackLengthDelta(c, msg)
+		}
+	}
+	_, _ = c.Discard(msgLength)
+	return nil
+}
+
+// DecodeRaw is like Decode but it also returns the raw message the frame is decoded from, including
+// the header and the trailer, for auditing for instance. The frame is a sub-slice of raw.
+func (cc *LengthFieldBasedFrameCodec) DecodeRaw(c Conn) (frame, raw []byte, err error) {
+	msg, strip, payloadEnd, msgLength, err := cc.peekMessage(c)
+	if msg == nil {
+		return nil, nil, err
 	}
 
-	in, err = c.Peek(msgLength)
+	raw = make([]byte, msgLength)
+	copy(raw, msg)
+	if err = cc.DiscardFrame(c, msgLength); err != nil {
+		return nil, nil, err
+	}
+
+	return raw[strip:payloadEnd], raw, nil
+}
+
+// DecodeWithPriority is like Decode but it also returns the priority of the frame read from
+// DecoderConfig.PriorityField, which is zero if there is no such field.
+func (cc *LengthFieldBasedFrameCodec) DecodeWithPriority(c Conn) (frame []byte, priority uint32, err error) {
+	msg, strip, payloadEnd, msgLength, err := cc.peekMessage(c)
+	if msg == nil {
+		return nil, 0, err
+	}
+	if field := cc.decoderConfig.PriorityField; field.Length > 0 {
+		if field.Offset+field.Length > len(msg) {
+			return nil, 0, fmt.Errorf("%w: no room for the priority", errors.ErrShortFrame)
+		}
+		byteOrder := field.ByteOrder
+		if byteOrder == nil {
+			byteOrder = cc.frameByteOrder(msg)
+		}
+		v, err := readUint(byteOrder, msg[field.Offset:], field.Length)
+		if err != nil {
+			return nil, 0, err
+		}
+		priority = uint32(v)
+	}
+
+	frame = make([]byte, payloadEnd-strip)
+	copy(frame, msg[strip:payloadEnd])
+	if err = cc.DiscardFrame(c, msgLength); err != nil {
+		return nil, 0, err
+	}
+
+	return frame, priority, nil
+}
+
+// DecodePooled is like Decode but the frame is allocated from the built-in byte slice pool,
+// the caller must call done to recycle the frame once it has finished with the frame.
+func (cc *LengthFieldBasedFrameCodec) DecodePooled(c Conn) (frame []byte, done func(), err error) {
+	in, msgLength, err := cc.peekFrame(c)
+	if in == nil {
+		return nil, nil, err
+	}
+
+	if len(in) == 0 {
+		frame, done = []byte{}, func() {}
+	} else {
+		frame = bsPool.Get(len(in))
+		copy(frame, in)
+		done = func() { bsPool.Put(frame) }
+	}
+	if err = cc.DiscardFrame(c, msgLength); err != nil {
+		done()
+		return nil, nil, err
+	}
+
+	return
+}
+
+// peekFrame peeks the next complete frame without consuming it, it returns the decoded frame borrowed
+// from the inbound buffer and the length of the whole message to be discarded, or nil frame if the
+// frame is incomplete. A message failing the checksum is discarded, msgLength is returned along with
+// the error in that case.
+func (cc *LengthFieldBasedFrameCodec) peekFrame(c Conn) (frame []byte, msgLength int, err error) {
+	msg, strip, payloadEnd, msgLength, err := cc.peekMessage(c)
+	if msg == nil {
+		return nil, msgLength, err
+	}
+	return msg[strip:payloadEnd], msgLength, nil
+}
+
+// peekMessage is like peekFrame but it returns the whole message borrowed from the inbound buffer,
+// in which the decoded frame ranges from strip to payloadEnd.
+func (cc *LengthFieldBasedFrameCodec) peekMessage(c Conn) (msg []byte, strip, payloadEnd, msgLength int, err error) {
+	if cc.decoderConfig.SkipInterFrameByte {
+		cc.skipInterFrameBytes(c)
+	}
+	if cc.byteOrderCodecs != nil {
+		variant, err := cc.byteOrderCodec(c)
+		if variant == nil {
+			return nil, 0, 0, 0, err
+		}
+		return variant.peekMessage(c)
+	}
+	header, strip, payloadEnd, msgLength, err := cc.peekFrameHeader(c)
+	if header == nil {
+		return nil, 0, 0, 0, err
+	}
+	headerLength := len(header)
+	trailer := msgLength - payloadEnd
+
+	in, err := c.Peek(msgLength)
 	if err != nil || len(in) < msgLength {
+		return nil, 0, 0, 0, err
+	}
+	if cc.decoderConfig.OnHeader != nil {
+		c.SetCodecScratch(headerNotifiedKey{cc.owner()}, false)
+	}
+
+	var checksum uint32
+	switch cc.decoderConfig.CRCScope {
+	case CRCPayloadOnly:
+		checksum = crc32.ChecksumIEEE(in[headerLength:payloadEnd])
+	case CRCIncludeHeader:
+		checksum = crc32.ChecksumIEEE(in[:payloadEnd])
+	}
+	if trailer > 0 && checksum != cc.decoderConfig.ByteOrder.Uint32(in[payloadEnd:msgLength]) {
+		c.Discard(msgLength)
+		return nil, 0, 0, msgLength, errors.ErrInvalidChecksum
+	}
+	if cc.decoderConfig.ValidateUTF8 && !utf8.Valid(in[headerLength:payloadEnd]) {
+		c.Discard(msgLength)
+		return nil, 0, 0, msgLength, errors.ErrInvalidUTF8
+	}
+	return in[:msgLength], strip, payloadEnd, msgLength, nil
+}
+
+// peekFrameHeader peeks the header of the next frame and checks it against MaxFrameLength and the bytes to strip,
+// calling DecoderConfig.OnHeader once it passes, it's shared by peekMessage and DecodeStream. It returns the header
+// along with the range of the decoded frame and the length of the whole message, or nil header if the header is
+// incomplete.
+func (cc *LengthFieldBasedFrameCodec) peekFrameHeader(c Conn) (header []byte, strip, payloadEnd, msgLength int, err error) {
+	header, msgLength, err = cc.peekHeader(c)
+	if header == nil {
+		return nil, 0, 0, 0, err
+	}
+	headerLength := len(header)
+	trailer := cc.trailerLength()
+	if maxFrameLength := cc.maxFrameLength(); msgLength > maxFrameLength {
+		if onFrameTooLarge := cc.decoderConfig.OnFrameTooLarge; onFrameTooLarge != nil {
+			onFrameTooLarge(c, msgLength)
+		}
+		return nil, 0, 0, 0, &errors.FrameTooLargeError{Length: int64(msgLength), Max: int64(maxFrameLength)}
+	}
+	// A message of no bytes at all, which only a header of no bytes makes, would be decoded over and over
+	// without consuming anything.
+	if msgLength == 0 {
+		return nil, 0, 0, 0, fmt.Errorf("%w: no header", errors.ErrUnsupportedLength)
+	}
+
+	payloadEnd = msgLength - trailer
+	if strip, err = cc.bytesToStrip(headerLength, payloadEnd); err != nil {
+		return nil, 0, 0, 0, err
+	}
+	if cc.decoderConfig.OnHeader != nil {
+		cc.notifyHeader(c, header)
+	}
+	return header, strip, payloadEnd, msgLength, nil
+}
+
+// sequenceKey is the key of the next expected sequence number stored by Conn.SetCodecScratch.
+type sequenceKey struct {
+	cc *LengthFieldBasedFrameCodec
+}
+
+// trackSequence checks the sequence number of the frame msg against the expected one of c.
+func (cc *LengthFieldBasedFrameCodec) trackSequence(c Conn, msg []byte) error {
+	field := cc.decoderConfig.SequenceField
+	if field.Offset+field.Length > len(msg) {
+		return fmt.Errorf("%w: no room for the sequence number", errors.ErrShortFrame)
+	}
+	byteOrder := field.ByteOrder
+	if byteOrder == nil {
+		byteOrder = cc.decoderConfig.ByteOrder
+	}
+	v, err := readUint(byteOrder, msg[field.Offset:], field.Length)
+	if err != nil {
+		return err
+	}
+	got, mask := uint32(v), uint32(uint64(1)<<(8*field.Length)-1)
+	key := sequenceKey{cc.owner()}
+	if expected, ok := c.CodecScratch(key).(uint32); ok {
+		// The distance ahead of the expected one in the serial number arithmetic of the field width.
+		ahead := (got - expected) & mask
+		if ahead > mask/2 {
+			return nil
+		}
+		if ahead > 0 && cc.decoderConfig.OnSequenceGap != nil {
+			cc.decoderConfig.OnSequenceGap(c, expected, got)
+		}
+	}
+	c.SetCodecScratch(key, (got+1)&mask)
+	return nil
+}
+
+// peekHeader peeks the header of the next frame, which is made up of the bytes through the length field and
+// the InterHeaderSkip bytes, it returns the header and the length of the whole frame including the trailer,
+// or nil header if the header is incomplete or rejected by VerifyInterHeader.
+func (cc *LengthFieldBasedFrameCodec) peekHeader(c Conn) (header []byte, msgLength int, err error) {
+	if cc.decoderConfig.SkipInterFrameByte {
+		cc.skipInterFrameBytes(c)
+	}
+	if cc.byteOrderCodecs != nil {
+		variant, err := cc.byteOrderCodec(c)
+		if variant == nil {
+			return nil, 0, err
+		}
+		return variant.peekHeader(c)
+	}
+	fieldLength, ok, err := cc.lengthFieldLength(c)
+	if !ok {
+		return nil, 0, err
+	}
+	lengthFieldEndOffset := cc.decoderConfig.LengthFieldOffset + fieldLength
+	headerLength := lengthFieldEndOffset + cc.decoderConfig.InterHeaderSkip
+	peekLength := headerLength
+	adjField := cc.decoderConfig.AdjustmentField
+	if end := adjField.Offset + adjField.Length; adjField.Length > 0 && end > peekLength {
+		peekLength = end
+	}
+	header, err = c.Peek(peekLength)
+	if err != nil || len(header) < peekLength {
+		return nil, 0, err
+	}
+	var adjustment uint64
+	if adjField.Length > 0 {
+		byteOrder := adjField.ByteOrder
+		if byteOrder == nil {
+			byteOrder = cc.decoderConfig.ByteOrder
+		}
+		if adjustment, err = readUint(byteOrder, header[adjField.Offset:], adjField.Length); err != nil {
+			return nil, 0, err
+		}
+	}
+	header = header[:headerLength]
+	if verify := cc.decoderConfig.VerifyInterHeader; verify != nil {
+		if err = verify(header[:lengthFieldEndOffset], header[lengthFieldEndOffset:]); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	var trailingFields int
+	if trailerLength := cc.decoderConfig.TrailerLength; trailerLength != nil {
+		if trailingFields, err = trailerLength(header); err != nil {
+			return nil, 0, err
+		}
+		if trailingFields < 0 {
+			return nil, 0, fmt.Errorf("%w: negative trailer length %d", errors.ErrMalformedFrame, trailingFields)
+		}
+	}
+
+	var frameLength int64
+	if parse := cc.decoderConfig.LengthParser; parse != nil {
+		var length int
+		if length, err = parse(header[cc.decoderConfig.LengthFieldOffset:lengthFieldEndOffset]); err != nil {
+			return nil, 0, err
+		}
+		if length < 0 {
+			return nil, 0, fmt.Errorf("%w: negative length %d", errors.ErrInvalidFrameLength, length)
+		}
+		frameLength = int64(length)
+	} else if fieldLength != cc.decoderConfig.LengthFieldLength {
+		return nil, 0, fmt.Errorf("%w: extended length field without LengthParser", errors.ErrUnsupportedLength)
+	} else {
+		value := cc.getFrameLength(header[cc.decoderConfig.LengthFieldOffset:])
+		if cc.decoderConfig.LengthDelta {
+			if frameLength, err = cc.undeltaLength(c, value); err != nil {
+				return nil, 0, err
+			}
+		} else if value > math.MaxInt64 {
+			return nil, 0, fmt.Errorf("%w: length %d overflows", errors.ErrInvalidFrameLength, value)
+		} else {
+			frameLength = int64(value)
+		}
+	}
+	if unit := int64(cc.decoderConfig.LengthFieldUnit); unit > 1 {
+		if frameLength > math.MaxInt64/unit {
+			return nil, 0, fmt.Errorf("%w: length %d in %d-byte units overflows", errors.ErrInvalidFrameLength, frameLength, unit)
+		}
+		frameLength *= unit
+	}
+	included := 0
+	if cc.decoderConfig.LengthIncludesLengthFieldLength {
+		included += fieldLength
+	}
+	if cc.decoderConfig.LengthIncludesTrailer {
+		included += trailingFields + cc.trailerLength()
+	}
+	payloadLength, err := adjustLength(frameLength, int64(adjustment),
+		int64(cc.decoderConfig.LengthAdjustment), int64(trailingFields), int64(-included))
+	if err != nil {
+		return nil, 0, err
+	}
+	if payloadLength < 0 {
+		return nil, 0, fmt.Errorf("%w: length %d adjusted to %d", errors.ErrInvalidFrameLength, frameLength, payloadLength)
+	}
+	// real message length
+	if payloadLength > int64(math.MaxInt-headerLength-cc.trailerLength()) {
+		return nil, 0, fmt.Errorf("%w: length %d overflows", errors.ErrInvalidFrameLength, payloadLength)
+	}
+	msgLength = headerLength + int(payloadLength) + cc.trailerLength()
+	return
+}
+
+// skipInterFrameBytes consumes the keepalive bytes of DecoderConfig.InterFrameByte ahead of the next frame.
+func (cc *LengthFieldBasedFrameCodec) skipInterFrameBytes(c Conn) {
+	for {
+		b, err := c.Peek(1)
+		if err != nil || len(b) == 0 || b[0] != cc.decoderConfig.InterFrameByte {
+			return
+		}
+		_, _ = c.Discard(1)
+	}
+}
+
+// headerNotifiedKey is the key of whether DecoderConfig.OnHeader has been called for the header
+// of the incomplete frame buffered, stored by Conn.SetCodecScratch.
+type headerNotifiedKey struct {
+	cc *LengthFieldBasedFrameCodec
+}
+
+// notifyHeader calls DecoderConfig.OnHeader with header unless it has been called for the same frame.
+func (cc *LengthFieldBasedFrameCodec) notifyHeader(c Conn, header []byte) {
+	key := headerNotifiedKey{cc.owner()}
+	if notified, _ := c.CodecScratch(key).(bool); !notified {
+		c.SetCodecScratch(key, true)
+		cc.decoderConfig.OnHeader(c, header)
+	}
+}
+
+// owner returns the codec keeping the per-connection state of cc, the variants of ByteOrderMark
+// share the state of the codec they belong to.
+func (cc *LengthFieldBasedFrameCodec) owner() *LengthFieldBasedFrameCodec {
+	if cc.base != nil {
+		return cc.base
+	}
+	return cc
+}
+
+// undeltaLength reconstructs the value of the length field of the next frame of c from its delta
+// of DecoderConfig.LengthDelta.
+func (cc *LengthFieldBasedFrameCodec) undeltaLength(c Conn, value uint64) (int64, error) {
+	prev, ok := c.CodecScratch(lengthDeltaKey{cc: cc.owner()}).(int64)
+	if !ok {
+		// the first frame carries the value itself.
+		if value > math.MaxInt64 {
+			return 0, fmt.Errorf("%w: length %d overflows", errors.ErrInvalidFrameLength, value)
+		}
+		return int64(value), nil
+	}
+	// the delta in two's complement of the length field.
+	delta := int64(value)
+	if bits := uint(8 * cc.decoderConfig.LengthFieldLength); bits > 0 && bits < 64 && value >= 1<<(bits-1) {
+		delta -= 1 << bits
+	}
+	if prev+delta < 0 || (delta > 0 && prev > math.MaxInt64-delta) {
+		return 0, fmt.Errorf("%w: length %d with delta %d", errors.ErrInvalidFrameLength, prev, delta)
+	}
+	return prev + delta, nil
+}
+
+// trackLengthDelta keeps the value of the length field of the decoded frame msg as the previous one of c.
+func (cc *LengthFieldBasedFrameCodec) trackLengthDelta(c Conn, msg []byte) {
+	if cc.decoderConfig.LengthParser != nil {
+		return
+	}
+	length, _ := cc.undeltaLength(c, cc.getFrameLength(msg[cc.decoderConfig.LengthFieldOffset:]))
+	c.SetCodecScratch(lengthDeltaKey{cc: cc.owner()}, length)
+}
+
+// byteOrderCodec returns the variant of byteOrderCodecs selected by the byte order mark of the next frame,
+// or nil if the byte order mark is incomplete.
+func (cc *LengthFieldBasedFrameCodec) byteOrderCodec(c Conn) (*LengthFieldBasedFrameCodec, error) {
+	mark, err := c.Peek(byteOrderMarkLength)
+	if err != nil || len(mark) < byteOrderMarkLength {
 		return nil, err
 	}
+	switch {
+	case mark[0] == 0xFE && mark[1] == 0xFF:
+		return cc.byteOrderCodecs[0], nil
+	case mark[0] == 0xFF && mark[1] == 0xFE:
+		return cc.byteOrderCodecs[1], nil
+	}
+	return nil, fmt.Errorf("%w: byte order mark %#x", errors.ErrMalformedFrame, mark)
+}
 
-	fullMessage := make([]byte, int(frameLength))
-	copy(fullMessage, in[cc.decoderConfig.LengthFieldLength:])
-	c.Discard(msgLength)
+// frameByteOrder returns the ByteOrder of the frame msg, which is selected by its byte order mark
+// if DecoderConfig.ByteOrderMark is set.
+func (cc *LengthFieldBasedFrameCodec) frameByteOrder(msg []byte) binary.ByteOrder {
+	if !cc.decoderConfig.ByteOrderMark {
+		return cc.decoderConfig.ByteOrder
+	}
+	if msg[0] == 0xFF {
+		return binary.LittleEndian
+	}
+	return binary.BigEndian
+}
 
-	return fullMessage, nil
+// lengthFieldLength returns the length of the length field of the next frame, which is LengthFieldLength unless
+// it's extended by LengthFieldExtension, ok is false if the leading bytes of the length field are incomplete.
+func (cc *LengthFieldBasedFrameCodec) lengthFieldLength(c Conn) (fieldLength int, ok bool, err error) {
+	fieldLength = cc.decoderConfig.LengthFieldLength
+	extend := cc.decoderConfig.LengthFieldExtension
+	if extend == nil {
+		return fieldLength, true, nil
+	}
+	leadingEnd := cc.decoderConfig.LengthFieldOffset + fieldLength
+	leading, err := c.Peek(leadingEnd)
+	if err != nil || len(leading) < leadingEnd {
+		return 0, false, err
+	}
+	extension, err := extend(leading[cc.decoderConfig.LengthFieldOffset:])
+	if err != nil {
+		return 0, false, err
+	}
+	if extension < 0 {
+		return 0, false, fmt.Errorf("%w: negative length field extension %d", errors.ErrMalformedFrame, extension)
+	}
+	return fieldLength + extension, true, nil
+}
+
+// adjustLength adds the adjustments to length, failing rather than wrapping around on overflow.
+func adjustLength(length int64, deltas ...int64) (int64, error) {
+	for _, delta := range deltas {
+		if (delta > 0 && length > math.MaxInt64-delta) || (delta < 0 && length < math.MinInt64-delta) {
+			return 0, fmt.Errorf("%w: length %d adjusted by %d overflows", errors.ErrMalformedFrame, length, delta)
+		}
+		length += delta
+	}
+	return length, nil
 }
 
-func (cc *LengthFieldBasedFrameCodec) getFrameLength(in []byte) uint32 {
+// bytesToStrip resolves InitialBytesToStrip against a frame whose payload ends at payloadEnd.
+func (cc *LengthFieldBasedFrameCodec) bytesToStrip(headerLength, payloadEnd int) (int, error) {
+	strip := cc.decoderConfig.InitialBytesToStrip
+	switch {
+	case strip == 0:
+		strip = headerLength
+	case strip == StripNone:
+		strip = 0
+	case strip < 0 || strip > payloadEnd:
+		return 0, fmt.Errorf("%w: %d bytes to strip from a %d-byte frame",
+			errors.ErrTooManyBytesToStrip, strip, payloadEnd)
+	}
+	if cc.decoderConfig.ByteOrderMark && strip < byteOrderMarkLength && payloadEnd >= byteOrderMarkLength {
+		strip = byteOrderMarkLength
+	}
+	return strip, nil
+}
+
+// defaultMaxFrameLength is the default of DecoderConfig.MaxFrameLength.
+const defaultMaxFrameLength = 10485760
+
+func (cc *LengthFieldBasedFrameCodec) maxFrameLength() int {
+	if cc.decoderConfig.MaxFrameLength > 0 {
+		return cc.decoderConfig.MaxFrameLength
+	}
+	return defaultMaxFrameLength
+}
+
+func (cc *LengthFieldBasedFrameCodec) trailerLength() int {
+	if cc.decoderConfig.CRCScope != CRCNone {
+		return crcLength
+	}
+	return 0
+}
+
+func (cc *LengthFieldBasedFrameCodec) getFrameLength(in []byte) uint64 {
 	switch cc.decoderConfig.LengthFieldLength {
 	case 1:
-		return uint32(in[0])
+		return uint64(in[0])
+	case 2:
+		return uint64(cc.decoderConfig.ByteOrder.Uint16(in))
+	case 3:
+		return readUint24(cc.decoderConfig.ByteOrder, in)
+	case 4:
+		return uint64(cc.decoderConfig.ByteOrder.Uint32(in))
+	case 8:
+		return cc.decoderConfig.ByteOrder.Uint64(in)
+	}
+	return uint64(cc.decoderConfig.ByteOrder.Uint32(in))
+}
+
+// Float32LengthParser returns a DecoderConfig.LengthParser for the 4-byte length fields holding the number of bytes
+// as an IEEE-754 float32 in byteOrder, the value is rounded to the nearest integer, while NaN and negative values
+// are rejected with errors.ErrMalformedFrame, and the values beyond DecoderConfig.MaxFrameLength, infinities
+// included, with errors.ErrFrameTooLarge. For instance, the frames made up of a big-endian float32 length followed
+// by the payload are decoded with:
+//
+//	NewLengthFieldBasedFrameCodec(EncoderConfig{}, DecoderConfig{
+//		ByteOrder:         binary.BigEndian,
+//		LengthFieldLength: 4,
+//		LengthParser:      Float32LengthParser(binary.BigEndian),
+//	})
+func Float32LengthParser(byteOrder binary.ByteOrder) func(lengthField []byte) (int, error) {
+	return func(lengthField []byte) (int, error) {
+		if len(lengthField) != 4 {
+			return 0, fmt.Errorf("%w: %d for a float32 length", errors.ErrUnsupportedLength, len(lengthField))
+		}
+		length := float64(math.Float32frombits(byteOrder.Uint32(lengthField)))
+		if math.IsNaN(length) || length < 0 {
+			return 0, fmt.Errorf("%w: float32 length %v", errors.ErrMalformedFrame, length)
+		}
+		if length >= math.MaxInt {
+			return 0, fmt.Errorf("%w: float32 length %v overflows", errors.ErrFrameTooLarge, length)
+		}
+		return int(math.Round(length)), nil
+	}
+}
+
+// EvenParityLengthParser returns a DecoderConfig.LengthParser for the length fields of 1 to 4 bytes in byteOrder whose
+// highest bit is the even parity bit over the other bits, i.e. the number of the set bits of the whole field is even.
+// The length is the value of the other bits, and a parity mismatch rejects the frame with errors.ErrBadLengthParity.
+func EvenParityLengthParser(byteOrder binary.ByteOrder) func(lengthField []byte) (int, error) {
+	return func(lengthField []byte) (int, error) {
+		v, err := readUint(byteOrder, lengthField, len(lengthField))
+		if err != nil {
+			return 0, err
+		}
+		if bits.OnesCount64(v)%2 != 0 {
+			return 0, fmt.Errorf("%w: length field %#x", errors.ErrBadLengthParity, v)
+		}
+		return int(v &^ (1 << (8*len(lengthField) - 1))), nil
+	}
+}
+
+// ASCIILengthParser returns a DecoderConfig.LengthParser for the length fields holding the number of bytes in ASCII
+// decimal digits, which may be padded with leading zeros and with leading or trailing spaces, "0042", "  42" and
+// "42  " are all 42 for instance. A field of no digits or of any other byte is rejected with errors.ErrMalformedFrame,
+// and a value beyond DecoderConfig.MaxFrameLength with errors.ErrFrameTooLarge. For instance, the frames made up of
+// a 4-digit length followed by the payload, whose lengths are padded with spaces, are coded with:
+//
+//	NewLengthFieldBasedFrameCodec(EncoderConfig{
+//		LengthFieldLength: 4,
+//		LengthFormatter:   ASCIILengthFormatter(' '),
+//	}, DecoderConfig{
+//		LengthFieldLength: 4,
+//		LengthParser:      ASCIILengthParser(),
+//	})
+func ASCIILengthParser() func(lengthField []byte) (int, error) {
+	return func(lengthField []byte) (int, error) {
+		digits := bytes.Trim(lengthField, " ")
+		if len(digits) == 0 {
+			return 0, fmt.Errorf("%w: ASCII length %q of no digits", errors.ErrMalformedFrame, lengthField)
+		}
+		length := 0
+		for _, d := range digits {
+			if d < '0' || d > '9' {
+				return 0, fmt.Errorf("%w: ASCII length %q", errors.ErrMalformedFrame, lengthField)
+			}
+			if length > (math.MaxInt-int(d-'0'))/10 {
+				return 0, fmt.Errorf("%w: ASCII length %q overflows", errors.ErrFrameTooLarge, lengthField)
+			}
+			length = length*10 + int(d-'0')
+		}
+		return length, nil
+	}
+}
+
+// ASCIILengthFormatter returns an EncoderConfig.LengthFormatter writing the length in ASCII decimal digits,
+// right-aligned and padded to the width of the length field with padding, which is either '0' or ' ' to be
+// parsed by ASCIILengthParser. A length of more digits than the width of the length field fails the encoding.
+func ASCIILengthFormatter(padding byte) func(lengthField []byte, length int) error {
+	return func(lengthField []byte, length int) error {
+		digits := strconv.Itoa(length)
+		if length < 0 || len(digits) > len(lengthField) {
+			return fmt.Errorf("%w: %d into %d ASCII digits", errors.ErrEncodeLengthOverflow, length, len(lengthField))
+		}
+		pad := len(lengthField) - len(digits)
+		for i := 0; i < pad; i++ {
+			lengthField[i] = padding
+		}
+		copy(lengthField[pad:], digits)
+		return nil
+	}
+}
+
+// BCDLengthParser returns a DecoderConfig.LengthParser for the length fields holding the number of bytes in packed
+// BCD, where each nibble is a decimal digit with the most significant one first, the 2-byte length field 0x12 0x34
+// is 1234 for instance. A field of any nibble beyond 9 is rejected with errors.ErrMalformedFrame, and a value beyond
+// DecoderConfig.MaxFrameLength with errors.ErrFrameTooLarge. For instance, the frames made up of a 2-byte BCD
+// length followed by the payload are coded with:
+//
+//	NewLengthFieldBasedFrameCodec(EncoderConfig{
+//		LengthFieldLength: 2,
+//		LengthFormatter:   BCDLengthFormatter(),
+//	}, DecoderConfig{
+//		LengthFieldLength: 2,
+//		LengthParser:      BCDLengthParser(),
+//	})
+func BCDLengthParser() func(lengthField []byte) (int, error) {
+	return func(lengthField []byte) (int, error) {
+		length := 0
+		for _, b := range lengthField {
+			hi, lo := b>>4, b&0x0F
+			if hi > 9 || lo > 9 {
+				return 0, fmt.Errorf("%w: BCD length %#x", errors.ErrMalformedFrame, lengthField)
+			}
+			digits := int(hi)*10 + int(lo)
+			if length > (math.MaxInt-digits)/100 {
+				return 0, fmt.Errorf("%w: BCD length %#x overflows", errors.ErrFrameTooLarge, lengthField)
+			}
+			length = length*100 + digits
+		}
+		return length, nil
+	}
+}
+
+// BCDLengthFormatter returns an EncoderConfig.LengthFormatter writing the length in packed BCD, padded with leading
+// zero digits to the width of the length field, which holds 2 digits per byte. A length of more digits than the
+// length field holds fails the encoding.
+func BCDLengthFormatter() func(lengthField []byte, length int) error {
+	return func(lengthField []byte, length int) error {
+		if length < 0 {
+			return fmt.Errorf("%w: %d into %d BCD bytes", errors.ErrEncodeLengthOverflow, length, len(lengthField))
+		}
+		v := length
+		for i := len(lengthField) - 1; i >= 0; i-- {
+			lengthField[i] = byte(v%100/10<<4 | v%10)
+			v /= 100
+		}
+		if v != 0 {
+			return fmt.Errorf("%w: %d into %d BCD bytes", errors.ErrEncodeLengthOverflow, length, len(lengthField))
+		}
+		return nil
+	}
+}
+
+// readUint reads an unsigned integer of length bytes from b with byteOrder.
+func readUint(byteOrder binary.ByteOrder, b []byte, length int) (uint64, error) {
+	switch length {
+	case 1:
+		return uint64(b[0]), nil
 	case 2:
-		return uint32(cc.decoderConfig.ByteOrder.Uint16(in))
+		return uint64(byteOrder.Uint16(b)), nil
 	case 3:
-		return uint32(readUint24(cc.decoderConfig.ByteOrder, in))
+		return readUint24(byteOrder, b), nil
 	case 4:
-		return uint32(cc.decoderConfig.ByteOrder.Uint32(in))
+		return uint64(byteOrder.Uint32(b)), nil
 	}
-	return uint32(cc.decoderConfig.ByteOrder.Uint32(in))
+	return 0, fmt.Errorf("%w: %d", errors.ErrUnsupportedLength, length)
 }
 
 func readUint24(byteOrder binary.ByteOrder, b []byte) uint64 {
@@ -0,0 +1,148 @@
+// Copyright (c) 2019 Andy Pan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gnet
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+
+	"github.com/walkon/wsgnet/pkg/errors"
+)
+
+const (
+	// aeadFrameData is the type of the frame carrying the encrypted payload.
+	aeadFrameData = 0
+	// aeadFrameRekey is the type of the control frame signaling the switch to the next key.
+	aeadFrameRekey = 1
+)
+
+// AEADFrameCodec encrypts the payloads of the frames of its inner codec with AES-GCM, each frame of the inner codec
+// is made up of a type byte, the nonce and the sealed payload, where the type byte is authenticated along with the
+// payload, and the nonce is random per frame.
+//
+// The key can be rotated mid-stream without reconnecting: SetEncryptionKey installs the next key agreed upon by
+// both sides, then Rekey sends a control frame sealed with the current key and switches the outbound frames to
+// the next key, the peer switches its inbound frames to the next key once it decodes the control frame, so that
+// the frames in flight are never decrypted with the wrong key. The frames to be sent after N frames or T seconds
+// are up to the caller to count or time.
+//
+// AEADFrameCodec keeps the keys of a connection, so it must not be shared between connections, instantiate one
+// per connection instead, by Conn.SetCodec() in EventHandler.OnOpen, and SetEncryptionKey and Rekey are supposed
+// to be called on the event-loop of the connection, in EventHandler.OnTraffic for instance.
+type AEADFrameCodec struct {
+	codec    ICodec
+	send     cipher.AEAD
+	recv     cipher.AEAD
+	nextSend cipher.AEAD
+	nextRecv cipher.AEAD
+}
+
+// NewAEADFrameCodec instantiates and returns an AEADFrameCodec that frames the sealed payloads with codec and
+// encrypts them with key, which is either 16, 24 or 32 bytes to select AES-128, AES-192 or AES-256.
+func NewAEADFrameCodec(codec ICodec, key []byte) (*AEADFrameCodec, error) {
+	aead, err := newAESGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	return &AEADFrameCodec{codec: codec, send: aead, recv: aead}, nil
+}
+
+// newAESGCM returns the AES-GCM of key.
+func newAESGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errors.ErrUnsupportedLength, err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// SetEncryptionKey installs key as the next key, which takes over the outbound frames by Rekey and the inbound
+// frames by the control frame of the peer, replacing the former next key that hasn't taken over yet if any.
+func (cc *AEADFrameCodec) SetEncryptionKey(key []byte) error {
+	aead, err := newAESGCM(key)
+	if err != nil {
+		return err
+	}
+	cc.nextSend, cc.nextRecv = aead, aead
+	return nil
+}
+
+// Rekey writes the control frame signaling the switch to the next key to c and switches the outbound frames
+// to the next key, which must have been installed by SetEncryptionKey.
+func (cc *AEADFrameCodec) Rekey(c Conn) error {
+	if cc.nextSend == nil {
+		return fmt.Errorf("%w: no next key to rekey with", errors.ErrUnsupportedOp)
+	}
+	out, err := cc.codec.Encode(c, cc.seal(aeadFrameRekey, nil))
+	if err != nil {
+		return err
+	}
+	if _, err = c.Write(out); err != nil {
+		return err
+	}
+	cc.send, cc.nextSend = cc.nextSend, nil
+	return nil
+}
+
+// seal seals payload into a frame of typ with the current outbound key.
+func (cc *AEADFrameCodec) seal(typ byte, payload []byte) []byte {
+	nonceSize := cc.send.NonceSize()
+	frame := make([]byte, 1+nonceSize, 1+nonceSize+len(payload)+cc.send.Overhead())
+	frame[0] = typ
+	// crypto/rand never fails on the supported platforms.
+	_, _ = rand.Read(frame[1:])
+	return cc.send.Seal(frame, frame[1:], payload, frame[:1])
+}
+
+// Encode seals buf with the current outbound key and encodes it with the inner codec.
+func (cc *AEADFrameCodec) Encode(c Conn, buf []byte) ([]byte, error) {
+	return cc.codec.Encode(c, cc.seal(aeadFrameData, buf))
+}
+
+// Decode decodes the next frame with the inner codec and opens its payload with the current inbound key,
+// the control frames of the peer are consumed, switching the inbound frames to the next key.
+func (cc *AEADFrameCodec) Decode(c Conn) ([]byte, error) {
+	for {
+		frame, err := cc.codec.Decode(c)
+		if frame == nil || err != nil {
+			return nil, err
+		}
+		nonceSize := cc.recv.NonceSize()
+		if len(frame) < 1+nonceSize+cc.recv.Overhead() {
+			return nil, fmt.Errorf("%w: %d-byte sealed frame", errors.ErrShortFrame, len(frame))
+		}
+		typ := frame[0]
+		payload, err := cc.recv.Open(frame[1+nonceSize:1+nonceSize], frame[1:1+nonceSize], frame[1+nonceSize:], frame[:1])
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", errors.ErrMalformedFrame, err)
+		}
+		switch typ {
+		case aeadFrameData:
+			if payload == nil {
+				payload = []byte{}
+			}
+			return payload, nil
+		case aeadFrameRekey:
+			if cc.nextRecv == nil {
+				return nil, fmt.Errorf("%w: rekey without the next key", errors.ErrMalformedFrame)
+			}
+			cc.recv, cc.nextRecv = cc.nextRecv, nil
+		default:
+			return nil, fmt.Errorf("%w: unknown sealed frame type %d", errors.ErrMalformedFrame, typ)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright (c) 2019 Andy Pan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gnet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	gerr "github.com/walkon/wsgnet/pkg/errors"
+)
+
+func newTestAEADFrameCodec(t *testing.T, key []byte) *AEADFrameCodec {
+	codec, err := NewAEADFrameCodec(NewLengthFieldBasedFrameCodec(
+		EncoderConfig{ByteOrder: binary.BigEndian, LengthFieldLength: 2},
+		DecoderConfig{ByteOrder: binary.BigEndian, LengthFieldLength: 2, InitialBytesToStrip: 2},
+	), key)
+	require.NoError(t, err)
+	return codec
+}
+
+func TestAEADFrameCodec(t *testing.T) {
+	key, nextKey := bytes.Repeat([]byte{0x01}, 16), bytes.Repeat([]byte{0x02}, 32)
+	sender, receiver := newTestAEADFrameCodec(t, key), newTestAEADFrameCodec(t, key)
+	client, server := &mockConn{}, &mockConn{}
+
+	out, err := sender.Encode(client, []byte("before"))
+	require.NoError(t, err)
+	assert.NotContains(t, string(out), "before")
+	client.feed(out)
+
+	// the key is rotated mid-stream.
+	assert.ErrorIs(t, sender.Rekey(client), gerr.ErrUnsupportedOp)
+	require.NoError(t, sender.SetEncryptionKey(nextKey))
+	require.NoError(t, receiver.SetEncryptionKey(nextKey))
+	require.NoError(t, sender.Rekey(client))
+	client.feed(client.outbound)
+	out, err = sender.Encode(client, []byte("after"))
+	require.NoError(t, err)
+	client.feed(out)
+	out, err = sender.Encode(client, nil)
+	require.NoError(t, err)
+	client.feed(out)
+
+	server.inbound = client.inbound
+	for _, want := range []string{"before", "after", ""} {
+		frame, err := receiver.Decode(server)
+		require.NoError(t, err)
+		assert.NotNil(t, frame)
+		assert.Equal(t, want, string(frame))
+	}
+	assert.Zero(t, server.InboundBuffered())
+
+	// the frames sealed with the former key are rejected.
+	stale := newTestAEADFrameCodec(t, key)
+	out, err = stale.Encode(client, []byte("stale"))
+	require.NoError(t, err)
+	server.feed(out)
+	_, err = receiver.Decode(server)
+	assert.ErrorIs(t, err, gerr.ErrMalformedFrame)
+
+	// a control frame without the next key installed.
+	server = &mockConn{}
+	client = &mockConn{}
+	require.NoError(t, stale.SetEncryptionKey(nextKey))
+	require.NoError(t, stale.Rekey(client))
+	server.feed(client.outbound)
+	_, err = newTestAEADFrameCodec(t, key).Decode(server)
+	assert.ErrorIs(t, err, gerr.ErrMalformedFrame)
+
+	_, err = NewAEADFrameCodec(nil, []byte("short"))
+	assert.ErrorIs(t, err, gerr.ErrUnsupportedLength)
+}
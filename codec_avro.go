@@ -0,0 +1,229 @@
+// Copyright (c) 2019 Andy Pan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gnet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/walkon/wsgnet/pkg/errors"
+)
+
+const (
+	// AvroOCFSyncLength is the length of the sync marker of an Avro Object Container File.
+	AvroOCFSyncLength = 16
+
+	// avroOCFMaxHeaderLength is the limit of the length of the file header.
+	avroOCFMaxHeaderLength = 1048576
+)
+
+// avroOCFMagic starts an Avro Object Container File.
+var avroOCFMagic = []byte{'O', 'b', 'j', 1}
+
+// AvroOCFBlock is a data block of an Avro Object Container File.
+type AvroOCFBlock struct {
+	// Count is the number of objects in the block.
+	Count int64
+	// Data is the serialized objects, compressed by the codec named by the "avro.codec" metadata if any.
+	Data []byte
+}
+
+// AvroOCFCodec decodes an Avro Object Container File streamed over a connection, it consumes the file header,
+// [4-byte magic][metadata map][16-byte sync marker], at first, and then decodes the data blocks, each of which
+// is [long count][long size][data][16-byte sync marker], where the longs are zigzag varints and the sync marker
+// must be the one of the header. A file header going beyond 1MB is rejected by errors.ErrFrameTooLarge.
+//
+// AvroOCFCodec keeps the metadata and the sync marker of the file, along with the progress of parsing the header,
+// so it must not be shared between connections, instantiate one per connection instead, by Conn.SetCodec() in
+// EventHandler.OnOpen for instance.
+type AvroOCFCodec struct {
+	headerDone bool
+	metadata   map[string][]byte
+	sync       [AvroOCFSyncLength]byte
+
+	// headerOffset is the number of the buffered bytes of the header that have been parsed, into partial,
+	// and pendingEntries is the number of the entries left in the current block of the metadata map.
+	headerOffset   int
+	pendingEntries int64
+	partial        map[string][]byte
+}
+
+// NewAvroOCFCodec instantiates and returns an AvroOCFCodec.
+func NewAvroOCFCodec() *AvroOCFCodec {
+	return new(AvroOCFCodec)
+}
+
+// Metadata returns the metadata of the file header, such as "avro.schema" and "avro.codec",
+// it is nil until the header has been decoded.
+func (cc *AvroOCFCodec) Metadata() map[string][]byte {
+	return cc.metadata
+}
+
+// Encode is not supported since a block can't be made up without its object count.
+func (cc *AvroOCFCodec) Encode(_ Conn, _ []byte) ([]byte, error) {
+	return nil, errors.ErrUnsupportedOp
+}
+
+// Decode decodes the data of the next complete block, see DecodeBlock.
+func (cc *AvroOCFCodec) Decode(c Conn) ([]byte, error) {
+	block, err := cc.DecodeBlock(c)
+	if block == nil {
+		return nil, err
+	}
+	return block.Data, nil
+}
+
+// DecodeBlock consumes the file header if it has not been decoded yet, and then decodes the next
+// complete block, it returns nil block and nil error when more bytes are required.
+func (cc *AvroOCFCodec) DecodeBlock(c Conn) (*AvroOCFBlock, error) {
+	if !cc.headerDone {
+		if err := cc.decodeHeader(c); err != nil || !cc.headerDone {
+			return nil, err
+		}
+	}
+
+	in, _ := c.Peek(c.InboundBuffered())
+	count, n, err := readAvroLong(in)
+	if n == 0 || err != nil {
+		return nil, err
+	}
+	if count < 0 {
+		return nil, fmt.Errorf("%w: Avro block count %d", errors.ErrMalformedFrame, count)
+	}
+	size, m, err := readAvroLong(in[n:])
+	if m == 0 || err != nil {
+		return nil, err
+	}
+	if size < 0 {
+		return nil, fmt.Errorf("%w: Avro block size %d", errors.ErrMalformedFrame, size)
+	}
+	if size > defaultMaxFrameLength {
+		return nil, fmt.Errorf("%w: Avro block size %d beyond %d", errors.ErrFrameTooLarge, size, defaultMaxFrameLength)
+	}
+	dataStart := n + m
+	dataEnd := dataStart + int(size)
+	if len(in) < dataEnd+AvroOCFSyncLength {
+		return nil, nil
+	}
+	if !bytes.Equal(in[dataEnd:dataEnd+AvroOCFSyncLength], cc.sync[:]) {
+		return nil, fmt.Errorf("%w: Avro sync marker mismatch", errors.ErrMalformedFrame)
+	}
+
+	block := &AvroOCFBlock{Count: count, Data: make([]byte, size)}
+	copy(block.Data, in[dataStart:dataEnd])
+	_, _ = c.Discard(dataEnd + AvroOCFSyncLength)
+	return block, nil
+}
+
+// decodeHeader parses the file header as it arrives and consumes it once it's complete, the parsed part is
+// tracked by headerOffset rather than parsed again on the next call.
+func (cc *AvroOCFCodec) decodeHeader(c Conn) error {
+	n := c.InboundBuffered()
+	if n > avroOCFMaxHeaderLength {
+		n = avroOCFMaxHeaderLength
+	}
+	in, _ := c.Peek(n)
+	// incomplete reports a header not fully buffered, which can't be completed within the limit.
+	incomplete := func(err error) error {
+		if err == nil && len(in) >= avroOCFMaxHeaderLength {
+			err = fmt.Errorf("%w: Avro file header beyond %d bytes", errors.ErrFrameTooLarge, avroOCFMaxHeaderLength)
+		}
+		return err
+	}
+	if cc.headerOffset == 0 {
+		if len(in) < len(avroOCFMagic) {
+			return nil
+		}
+		if !bytes.Equal(in[:len(avroOCFMagic)], avroOCFMagic) {
+			return fmt.Errorf("%w: not an Avro Object Container File", errors.ErrMalformedFrame)
+		}
+		cc.headerOffset = len(avroOCFMagic)
+		cc.partial = make(map[string][]byte)
+	}
+
+	for {
+		off := cc.headerOffset
+		if cc.pendingEntries == 0 {
+			count, n, err := readAvroLong(in[off:])
+			if n == 0 || err != nil {
+				return incomplete(err)
+			}
+			off += n
+			if count == 0 {
+				if len(in) < off+AvroOCFSyncLength {
+					return incomplete(nil)
+				}
+				copy(cc.sync[:], in[off:])
+				cc.metadata, cc.partial = cc.partial, nil
+				cc.headerDone = true
+				_, _ = c.Discard(off + AvroOCFSyncLength)
+				return nil
+			}
+			// A negative count is followed by the size of the block of the map entries.
+			if count < 0 {
+				if count = -count; count < 0 {
+					return fmt.Errorf("%w: Avro map block count overflows", errors.ErrMalformedFrame)
+				}
+				if _, n, err = readAvroLong(in[off:]); n == 0 || err != nil {
+					return incomplete(err)
+				}
+				off += n
+			}
+			cc.headerOffset, cc.pendingEntries = off, count
+		}
+
+		key, n, err := readAvroBytes(in[off:])
+		if n == 0 || err != nil {
+			return incomplete(err)
+		}
+		off += n
+		value, n, err := readAvroBytes(in[off:])
+		if n == 0 || err != nil {
+			return incomplete(err)
+		}
+		off += n
+		cc.partial[string(key)] = append([]byte(nil), value...)
+		cc.headerOffset = off
+		cc.pendingEntries--
+	}
+}
+
+// readAvroLong reads a zigzag varint from b, n is zero when b doesn't hold the whole varint.
+func readAvroLong(b []byte) (v int64, n int, err error) {
+	if v, n = binary.Varint(b); n < 0 {
+		return 0, 0, fmt.Errorf("%w: Avro long overflows 64 bits", errors.ErrMalformedFrame)
+	}
+	return
+}
+
+// readAvroBytes reads a long length followed by as many bytes from b, n is zero when b doesn't hold all of them.
+func readAvroBytes(b []byte) (v []byte, n int, err error) {
+	length, n, err := readAvroLong(b)
+	if n == 0 || err != nil {
+		return nil, 0, err
+	}
+	if length < 0 {
+		return nil, 0, fmt.Errorf("%w: Avro bytes length %d", errors.ErrMalformedFrame, length)
+	}
+	if length > defaultMaxFrameLength {
+		return nil, 0, fmt.Errorf("%w: Avro bytes length %d beyond %d",
+			errors.ErrFrameTooLarge, length, defaultMaxFrameLength)
+	}
+	if len(b) < n+int(length) {
+		return nil, 0, nil
+	}
+	return b[n : n+int(length)], n + int(length), nil
+}
@@ -0,0 +1,91 @@
+// Copyright (c) 2019 Andy Pan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gnet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	gerr "github.com/walkon/wsgnet/pkg/errors"
+)
+
+func appendAvroBytes(b, v []byte) []byte {
+	b = binary.AppendVarint(b, int64(len(v)))
+	return append(b, v...)
+}
+
+func TestAvroOCFCodec(t *testing.T) {
+	sync := bytes.Repeat([]byte{0xA5}, AvroOCFSyncLength)
+	schema := []byte(`{"type":"string"}`)
+	file := binary.AppendVarint([]byte("Obj\x01"), 2)
+	file = appendAvroBytes(file, []byte("avro.schema"))
+	file = appendAvroBytes(file, schema)
+	file = appendAvroBytes(file, []byte("avro.codec"))
+	file = appendAvroBytes(file, []byte("null"))
+	file = append(file, 0)
+	file = append(file, sync...)
+	blocks := []*AvroOCFBlock{
+		{Count: 2, Data: appendAvroBytes(appendAvroBytes(nil, []byte("foo")), []byte("bar"))},
+		{Count: 1, Data: appendAvroBytes(nil, bytes.Repeat([]byte{'x'}, 200))},
+	}
+	for _, block := range blocks {
+		file = binary.AppendVarint(file, block.Count)
+		file = binary.AppendVarint(file, int64(len(block.Data)))
+		file = append(file, block.Data...)
+		file = append(file, sync...)
+	}
+
+	// feed byte by byte to make sure partial headers and blocks are never consumed.
+	codec := NewAvroOCFCodec()
+	c := &mockConn{}
+	var got []*AvroOCFBlock
+	for _, b := range file {
+		c.feed([]byte{b})
+		block, err := codec.DecodeBlock(c)
+		require.NoError(t, err)
+		if block != nil {
+			got = append(got, block)
+		}
+	}
+	assert.Equal(t, blocks, got)
+	assert.Equal(t, map[string][]byte{"avro.schema": schema, "avro.codec": []byte("null")}, codec.Metadata())
+	assert.Zero(t, c.InboundBuffered())
+
+	// a block must end with the sync marker of the header.
+	c.feed(appendAvroBytes(binary.AppendVarint(nil, 1), []byte("ok")))
+	c.feed(bytes.Repeat([]byte{0x5A}, AvroOCFSyncLength))
+	_, err := codec.Decode(c)
+	assert.ErrorIs(t, err, gerr.ErrMalformedFrame)
+
+	_, err = NewAvroOCFCodec().Decode(&mockConn{inbound: []byte("PAR1")})
+	assert.ErrorIs(t, err, gerr.ErrMalformedFrame)
+
+	// a file header that can't be completed within the limit.
+	header := binary.AppendVarint([]byte("Obj\x01"), 1)
+	header = appendAvroBytes(header, []byte("avro.schema"))
+	header = binary.AppendVarint(header, 2*avroOCFMaxHeaderLength)
+	codec, c = NewAvroOCFCodec(), &mockConn{}
+	c.feed(header)
+	block, err := codec.DecodeBlock(c)
+	require.NoError(t, err)
+	assert.Nil(t, block)
+	c.feed(make([]byte, avroOCFMaxHeaderLength))
+	_, err = codec.DecodeBlock(c)
+	assert.ErrorIs(t, err, gerr.ErrFrameTooLarge)
+}
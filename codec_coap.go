@@ -0,0 +1,178 @@
+// Copyright (c) 2019 Andy Pan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gnet
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/walkon/wsgnet/pkg/errors"
+)
+
+const coapMaxTokenLength = 8
+
+// coapExtendedLength is indexed by the Len nibble minus 13, it holds the number of the extended length bytes
+// and the offset added to them.
+var coapExtendedLength = [3]struct{ size, offset int }{{1, 13}, {2, 269}, {4, 65805}}
+
+// CoAPMessage is a CoAP message carried over TCP, the options are left encoded in Body
+// along with the payload since they don't matter to the framing.
+type CoAPMessage struct {
+	// Code is the request method or response code.
+	Code byte
+	// Token is the token of 0 to 8 bytes that matches a response with its request.
+	Token []byte
+	// Body is the options followed by the payload marker and the payload, if any.
+	Body []byte
+}
+
+// CoAPTCPCodec frames the CoAP messages over TCP described in RFC 8323, the length of a message is carried
+// by the high nibble of the first byte, with 1, 2 or 4 extended length bytes following it when the nibble
+// is 13, 14 or 15 respectively.
+//
+// Unlike the other codecs, Decode returns the whole message including its header, since the token length
+// and the code are still required to interpret the message, use DecodeMessage to get it parsed.
+// CoAPTCPCodec is stateless, so it can be shared between connections.
+type CoAPTCPCodec struct{}
+
+// NewCoAPTCPCodec instantiates and returns a CoAPTCPCodec.
+func NewCoAPTCPCodec() *CoAPTCPCodec {
+	return new(CoAPTCPCodec)
+}
+
+// Encode is not supported since the token length can't be known from a bare buffer, use EncodeMessage instead.
+func (cc *CoAPTCPCodec) Encode(_ Conn, _ []byte) ([]byte, error) {
+	return nil, errors.ErrUnsupportedOp
+}
+
+// Decode decodes the next complete message as it is on the wire, it returns nil and nil error
+// when more bytes are required to complete the message.
+func (cc *CoAPTCPCodec) Decode(c Conn) ([]byte, error) {
+	in, msgLength, _, err := cc.peekMessage(c)
+	if in == nil {
+		return nil, err
+	}
+	msg := make([]byte, msgLength)
+	copy(msg, in)
+	_, _ = c.Discard(msgLength)
+	return msg, nil
+}
+
+// DecodeN is like Decode but it also returns the number of bytes consumed, which is the length of the message.
+func (cc *CoAPTCPCodec) DecodeN(c Conn) ([]byte, int, error) {
+	msg, err := cc.Decode(c)
+	return msg, len(msg), err
+}
+
+// DecodeMessage decodes and parses the next complete message, it returns nil message and nil error
+// when more bytes are required to complete the message.
+func (cc *CoAPTCPCodec) DecodeMessage(c Conn) (*CoAPMessage, error) {
+	in, msgLength, headerLength, err := cc.peekMessage(c)
+	if in == nil {
+		return nil, err
+	}
+	tokenEnd := headerLength + int(in[0]&0x0f)
+	msg := &CoAPMessage{
+		Code:  in[headerLength-1],
+		Token: make([]byte, tokenEnd-headerLength),
+		Body:  make([]byte, msgLength-tokenEnd),
+	}
+	copy(msg.Token, in[headerLength:tokenEnd])
+	copy(msg.Body, in[tokenEnd:msgLength])
+	_, _ = c.Discard(msgLength)
+	return msg, nil
+}
+
+// peekMessage peeks the next complete message, headerLength counts the first byte, the extended length bytes
+// and the code byte.
+func (cc *CoAPTCPCodec) peekMessage(c Conn) (in []byte, msgLength, headerLength int, err error) {
+	first, err := c.Peek(1)
+	if err != nil {
+		return nil, 0, 0, nil
+	}
+	tokenLength := int(first[0] & 0x0f)
+	if tokenLength > coapMaxTokenLength {
+		return nil, 0, 0, fmt.Errorf("%w: CoAP token length %d is reserved", errors.ErrMalformedFrame, tokenLength)
+	}
+
+	bodyLength := int(first[0] >> 4)
+	extLength := 0
+	if bodyLength >= 13 {
+		ext := coapExtendedLength[bodyLength-13]
+		header, err := c.Peek(1 + ext.size)
+		if err != nil {
+			return nil, 0, 0, nil
+		}
+		switch ext.size {
+		case 1:
+			bodyLength = int(header[1])
+		case 2:
+			bodyLength = int(binary.BigEndian.Uint16(header[1:]))
+		case 4:
+			bodyLength = int(binary.BigEndian.Uint32(header[1:]))
+		}
+		bodyLength += ext.offset
+		extLength = ext.size
+	}
+
+	headerLength = 1 + extLength + 1
+	msgLength = headerLength + tokenLength + bodyLength
+	if msgLength > defaultMaxFrameLength {
+		return nil, 0, 0, fmt.Errorf("%w: CoAP message length %d beyond %d",
+			errors.ErrFrameTooLarge, msgLength, defaultMaxFrameLength)
+	}
+	if in, err = c.Peek(msgLength); err != nil {
+		return nil, 0, 0, nil
+	}
+	return
+}
+
+// EncodeMessage encodes msg with the shortest length field that holds the length of its body.
+func (cc *CoAPTCPCodec) EncodeMessage(msg *CoAPMessage) ([]byte, error) {
+	tokenLength := len(msg.Token)
+	if tokenLength > coapMaxTokenLength {
+		return nil, fmt.Errorf("%w: CoAP token length %d exceeds %d", errors.ErrMalformedFrame, tokenLength, coapMaxTokenLength)
+	}
+	bodyLength := len(msg.Body)
+	if 2+4+tokenLength+bodyLength > defaultMaxFrameLength {
+		return nil, fmt.Errorf("%w: CoAP message length exceeds the limit", errors.ErrEncodeLengthOverflow)
+	}
+
+	var (
+		nibble int
+		ext    [4]byte
+		extLen int
+	)
+	switch {
+	case bodyLength < 13:
+		nibble = bodyLength
+	case bodyLength < 269:
+		nibble, extLen = 13, 1
+		ext[0] = byte(bodyLength - 13)
+	case bodyLength < 65805:
+		nibble, extLen = 14, 2
+		binary.BigEndian.PutUint16(ext[:], uint16(bodyLength-269))
+	default:
+		nibble, extLen = 15, 4
+		binary.BigEndian.PutUint32(ext[:], uint32(bodyLength-65805))
+	}
+
+	out := make([]byte, 0, 2+extLen+tokenLength+bodyLength)
+	out = append(out, byte(nibble<<4|tokenLength))
+	out = append(out, ext[:extLen]...)
+	out = append(out, msg.Code)
+	out = append(out, msg.Token...)
+	return append(out, msg.Body...), nil
+}
@@ -0,0 +1,94 @@
+// Copyright (c) 2019 Andy Pan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gnet
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	gerr "github.com/walkon/wsgnet/pkg/errors"
+)
+
+func TestCoAPTCPCodec(t *testing.T) {
+	codec := NewCoAPTCPCodec()
+	// body lengths around the boundaries of every length field size.
+	cases := []struct {
+		bodyLength   int
+		headerLength int
+	}{
+		{0, 2}, {12, 2}, {13, 3}, {268, 3}, {269, 4}, {65804, 4}, {65805, 6}, {70000, 6},
+	}
+	for _, tc := range cases {
+		msg := &CoAPMessage{Code: 0x02, Token: []byte{0xCA, 0xFE}, Body: bytes.Repeat([]byte{'o'}, tc.bodyLength)}
+		out, err := codec.EncodeMessage(msg)
+		require.NoError(t, err)
+		require.Len(t, out, tc.headerLength+len(msg.Token)+tc.bodyLength, "body length %d", tc.bodyLength)
+
+		c := &mockConn{}
+		c.feed(out[:tc.headerLength-1])
+		got, err := codec.DecodeMessage(c)
+		require.NoError(t, err)
+		assert.Nil(t, got, "incomplete header should not be decoded")
+		c.feed(out[tc.headerLength-1 : len(out)-1])
+		got, err = codec.DecodeMessage(c)
+		require.NoError(t, err)
+		assert.Nil(t, got, "incomplete message should not be decoded")
+
+		c.feed(out[len(out)-1:])
+		c.feed(out)
+		got, err = codec.DecodeMessage(c)
+		require.NoError(t, err)
+		assert.Equal(t, msg, got)
+		raw, err := codec.Decode(c)
+		require.NoError(t, err)
+		assert.Equal(t, out, raw)
+		assert.Zero(t, c.InboundBuffered())
+	}
+}
+
+func TestCoAPTCPCodecWireFormat(t *testing.T) {
+	codec := NewCoAPTCPCodec()
+	c := &mockConn{}
+	// an empty CSM (7.01) message without token.
+	c.feed([]byte{0x00, 0xE1})
+	// a GET with a 1-byte token and a Uri-Path "temperature" option of 12 bytes.
+	c.feed(append([]byte{0xC1, 0x01, 0x24, 0xBB}, "temperature"...))
+	// a 2.05 Content response with 13 bytes of body, which needs the 1-byte extended length.
+	c.feed(append([]byte{0xD1, 0x00, 0x45, 0x24, 0xFF}, "22.5 celsius"...))
+
+	msg, err := codec.DecodeMessage(c)
+	require.NoError(t, err)
+	assert.Equal(t, &CoAPMessage{Code: 0xE1, Token: []byte{}, Body: []byte{}}, msg)
+	msg, err = codec.DecodeMessage(c)
+	require.NoError(t, err)
+	assert.Equal(t, &CoAPMessage{Code: 0x01, Token: []byte{0x24}, Body: append([]byte{0xBB}, "temperature"...)}, msg)
+	msg, err = codec.DecodeMessage(c)
+	require.NoError(t, err)
+	assert.Equal(t, &CoAPMessage{Code: 0x45, Token: []byte{0x24}, Body: append([]byte{0xFF}, "22.5 celsius"...)}, msg)
+	assert.Zero(t, c.InboundBuffered())
+
+	// the token length nibbles 9 to 15 are reserved.
+	c.feed([]byte{0x09, 0x01})
+	_, err = codec.Decode(c)
+	assert.ErrorIs(t, err, gerr.ErrMalformedFrame)
+
+	_, err = codec.EncodeMessage(&CoAPMessage{Token: make([]byte, 9)})
+	assert.ErrorIs(t, err, gerr.ErrMalformedFrame)
+	_, err = codec.EncodeMessage(&CoAPMessage{Body: make([]byte, defaultMaxFrameLength)})
+	assert.ErrorIs(t, err, gerr.ErrEncodeLengthOverflow)
+}
@@ -0,0 +1,246 @@
+// Copyright (c) 2019 Andy Pan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gnet
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/walkon/wsgnet/pkg/errors"
+)
+
+// Compressor compresses and decompresses the payloads of frames, it's implemented by GzipCompressor and
+// can be implemented by the third-party libraries of snappy or zstd for instance.
+type Compressor interface {
+	// Compress compresses src.
+	Compress(src []byte) ([]byte, error)
+	// Decompress decompresses src.
+	Decompress(src []byte) ([]byte, error)
+}
+
+// GzipCompressor is the Compressor of gzip.
+type GzipCompressor struct {
+	// Level is the compression level from gzip.HuffmanOnly to gzip.BestCompression, zero means
+	// gzip.DefaultCompression.
+	Level int
+}
+
+// Compress implements Compressor.
+func (gc GzipCompressor) Compress(src []byte) ([]byte, error) {
+	level := gc.Level
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+	var buf bytes.Buffer
+	zw, err := gzip.NewWriterLevel(&buf, level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = zw.Write(src); err != nil {
+		return nil, err
+	}
+	if err = zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decompress implements Compressor, it fails on the payloads decompressed beyond 10MB.
+func (gc GzipCompressor) Decompress(src []byte) ([]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(src))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errors.ErrMalformedFrame, err)
+	}
+	return readDecompressed(zr)
+}
+
+// FlateCompressor is the Compressor of DEFLATE, see RFC 1951.
+type FlateCompressor struct {
+	// Level is the compression level from flate.HuffmanOnly to flate.BestCompression, zero means
+	// flate.DefaultCompression.
+	Level int
+}
+
+// Compress implements Compressor.
+func (fc FlateCompressor) Compress(src []byte) ([]byte, error) {
+	level := fc.Level
+	if level == 0 {
+		level = flate.DefaultCompression
+	}
+	var buf bytes.Buffer
+	zw, err := flate.NewWriter(&buf, level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = zw.Write(src); err != nil {
+		return nil, err
+	}
+	if err = zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decompress implements Compressor, it fails on the payloads decompressed beyond 10MB.
+func (fc FlateCompressor) Decompress(src []byte) ([]byte, error) {
+	zr := flate.NewReader(bytes.NewReader(src))
+	defer zr.Close()
+	return readDecompressed(zr)
+}
+
+// readDecompressed reads all the decompressed bytes from r up to the limit of defaultMaxFrameLength,
+// which guards against the decompression bombs.
+func readDecompressed(r io.Reader) ([]byte, error) {
+	out, err := io.ReadAll(io.LimitReader(r, defaultMaxFrameLength+1))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errors.ErrMalformedFrame, err)
+	}
+	if len(out) > defaultMaxFrameLength {
+		return nil, fmt.Errorf("%w: decompressed beyond %d bytes", errors.ErrFrameTooLarge, defaultMaxFrameLength)
+	}
+	return out, nil
+}
+
+// frameCompressedFlag is the bit of the flags byte indicating the payload is compressed.
+const frameCompressedFlag = 0x01
+
+// CompressedFrameCodec compresses the payloads of the frames of its inner codec on demand, each frame of the inner
+// codec is made up of a flags byte and the payload, whose lowest bit indicates whether the payload is compressed.
+// Encode compresses the payloads longer than the threshold and sets the flag, unless the compressed payload turns
+// out no shorter, so small frames stay raw, while Decode decompresses the payloads with the flag set.
+//
+// CompressedFrameCodec keeps no state of its own, so it can be shared between connections if the inner codec can.
+type CompressedFrameCodec struct {
+	codec      ICodec
+	compressor Compressor
+	threshold  int
+}
+
+// NewCompressedFrameCodec instantiates and returns a CompressedFrameCodec that frames the flags byte and the payload
+// with codec and compresses the payloads longer than threshold bytes with compressor.
+func NewCompressedFrameCodec(codec ICodec, compressor Compressor, threshold int) *CompressedFrameCodec {
+	return &CompressedFrameCodec{codec: codec, compressor: compressor, threshold: threshold}
+}
+
+// Encode compresses buf if it's longer than the threshold and encodes it along with the flags byte.
+func (cc *CompressedFrameCodec) Encode(c Conn, buf []byte) ([]byte, error) {
+	var flags byte
+	payload := buf
+	if len(buf) > cc.threshold {
+		compressed, err := cc.compressor.Compress(buf)
+		if err != nil {
+			return nil, err
+		}
+		if len(compressed) < len(buf) {
+			flags, payload = frameCompressedFlag, compressed
+		}
+	}
+	frame := make([]byte, 1+len(payload))
+	frame[0] = flags
+	copy(frame[1:], payload)
+	return cc.codec.Encode(c, frame)
+}
+
+// Decode decodes the next frame with the inner codec and decompresses its payload if the flag is set.
+func (cc *CompressedFrameCodec) Decode(c Conn) ([]byte, error) {
+	frame, err := cc.codec.Decode(c)
+	if frame == nil || err != nil {
+		return nil, err
+	}
+	if len(frame) == 0 {
+		return nil, fmt.Errorf("%w: no flags byte", errors.ErrShortFrame)
+	}
+	flags := frame[0]
+	if flags&^frameCompressedFlag != 0 {
+		return nil, fmt.Errorf("%w: unknown flags %#x", errors.ErrMalformedFrame, flags)
+	}
+	if flags&frameCompressedFlag == 0 {
+		return frame[1:], nil
+	}
+	return cc.compressor.Decompress(frame[1:])
+}
+
+// CompressionAlgorithm is the algorithm of CompressionCodec.
+type CompressionAlgorithm int
+
+const (
+	// CompressionGzip compresses the frames with gzip, see GzipCompressor.
+	CompressionGzip CompressionAlgorithm = iota
+	// CompressionFlate compresses the frames with DEFLATE, see FlateCompressor.
+	CompressionFlate
+)
+
+// CompressionCodec compresses all the frames of its inner codec transparently, Encode compresses buf and encodes it
+// with the inner codec, while Decode decompresses the frame decoded by the inner codec, so it composes with any
+// ICodec, LengthFieldBasedFrameCodec for instance. Note that the compressed bytes may contain any byte, so the inner
+// codec framing by delimiters rejects the compressed frames containing its delimiter, see CompressedFrameCodec
+// for compressing the large frames only.
+//
+// CompressionCodec keeps no state of its own, so it can be shared between connections if the inner codec can.
+type CompressionCodec struct {
+	codec      ICodec
+	compressor Compressor
+}
+
+// NewCompressionCodec instantiates and returns a CompressionCodec that compresses the frames of codec with algorithm
+// at level, zero means the default compression level of algorithm.
+func NewCompressionCodec(codec ICodec, algorithm CompressionAlgorithm, level int) *CompressionCodec {
+	cc := &CompressionCodec{codec: codec}
+	switch algorithm {
+	case CompressionGzip:
+		cc.compressor = GzipCompressor{Level: level}
+	case CompressionFlate:
+		cc.compressor = FlateCompressor{Level: level}
+	}
+	return cc
+}
+
+// Encode compresses buf and encodes it with the inner codec.
+func (cc *CompressionCodec) Encode(c Conn, buf []byte) ([]byte, error) {
+	if cc.compressor == nil {
+		return nil, fmt.Errorf("%w: unknown compression algorithm", errors.ErrUnsupportedOp)
+	}
+	compressed, err := cc.compressor.Compress(buf)
+	if err != nil {
+		return nil, err
+	}
+	return cc.codec.Encode(c, compressed)
+}
+
+// Decode decodes the next frame with the inner codec and decompresses it, the frames failing the decompression
+// are rejected by errors.ErrMalformedFrame, and the ones decompressed beyond 10MB by errors.ErrFrameTooLarge.
+func (cc *CompressionCodec) Decode(c Conn) ([]byte, error) {
+	if cc.compressor == nil {
+		return nil, fmt.Errorf("%w: unknown compression algorithm", errors.ErrUnsupportedOp)
+	}
+	frame, err := cc.codec.Decode(c)
+	if frame == nil || err != nil {
+		return nil, err
+	}
+	if len(frame) == 0 {
+		return nil, fmt.Errorf("%w: no compressed data", errors.ErrShortFrame)
+	}
+	out, err := cc.compressor.Decompress(frame)
+	if err != nil {
+		return nil, err
+	}
+	if out == nil {
+		out = []byte{}
+	}
+	return out, nil
+}
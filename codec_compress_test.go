@@ -0,0 +1,114 @@
+// Copyright (c) 2019 Andy Pan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gnet
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	gerr "github.com/walkon/wsgnet/pkg/errors"
+)
+
+func TestCompressedFrameCodec(t *testing.T) {
+	codec := NewCompressedFrameCodec(NewLengthFieldBasedFrameCodec(
+		EncoderConfig{ByteOrder: binary.BigEndian, LengthFieldLength: 4},
+		DecoderConfig{ByteOrder: binary.BigEndian, LengthFieldLength: 4},
+	), GzipCompressor{}, 64)
+	c := &mockConn{}
+	small := []byte("small frame stays raw")
+	large := bytes.Repeat([]byte("compressible "), 100)
+	for _, payload := range [][]byte{small, large} {
+		out, err := codec.Encode(c, payload)
+		require.NoError(t, err)
+		c.feed(out)
+	}
+	// the small frame is sent as it is, the large one is compressed.
+	assert.Equal(t, append([]byte{0x00, 0x00, 0x00, byte(1 + len(small)), 0x00}, small...), c.inbound[:5+len(small)])
+	assert.Equal(t, byte(frameCompressedFlag), c.inbound[5+len(small)+4])
+	assert.Less(t, len(c.inbound), 5+len(small)+len(large))
+
+	for _, want := range [][]byte{small, large} {
+		frame, err := codec.Decode(c)
+		require.NoError(t, err)
+		assert.Equal(t, want, frame)
+	}
+	assert.Zero(t, c.InboundBuffered())
+
+	c.feed([]byte{0x00, 0x00, 0x00, 0x02, 0x02, 'x'})
+	_, err := codec.Decode(c)
+	assert.ErrorIs(t, err, gerr.ErrMalformedFrame)
+	c.feed([]byte{0x00, 0x00, 0x00, 0x02, frameCompressedFlag, 'x'})
+	_, err = codec.Decode(c)
+	assert.ErrorIs(t, err, gerr.ErrMalformedFrame, "a payload flagged compressed must be decompressible")
+}
+
+func TestGzipCompressorLimit(t *testing.T) {
+	bomb, err := GzipCompressor{Level: 9}.Compress(make([]byte, defaultMaxFrameLength+1))
+	require.NoError(t, err)
+	_, err = GzipCompressor{}.Decompress(bomb)
+	assert.ErrorIs(t, err, gerr.ErrFrameTooLarge)
+}
+
+func TestCompressionCodec(t *testing.T) {
+	payload := bytes.Repeat([]byte("compressible "), 100)
+	for _, algorithm := range []CompressionAlgorithm{CompressionGzip, CompressionFlate} {
+		codec := NewCompressionCodec(NewLengthFieldBasedFrameCodec(
+			EncoderConfig{ByteOrder: binary.BigEndian, LengthFieldLength: 4},
+			DecoderConfig{ByteOrder: binary.BigEndian, LengthFieldLength: 4, InitialBytesToStrip: 4},
+		), algorithm, flate.BestCompression)
+		c := &mockConn{}
+		for _, buf := range [][]byte{payload, nil} {
+			out, err := codec.Encode(c, buf)
+			require.NoError(t, err)
+			c.feed(out)
+		}
+		assert.Less(t, len(c.inbound), len(payload))
+
+		frame, err := codec.Decode(c)
+		require.NoError(t, err)
+		assert.Equal(t, payload, frame)
+		frame, err = codec.Decode(c)
+		require.NoError(t, err)
+		assert.NotNil(t, frame)
+		assert.Empty(t, frame)
+		frame, _ = codec.Decode(c)
+		assert.Nil(t, frame)
+
+		c.feed([]byte{0x00, 0x00, 0x00, 0x00})
+		_, err = codec.Decode(c)
+		assert.ErrorIs(t, err, gerr.ErrShortFrame)
+		c.feed([]byte{0x00, 0x00, 0x00, 0x02, 0xFF, 0xFF})
+		_, err = codec.Decode(c)
+		assert.ErrorIs(t, err, gerr.ErrMalformedFrame, "the frame must be decompressible")
+	}
+
+	// it composes with the delimiter codecs as well.
+	codec := NewCompressionCodec(NewDelimiterBasedFrameCodec([]byte("\x00\xFF\x00\xFF"), 0), CompressionFlate, 0)
+	c := &mockConn{}
+	out, err := codec.Encode(c, []byte("hello"))
+	require.NoError(t, err)
+	c.feed(out)
+	frame, err := codec.Decode(c)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(frame))
+
+	_, err = NewCompressionCodec(codec, CompressionAlgorithm(-1), 0).Encode(c, nil)
+	assert.ErrorIs(t, err, gerr.ErrUnsupportedOp)
+}
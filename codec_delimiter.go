@@ -0,0 +1,106 @@
+// Copyright (c) 2019 Andy Pan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gnet
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/walkon/wsgnet/pkg/errors"
+)
+
+// delimiterScanKey is the key of the number of the buffered bytes that have been scanned for the delimiter
+// without finding it, stored by Conn.SetCodecScratch.
+type delimiterScanKey struct {
+	cc *DelimiterBasedFrameCodec
+}
+
+// DelimiterBasedFrameCodec frames the text protocols whose frames are terminated by a delimiter rather than
+// prefixed with their length, "\r\n" for SMTP or the inline commands of Redis, "\n" or "\x00" for instance.
+//
+// Decode returns the next frame without its delimiter, the frames going beyond maxFrameLength, the delimiter
+// excluded, are rejected by errors.ErrFrameTooLarge as soon as that many bytes are buffered without a delimiter,
+// so the connection is supposed to be closed. Encode appends the delimiter to buf, which must not contain it.
+//
+// The bytes scanned for the delimiter of an incomplete frame are tracked per connection by Conn.SetCodecScratch,
+// so that they aren't scanned again when more bytes arrive, so DelimiterBasedFrameCodec can be shared between
+// connections.
+type DelimiterBasedFrameCodec struct {
+	delimiter      []byte
+	maxFrameLength int
+}
+
+// NewDelimiterBasedFrameCodec instantiates and returns a DelimiterBasedFrameCodec of delimiter, which must not
+// be empty, maxFrameLength defaults to 10MB if it's not positive.
+func NewDelimiterBasedFrameCodec(delimiter []byte, maxFrameLength int) *DelimiterBasedFrameCodec {
+	if maxFrameLength <= 0 {
+		maxFrameLength = defaultMaxFrameLength
+	}
+	return &DelimiterBasedFrameCodec{delimiter: append([]byte(nil), delimiter...), maxFrameLength: maxFrameLength}
+}
+
+// Encode appends the delimiter to buf.
+func (cc *DelimiterBasedFrameCodec) Encode(_ Conn, buf []byte) ([]byte, error) {
+	if len(cc.delimiter) == 0 {
+		return nil, fmt.Errorf("%w: empty delimiter", errors.ErrUnsupportedOp)
+	}
+	if len(buf) > cc.maxFrameLength {
+		return nil, fmt.Errorf("%w: %w: %d-byte frame beyond %d bytes",
+			errors.ErrEncodeLengthOverflow, errors.ErrFrameTooLarge, len(buf), cc.maxFrameLength)
+	}
+	if bytes.Contains(buf, cc.delimiter) {
+		return nil, fmt.Errorf("%w: frame containing the delimiter %q", errors.ErrMalformedFrame, cc.delimiter)
+	}
+	out := make([]byte, len(buf)+len(cc.delimiter))
+	copy(out, buf)
+	copy(out[len(buf):], cc.delimiter)
+	return out, nil
+}
+
+// Decode decodes the next frame without its delimiter, it returns nil and nil error when the delimiter
+// of the next frame hasn't arrived yet.
+func (cc *DelimiterBasedFrameCodec) Decode(c Conn) ([]byte, error) {
+	if len(cc.delimiter) == 0 {
+		return nil, fmt.Errorf("%w: empty delimiter", errors.ErrUnsupportedOp)
+	}
+	in, _ := c.Peek(c.InboundBuffered())
+	key := delimiterScanKey{cc}
+	// The delimiter may span the scanned bytes and the new ones, so the scan resumes before the end of the former.
+	scanned, _ := c.CodecScratch(key).(int)
+	from := scanned - (len(cc.delimiter) - 1)
+	if from < 0 || from > len(in) {
+		from = 0
+	}
+	i := bytes.Index(in[from:], cc.delimiter)
+	if i < 0 {
+		if len(in) > cc.maxFrameLength {
+			return nil, fmt.Errorf("%w: %d bytes buffered without the delimiter beyond %d bytes",
+				errors.ErrFrameTooLarge, len(in), cc.maxFrameLength)
+		}
+		c.SetCodecScratch(key, len(in))
+		return nil, nil
+	}
+	frameLength := from + i
+	if frameLength > cc.maxFrameLength {
+		return nil, fmt.Errorf("%w: %d-byte frame beyond %d bytes", errors.ErrFrameTooLarge, frameLength, cc.maxFrameLength)
+	}
+	if scanned != 0 {
+		c.SetCodecScratch(key, 0)
+	}
+	frame := make([]byte, frameLength)
+	copy(frame, in)
+	_, _ = c.Discard(frameLength + len(cc.delimiter))
+	return frame, nil
+}
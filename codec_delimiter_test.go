@@ -0,0 +1,86 @@
+// Copyright (c) 2019 Andy Pan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gnet
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	gerr "github.com/walkon/wsgnet/pkg/errors"
+)
+
+func TestDelimiterBasedFrameCodec(t *testing.T) {
+	codec := NewDelimiterBasedFrameCodec([]byte("\r\n"), 8)
+	c := &mockConn{}
+
+	out, err := codec.Encode(c, []byte("EHLO"))
+	require.NoError(t, err)
+	assert.Equal(t, "EHLO\r\n", string(out))
+	_, err = codec.Encode(c, []byte("EH\r\nLO"))
+	assert.ErrorIs(t, err, gerr.ErrMalformedFrame)
+	_, err = codec.Encode(c, []byte("too long!"))
+	assert.ErrorIs(t, err, gerr.ErrEncodeLengthOverflow)
+	assert.ErrorIs(t, err, gerr.ErrFrameTooLarge)
+
+	// two frames in one read, an empty one among them.
+	c.feed([]byte("HELO\r\n\r\nQUIT\r"))
+	frame, err := codec.Decode(c)
+	require.NoError(t, err)
+	assert.Equal(t, "HELO", string(frame))
+	frame, err = codec.Decode(c)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{}, frame)
+	frame, err = codec.Decode(c)
+	require.NoError(t, err)
+	assert.Nil(t, frame, "the delimiter is incomplete")
+
+	// the delimiter spans the bytes scanned already and the new ones.
+	c.feed([]byte("\nNO"))
+	frame, err = codec.Decode(c)
+	require.NoError(t, err)
+	assert.Equal(t, "QUIT", string(frame))
+	assert.Equal(t, 2, c.InboundBuffered())
+
+	c.feed([]byte("OP\x00\r\n"))
+	frame, err = codec.Decode(c)
+	require.NoError(t, err)
+	assert.Equal(t, "NOOP\x00", string(frame))
+	assert.Zero(t, c.InboundBuffered())
+
+	// the frames without a delimiter are rejected once they go beyond the limit.
+	c.feed([]byte("12345678"))
+	frame, err = codec.Decode(c)
+	require.NoError(t, err)
+	assert.Nil(t, frame)
+	c.feed([]byte("9"))
+	_, err = codec.Decode(c)
+	assert.ErrorIs(t, err, gerr.ErrFrameTooLarge)
+
+	// a NUL-terminated protocol.
+	codec = NewDelimiterBasedFrameCodec([]byte{0}, 0)
+	c = &mockConn{}
+	c.feed([]byte("a\x00bc\x00"))
+	frame, err = codec.Decode(c)
+	require.NoError(t, err)
+	assert.Equal(t, "a", string(frame))
+	frame, err = codec.Decode(c)
+	require.NoError(t, err)
+	assert.Equal(t, "bc", string(frame))
+
+	_, err = NewDelimiterBasedFrameCodec(nil, 0).Decode(c)
+	assert.ErrorIs(t, err, gerr.ErrUnsupportedOp)
+}
@@ -0,0 +1,57 @@
+// Copyright (c) 2019 Andy Pan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gnet
+
+import (
+	"fmt"
+
+	"github.com/walkon/wsgnet/pkg/errors"
+)
+
+// FixedLengthFrameCodec frames the fixed-size records carrying no framing metadata at all, each frame is exactly
+// frameLength bytes, e.g. the 64-byte readings of a sensor stream. Encode validates that buf is exactly frameLength
+// bytes, padding it is up to the caller. FixedLengthFrameCodec is stateless, so it can be shared between connections.
+type FixedLengthFrameCodec struct {
+	frameLength int
+}
+
+// NewFixedLengthFrameCodec instantiates and returns a FixedLengthFrameCodec of frames of frameLength bytes,
+// which must be positive.
+func NewFixedLengthFrameCodec(frameLength int) *FixedLengthFrameCodec {
+	return &FixedLengthFrameCodec{frameLength: frameLength}
+}
+
+// Encode validates that buf is a whole frame and passes it through.
+func (cc *FixedLengthFrameCodec) Encode(_ Conn, buf []byte) ([]byte, error) {
+	if len(buf) != cc.frameLength {
+		return nil, fmt.Errorf("%w: %d-byte frame of the fixed length %d", errors.ErrMalformedFrame, len(buf), cc.frameLength)
+	}
+	return buf, nil
+}
+
+// Decode decodes the next frame, it returns nil and nil error when fewer than frameLength bytes are buffered.
+func (cc *FixedLengthFrameCodec) Decode(c Conn) ([]byte, error) {
+	if cc.frameLength <= 0 {
+		return nil, fmt.Errorf("%w: fixed length %d", errors.ErrUnsupportedLength, cc.frameLength)
+	}
+	in, err := c.Peek(cc.frameLength)
+	if err != nil || len(in) < cc.frameLength {
+		return nil, nil
+	}
+	frame := make([]byte, cc.frameLength)
+	copy(frame, in)
+	_, _ = c.Discard(cc.frameLength)
+	return frame, nil
+}
@@ -0,0 +1,61 @@
+// Copyright (c) 2019 Andy Pan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gnet
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	gerr "github.com/walkon/wsgnet/pkg/errors"
+)
+
+func TestFixedLengthFrameCodec(t *testing.T) {
+	codec := NewFixedLengthFrameCodec(64)
+	c := &mockConn{}
+	reading := bytes.Repeat([]byte{0x5A}, 64)
+
+	out, err := codec.Encode(c, reading)
+	require.NoError(t, err)
+	assert.Equal(t, reading, out)
+	_, err = codec.Encode(c, reading[:63])
+	assert.ErrorIs(t, err, gerr.ErrMalformedFrame, "padding is up to the caller")
+
+	// a reading accumulates across the reads.
+	for _, chunk := range [][]byte{reading[:10], reading[10:40], reading[40:63]} {
+		c.feed(chunk)
+		frame, err := codec.Decode(c)
+		require.NoError(t, err)
+		assert.Nil(t, frame)
+	}
+	c.feed(reading[63:])
+	// the next reading arrives along with the last byte of the first one.
+	c.feed(reading)
+	c.feed(reading[:1])
+	for i := 0; i < 2; i++ {
+		frame, err := codec.Decode(c)
+		require.NoError(t, err)
+		assert.Equal(t, reading, frame)
+	}
+	frame, err := codec.Decode(c)
+	require.NoError(t, err)
+	assert.Nil(t, frame)
+	assert.Equal(t, 1, c.InboundBuffered())
+
+	_, err = NewFixedLengthFrameCodec(0).Decode(c)
+	assert.ErrorIs(t, err, gerr.ErrUnsupportedLength)
+}
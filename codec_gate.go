@@ -0,0 +1,68 @@
+// Copyright (c) 2019 Andy Pan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gnet
+
+import (
+	"fmt"
+
+	"github.com/walkon/wsgnet/pkg/errors"
+)
+
+// GatedCodec holds back the decoding of its inner codec until Enable is called, the inbound bytes are left
+// buffered in the connection meanwhile, so the frames pipelined by the peer during a handshake are decoded
+// once the handler has consumed the handshake by itself and enabled the GatedCodec. Encode always goes to
+// the inner codec.
+//
+// GatedCodec keeps the state of the gate, so it must not be shared between connections, instantiate one per
+// connection instead, by Conn.SetCodec() in EventHandler.OnOpen for instance.
+type GatedCodec struct {
+	codec       ICodec
+	maxBuffered int
+	enabled     bool
+}
+
+// NewGatedCodec instantiates and returns a disabled GatedCodec of codec, maxBuffered is the maximum number of
+// bytes buffered while decoding is disabled, beyond which Decode fails with errors.ErrInboundBufferFull,
+// zero means no limit.
+func NewGatedCodec(codec ICodec, maxBuffered int) *GatedCodec {
+	return &GatedCodec{codec: codec, maxBuffered: maxBuffered}
+}
+
+// Enable enables decoding, it's typically called once the handshake is done.
+func (gc *GatedCodec) Enable() {
+	gc.enabled = true
+}
+
+// Enabled reports whether decoding has been enabled.
+func (gc *GatedCodec) Enabled() bool {
+	return gc.enabled
+}
+
+// Encode encodes buf with the inner codec.
+func (gc *GatedCodec) Encode(c Conn, buf []byte) ([]byte, error) {
+	return gc.codec.Encode(c, buf)
+}
+
+// Decode decodes the next frame with the inner codec once decoding is enabled, it returns nil frame and
+// nil error until then.
+func (gc *GatedCodec) Decode(c Conn) ([]byte, error) {
+	if !gc.enabled {
+		if buffered := c.InboundBuffered(); gc.maxBuffered > 0 && buffered > gc.maxBuffered {
+			return nil, fmt.Errorf("%w: %d bytes buffered before decoding is enabled", errors.ErrInboundBufferFull, buffered)
+		}
+		return nil, nil
+	}
+	return gc.codec.Decode(c)
+}
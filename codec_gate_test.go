@@ -0,0 +1,58 @@
+// Copyright (c) 2019 Andy Pan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gnet
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	gerr "github.com/walkon/wsgnet/pkg/errors"
+)
+
+func TestGatedCodec(t *testing.T) {
+	codec := NewGatedCodec(NewLengthFieldBasedFrameCodec(
+		EncoderConfig{ByteOrder: binary.BigEndian, LengthFieldLength: 2},
+		DecoderConfig{ByteOrder: binary.BigEndian, LengthFieldLength: 2},
+	), 16)
+	c := &mockConn{}
+	out, err := codec.Encode(c, []byte("ping"))
+	require.NoError(t, err)
+	assert.Equal(t, append([]byte{0x00, 0x04}, "ping"...), out)
+
+	// a handshake pipelined with a frame.
+	c.feed([]byte("HELLO"))
+	c.feed(out)
+	frame, err := codec.Decode(c)
+	require.NoError(t, err)
+	assert.Nil(t, frame, "no frame should be decoded before the handshake is done")
+	assert.False(t, codec.Enabled())
+
+	hello, err := c.Next(5)
+	require.NoError(t, err)
+	assert.Equal(t, "HELLO", string(hello))
+	codec.Enable()
+	assert.True(t, codec.Enabled())
+	frame, err = codec.Decode(c)
+	require.NoError(t, err)
+	assert.Equal(t, "ping", string(frame))
+
+	codec = NewGatedCodec(codec, 4)
+	c.feed([]byte("HELLO"))
+	_, err = codec.Decode(c)
+	assert.ErrorIs(t, err, gerr.ErrInboundBufferFull)
+}
@@ -0,0 +1,122 @@
+// Copyright (c) 2019 Andy Pan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gnet
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/walkon/wsgnet/pkg/errors"
+)
+
+// grpcPrefixLength is the length of the prefix of a gRPC length-prefixed message.
+const grpcPrefixLength = 5
+
+// GRPCDecompressor decompresses the data of a message whose compressed flag is set, with the algorithm
+// negotiated by the grpc-encoding header, gzip for instance.
+type GRPCDecompressor func(compressed []byte) ([]byte, error)
+
+// GRPCMessage is a gRPC length-prefixed message.
+type GRPCMessage struct {
+	// Compressed is the compressed flag of the message as it was on the wire.
+	Compressed bool
+	// Data is the message, which has been decompressed if Compressed is set.
+	Data []byte
+}
+
+// GRPCMessageCodec frames the gRPC length-prefixed messages, [1-byte compressed flag][4-byte big-endian length]
+// [message], carried by the DATA frames of a gRPC stream, it operates on the data of a single stream that has
+// been demultiplexed from HTTP/2 already, or on a raw connection for testing. The messages with the compressed
+// flag set are decompressed by the decompressor registered by RegisterDecompressor.
+//
+// Encode never compresses, it frames buf with the compressed flag unset. GRPCMessageCodec is stateless once
+// the decompressor has been registered, so it can be shared between connections.
+type GRPCMessageCodec struct {
+	lfb        *LengthFieldBasedFrameCodec
+	decompress GRPCDecompressor
+}
+
+// NewGRPCMessageCodec instantiates and returns a GRPCMessageCodec without any decompressor.
+func NewGRPCMessageCodec() *GRPCMessageCodec {
+	return &GRPCMessageCodec{lfb: NewLengthFieldBasedFrameCodec(EncoderConfig{}, DecoderConfig{
+		ByteOrder:           binary.BigEndian,
+		LengthFieldOffset:   1,
+		LengthFieldLength:   4,
+		InitialBytesToStrip: StripNone,
+		VerifyInterHeader:   verifyGRPCPrefix,
+	})}
+}
+
+// RegisterDecompressor registers the decompressor of the compressed messages, it must be called before
+// the codec is in use.
+func (cc *GRPCMessageCodec) RegisterDecompressor(decompress GRPCDecompressor) {
+	cc.decompress = decompress
+}
+
+// verifyGRPCPrefix rejects a message by its compressed flag, which is either 0 or 1.
+func verifyGRPCPrefix(prefix, _ []byte) error {
+	if prefix[0] > 1 {
+		return fmt.Errorf("%w: gRPC compressed flag %d", errors.ErrMalformedFrame, prefix[0])
+	}
+	return nil
+}
+
+// Encode frames the uncompressed message buf, it fails with errors.ErrEncodeLengthOverflow if buf is 4GiB or
+// beyond, which the 4-byte length prefix can't hold.
+func (cc *GRPCMessageCodec) Encode(_ Conn, buf []byte) ([]byte, error) {
+	if uint64(len(buf)) >= 1<<32 {
+		return nil, fmt.Errorf("%w: %d-byte gRPC message", errors.ErrEncodeLengthOverflow, len(buf))
+	}
+	out := make([]byte, grpcPrefixLength+len(buf))
+	binary.BigEndian.PutUint32(out[1:], uint32(len(buf)))
+	copy(out[grpcPrefixLength:], buf)
+	return out, nil
+}
+
+// Decode decodes the next complete message, decompressed if its compressed flag is set, see DecodeMessage.
+func (cc *GRPCMessageCodec) Decode(c Conn) ([]byte, error) {
+	msg, err := cc.DecodeMessage(c)
+	if msg == nil {
+		return nil, err
+	}
+	return msg.Data, nil
+}
+
+// DecodeMessage decodes the next complete message along with its compressed flag, it returns nil message
+// and nil error when more bytes are required to complete the message. A compressed message fails to decode
+// with errors.ErrUnsupportedOp if no decompressor has been registered, it's consumed anyway.
+func (cc *GRPCMessageCodec) DecodeMessage(c Conn) (*GRPCMessage, error) {
+	in, msgLength, err := cc.lfb.peekFrame(c)
+	if in == nil {
+		return nil, err
+	}
+	msg := &GRPCMessage{Compressed: in[0] == 1}
+	data := in[grpcPrefixLength:msgLength]
+	if msg.Compressed {
+		if cc.decompress == nil {
+			_, _ = c.Discard(msgLength)
+			return nil, fmt.Errorf("%w: no decompressor for the compressed gRPC message", errors.ErrUnsupportedOp)
+		}
+		if msg.Data, err = cc.decompress(data); err != nil {
+			_, _ = c.Discard(msgLength)
+			return nil, err
+		}
+	} else {
+		msg.Data = make([]byte, len(data))
+		copy(msg.Data, data)
+	}
+	_, _ = c.Discard(msgLength)
+	return msg, nil
+}
@@ -0,0 +1,82 @@
+// Copyright (c) 2019 Andy Pan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gnet
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"strconv"
+	"testing"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	gerr "github.com/walkon/wsgnet/pkg/errors"
+)
+
+func TestGRPCMessageCodec(t *testing.T) {
+	codec := NewGRPCMessageCodec()
+	c := &mockConn{}
+	out, err := codec.Encode(c, []byte("hello"))
+	require.NoError(t, err)
+	assert.Equal(t, append([]byte{0x00, 0x00, 0x00, 0x00, 0x05}, "hello"...), out)
+
+	if strconv.IntSize == 64 {
+		// the message is never read, so a slice header claiming 4GiB stands in for a real one.
+		huge := unsafe.Slice(&out[0], 1<<32)
+		_, err = codec.Encode(c, huge)
+		assert.ErrorIs(t, err, gerr.ErrEncodeLengthOverflow, "4GiB doesn't fit into the length prefix")
+	}
+
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	_, _ = zw.Write([]byte("compressed hello"))
+	require.NoError(t, zw.Close())
+	gzipped := append([]byte{0x01, 0x00, 0x00, 0x00, byte(compressed.Len())}, compressed.Bytes()...)
+
+	c.feed(out[:4])
+	msg, _ := codec.DecodeMessage(c)
+	assert.Nil(t, msg)
+	c.feed(out[4:])
+	c.feed(gzipped)
+	c.feed(out)
+	msg, err = codec.DecodeMessage(c)
+	require.NoError(t, err)
+	assert.Equal(t, &GRPCMessage{Data: []byte("hello")}, msg)
+	_, err = codec.DecodeMessage(c)
+	assert.ErrorIs(t, err, gerr.ErrUnsupportedOp, "compressed message without a decompressor")
+	frame, err := codec.Decode(c)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(frame), "the compressed message should have been consumed")
+
+	codec.RegisterDecompressor(func(compressed []byte) ([]byte, error) {
+		zr, err := gzip.NewReader(bytes.NewReader(compressed))
+		if err != nil {
+			return nil, err
+		}
+		return io.ReadAll(zr)
+	})
+	c.feed(gzipped)
+	msg, err = codec.DecodeMessage(c)
+	require.NoError(t, err)
+	assert.Equal(t, &GRPCMessage{Compressed: true, Data: []byte("compressed hello")}, msg)
+	assert.Zero(t, c.InboundBuffered())
+
+	c.feed([]byte{0x02, 0x00, 0x00, 0x00, 0x00})
+	_, err = codec.Decode(c)
+	assert.ErrorIs(t, err, gerr.ErrMalformedFrame)
+}
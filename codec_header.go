@@ -0,0 +1,189 @@
+// Copyright (c) 2019 Andy Pan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gnet
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+
+	"github.com/walkon/wsgnet/pkg/errors"
+)
+
+const (
+	// headerFramePrefixLength is the length of [4-byte length][2-byte headers length].
+	headerFramePrefixLength = 6
+
+	// DefaultMaxFrameHeaders is the default limit of the number of headers of a frame.
+	DefaultMaxFrameHeaders = 32
+	// DefaultMaxFrameHeadersLength is the default limit of the encoded length of the headers of a frame.
+	DefaultMaxFrameHeadersLength = 4096
+)
+
+// FrameHeaders is the key/value headers of a frame decoded by HeaderFrameCodec.
+type FrameHeaders map[string]string
+
+// HeaderFrameCodec frames the payloads carrying optional key/value headers ahead of them,
+// [4-byte big-endian length][2-byte big-endian headers length][headers][payload], where the length counts
+// everything after itself and the headers are a sequence of [1-byte key length][key][2-byte big-endian value
+// length][value]. It's the length field preset of LengthFieldLength=4, InterHeaderSkip=2 and LengthAdjustment=-2
+// with nothing stripped, the headers are parsed out of the frame and the payload is left.
+//
+// The number of headers and their encoded length are bounded by maxHeaders and maxHeadersLength, a frame going
+// beyond them is rejected by ErrMalformedFrame as soon as its prefix arrives, as is a frame with an empty or
+// a duplicate key, while a frame beyond 10MB is rejected by ErrFrameTooLarge.
+//
+// Decode exposes the headers of the last decoded frame as FrameHeaders in the connection context, which is nil
+// if the frame has no headers, so the context of a connection framed by HeaderFrameCodec must be left to it.
+// Encode frames buf without headers, use EncodeFrame to send them. HeaderFrameCodec itself is stateless,
+// so it can be shared between connections.
+type HeaderFrameCodec struct {
+	maxHeaders       int
+	maxHeadersLength int
+	lfb              *LengthFieldBasedFrameCodec
+}
+
+// NewHeaderFrameCodec instantiates and returns a HeaderFrameCodec, maxHeaders and maxHeadersLength default
+// to DefaultMaxFrameHeaders and DefaultMaxFrameHeadersLength if they are not positive, maxHeadersLength
+// can't go beyond 65535 of the 2-byte headers length.
+func NewHeaderFrameCodec(maxHeaders, maxHeadersLength int) *HeaderFrameCodec {
+	if maxHeaders <= 0 {
+		maxHeaders = DefaultMaxFrameHeaders
+	}
+	if maxHeadersLength <= 0 {
+		maxHeadersLength = DefaultMaxFrameHeadersLength
+	}
+	if maxHeadersLength > 1<<16-1 {
+		maxHeadersLength = 1<<16 - 1
+	}
+	cc := &HeaderFrameCodec{maxHeaders: maxHeaders, maxHeadersLength: maxHeadersLength}
+	cc.lfb = NewLengthFieldBasedFrameCodec(EncoderConfig{}, DecoderConfig{
+		ByteOrder:           binary.BigEndian,
+		LengthFieldLength:   4,
+		InterHeaderSkip:     2,
+		LengthAdjustment:    -2,
+		InitialBytesToStrip: StripNone,
+		VerifyInterHeader:   cc.verifyPrefix,
+	})
+	return cc
+}
+
+// verifyPrefix rejects a frame by its length and its headers length before the rest of it is read.
+func (cc *HeaderFrameCodec) verifyPrefix(lengthField, skipped []byte) error {
+	length := binary.BigEndian.Uint32(lengthField)
+	if length < 2 {
+		return fmt.Errorf("%w: header frame length %d", errors.ErrMalformedFrame, length)
+	}
+	headersLength := binary.BigEndian.Uint16(skipped)
+	if int(headersLength) > cc.maxHeadersLength || uint32(headersLength) > length-2 {
+		return fmt.Errorf("%w: %d bytes of headers in the header frame of %d bytes",
+			errors.ErrMalformedFrame, headersLength, length)
+	}
+	return nil
+}
+
+// Encode frames the payload buf without headers.
+func (cc *HeaderFrameCodec) Encode(_ Conn, buf []byte) ([]byte, error) {
+	return cc.EncodeFrame(nil, buf)
+}
+
+// EncodeFrame frames the payload along with headers, which are written in the order of their keys.
+func (cc *HeaderFrameCodec) EncodeFrame(headers FrameHeaders, payload []byte) ([]byte, error) {
+	if len(headers) > cc.maxHeaders {
+		return nil, fmt.Errorf("%w: %d headers beyond %d", errors.ErrMalformedFrame, len(headers), cc.maxHeaders)
+	}
+	keys := make([]string, 0, len(headers))
+	headersLength := 0
+	for key, value := range headers {
+		if len(key) == 0 {
+			return nil, fmt.Errorf("%w: header of an empty key", errors.ErrMalformedFrame)
+		}
+		if len(key) > 1<<8-1 || len(value) > 1<<16-1 {
+			return nil, fmt.Errorf("%w: header of a %d-byte key and a %d-byte value",
+				errors.ErrEncodeLengthOverflow, len(key), len(value))
+		}
+		keys = append(keys, key)
+		headersLength += 3 + len(key) + len(value)
+	}
+	if headersLength > cc.maxHeadersLength {
+		return nil, fmt.Errorf("%w: %d bytes of headers beyond %d",
+			errors.ErrMalformedFrame, headersLength, cc.maxHeadersLength)
+	}
+	frameLength := headerFramePrefixLength + headersLength + len(payload)
+	if frameLength > cc.lfb.maxFrameLength() {
+		return nil, fmt.Errorf("%w: header frame of %d bytes", errors.ErrEncodeLengthOverflow, frameLength)
+	}
+	sort.Strings(keys)
+
+	out := make([]byte, headerFramePrefixLength, frameLength)
+	binary.BigEndian.PutUint32(out, uint32(frameLength-4))
+	binary.BigEndian.PutUint16(out[4:], uint16(headersLength))
+	for _, key := range keys {
+		value := headers[key]
+		out = append(out, byte(len(key)))
+		out = append(out, key...)
+		out = binary.BigEndian.AppendUint16(out, uint16(len(value)))
+		out = append(out, value...)
+	}
+	return append(out, payload...), nil
+}
+
+// Decode decodes the payload of the next complete frame and stores its headers in the connection context.
+func (cc *HeaderFrameCodec) Decode(c Conn) ([]byte, error) {
+	in, msgLength, err := cc.lfb.peekFrame(c)
+	if in == nil {
+		return nil, err
+	}
+	headersEnd := headerFramePrefixLength + int(binary.BigEndian.Uint16(in[4:]))
+	headers, err := cc.parseHeaders(in[headerFramePrefixLength:headersEnd])
+	if err != nil {
+		_, _ = c.Discard(msgLength)
+		return nil, err
+	}
+	c.SetContext(headers)
+	payload := make([]byte, msgLength-headersEnd)
+	copy(payload, in[headersEnd:])
+	_, _ = c.Discard(msgLength)
+	return payload, nil
+}
+
+// parseHeaders parses the headers of a frame, it returns nil FrameHeaders if there is none.
+func (cc *HeaderFrameCodec) parseHeaders(in []byte) (FrameHeaders, error) {
+	var headers FrameHeaders
+	for len(in) > 0 {
+		if len(headers) == cc.maxHeaders {
+			return nil, fmt.Errorf("%w: headers beyond %d", errors.ErrMalformedFrame, cc.maxHeaders)
+		}
+		keyLength := int(in[0])
+		if keyLength == 0 || len(in) < 1+keyLength+2 {
+			return nil, fmt.Errorf("%w: truncated header", errors.ErrMalformedFrame)
+		}
+		key := string(in[1 : 1+keyLength])
+		in = in[1+keyLength:]
+		valueLength := int(binary.BigEndian.Uint16(in))
+		if len(in) < 2+valueLength {
+			return nil, fmt.Errorf("%w: truncated value of header %q", errors.ErrMalformedFrame, key)
+		}
+		if _, ok := headers[key]; ok {
+			return nil, fmt.Errorf("%w: duplicate header %q", errors.ErrMalformedFrame, key)
+		}
+		if headers == nil {
+			headers = make(FrameHeaders)
+		}
+		headers[key] = string(in[2 : 2+valueLength])
+		in = in[2+valueLength:]
+	}
+	return headers, nil
+}
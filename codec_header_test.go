@@ -0,0 +1,89 @@
+// Copyright (c) 2019 Andy Pan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gnet
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	gerr "github.com/walkon/wsgnet/pkg/errors"
+)
+
+func TestHeaderFrameCodec(t *testing.T) {
+	codec := NewHeaderFrameCodec(2, 32)
+	c := &mockConn{}
+
+	out, err := codec.EncodeFrame(FrameHeaders{"type": "ping", "id": "7"}, []byte("hello"))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("\x00\x00\x00\x18\x00\x11"+"\x02id\x00\x017"+"\x04type\x00\x04ping"+"hello"), out)
+
+	c.feed(out[:8])
+	payload, _ := codec.Decode(c)
+	assert.Nil(t, payload)
+	c.feed(out[8:])
+	payload, err = codec.Decode(c)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("hello"), payload)
+	assert.Equal(t, FrameHeaders{"type": "ping", "id": "7"}, c.Context())
+	assert.Zero(t, c.InboundBuffered())
+
+	// a frame without headers leaves no headers in the context.
+	out, err = codec.Encode(c, []byte("bye"))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("\x00\x00\x00\x05\x00\x00bye"), out)
+	c.feed(out)
+	payload, err = codec.Decode(c)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("bye"), payload)
+	assert.Nil(t, c.Context().(FrameHeaders))
+
+	// the bounds of the headers are enforced by both ends.
+	_, err = codec.EncodeFrame(FrameHeaders{"a": "1", "b": "2", "c": "3"}, nil)
+	assert.ErrorIs(t, err, gerr.ErrMalformedFrame)
+	_, err = codec.EncodeFrame(FrameHeaders{"a": strings.Repeat("x", 32)}, nil)
+	assert.ErrorIs(t, err, gerr.ErrMalformedFrame)
+	_, err = codec.EncodeFrame(FrameHeaders{"": "1"}, nil)
+	assert.ErrorIs(t, err, gerr.ErrMalformedFrame)
+	_, err = codec.EncodeFrame(FrameHeaders{strings.Repeat("k", 256): "1"}, nil)
+	assert.ErrorIs(t, err, gerr.ErrEncodeLengthOverflow)
+	_, err = codec.EncodeFrame(nil, make([]byte, defaultMaxFrameLength))
+	assert.ErrorIs(t, err, gerr.ErrEncodeLengthOverflow)
+
+	// too many headers.
+	c.feed([]byte("\x00\x00\x00\x11\x00\x0f" + "\x01a\x00\x011" + "\x01b\x00\x012" + "\x01c\x00\x013"))
+	_, err = codec.Decode(c)
+	assert.ErrorIs(t, err, gerr.ErrMalformedFrame)
+	assert.Zero(t, c.InboundBuffered(), "the rejected frame is consumed")
+
+	// a duplicate key.
+	c.feed([]byte("\x00\x00\x00\x0c\x00\x0a" + "\x01a\x00\x011" + "\x01a\x00\x012"))
+	_, err = codec.Decode(c)
+	assert.ErrorIs(t, err, gerr.ErrMalformedFrame)
+	_, _ = c.Discard(c.InboundBuffered())
+
+	// headers beyond maxHeadersLength are rejected by the prefix.
+	c.feed([]byte("\x00\x00\x01\x00\x00\x40"))
+	_, err = codec.Decode(c)
+	assert.ErrorIs(t, err, gerr.ErrMalformedFrame)
+	_, _ = c.Discard(c.InboundBuffered())
+
+	// a truncated header.
+	c.feed([]byte("\x00\x00\x00\x06\x00\x04" + "\x01a\x00\x05"))
+	_, err = codec.Decode(c)
+	assert.ErrorIs(t, err, gerr.ErrMalformedFrame)
+}
@@ -0,0 +1,174 @@
+// Copyright (c) 2019 Andy Pan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gnet
+
+import (
+	"bytes"
+	"fmt"
+	"unicode/utf8"
+
+	"github.com/walkon/wsgnet/pkg/errors"
+)
+
+// lineScanKey is the key of the number of the buffered bytes that have been scanned for the newline
+// without finding it, stored by Conn.SetCodecScratch.
+type lineScanKey struct {
+	cc *LineBasedFrameCodec
+}
+
+// lineBOMKey is the key of whether the first line of a connection has been checked for the UTF-8 BOM,
+// stored by Conn.SetCodecScratch.
+type lineBOMKey struct {
+	cc *LineBasedFrameCodec
+}
+
+// utf8BOM is the byte order mark of UTF-8.
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// LineBasedFrameCodec frames the text protocols whose frames are lines terminated by either "\n" or "\r\n",
+// both of which are accepted within the same stream, like the LineBasedFrameDecoder of Netty.
+//
+// Decode returns the next line with its terminator stripped if stripDelimiter is set, so no trailing '\r' is left
+// on the lines terminated by "\r\n", the lines going beyond maxLength, the terminator excluded, are rejected by
+// errors.ErrFrameTooLarge as soon as that many bytes are buffered without a newline, so the connection is
+// supposed to be closed. Encode appends "\n" to buf, or "\r\n" if EncodeCRLF is set, which must not contain '\n'.
+//
+// The bytes scanned for the newline of an incomplete line are tracked per connection by Conn.SetCodecScratch,
+// so LineBasedFrameCodec can be shared between connections as long as its options are set up beforehand.
+type LineBasedFrameCodec struct {
+	maxLength      int
+	stripDelimiter bool
+
+	// EncodeCRLF indicates whether Encode terminates the lines with "\r\n" rather than "\n".
+	EncodeCRLF bool
+
+	// SkipLeadingWhitespace indicates whether to consume the spaces, tabs, '\r' and '\n' ahead of a line
+	// before framing it, for the sloppy clients sending stray whitespace between the lines, which makes
+	// the empty lines never decoded as well.
+	SkipLeadingWhitespace bool
+
+	// ValidateUTF8 indicates whether to reject the lines that are not valid UTF-8 with errors.ErrInvalidUTF8,
+	// the line is discarded, so the connection is supposed to be closed.
+	ValidateUTF8 bool
+
+	// StripUTF8BOM indicates whether to strip the UTF-8 BOM, 0xEF 0xBB 0xBF, off the first line of a connection,
+	// which some clients prepend to their first message, the BOM on the following lines is left as it is.
+	StripUTF8BOM bool
+}
+
+// NewLineBasedFrameCodec instantiates and returns a LineBasedFrameCodec, maxLength defaults to 10MB if it's
+// not positive, and stripDelimiter indicates whether to strip the terminators off the decoded lines.
+func NewLineBasedFrameCodec(maxLength int, stripDelimiter bool) *LineBasedFrameCodec {
+	if maxLength <= 0 {
+		maxLength = defaultMaxFrameLength
+	}
+	return &LineBasedFrameCodec{maxLength: maxLength, stripDelimiter: stripDelimiter}
+}
+
+// Encode appends the terminator to buf.
+func (cc *LineBasedFrameCodec) Encode(_ Conn, buf []byte) ([]byte, error) {
+	if len(buf) > cc.maxLength {
+		return nil, fmt.Errorf("%w: %w: %d-byte line beyond %d bytes",
+			errors.ErrEncodeLengthOverflow, errors.ErrFrameTooLarge, len(buf), cc.maxLength)
+	}
+	if bytes.IndexByte(buf, '\n') >= 0 {
+		return nil, fmt.Errorf("%w: line containing a newline", errors.ErrMalformedFrame)
+	}
+	terminator := "\n"
+	if cc.EncodeCRLF {
+		terminator = "\r\n"
+	}
+	out := make([]byte, len(buf)+len(terminator))
+	copy(out, buf)
+	copy(out[len(buf):], terminator)
+	return out, nil
+}
+
+// Decode decodes the next line, it returns nil and nil error when the newline of the next line
+// hasn't arrived yet.
+func (cc *LineBasedFrameCodec) Decode(c Conn) ([]byte, error) {
+	if cc.StripUTF8BOM {
+		if checked, _ := c.CodecScratch(lineBOMKey{cc}).(bool); !checked && !cc.stripUTF8BOM(c) {
+			return nil, nil
+		}
+	}
+	if cc.SkipLeadingWhitespace {
+		skipLeadingWhitespace(c)
+	}
+	in, _ := c.Peek(c.InboundBuffered())
+	key := lineScanKey{cc}
+	scanned, _ := c.CodecScratch(key).(int)
+	if scanned > len(in) {
+		scanned = 0
+	}
+	i := bytes.IndexByte(in[scanned:], '\n')
+	if i < 0 {
+		if len(in) > cc.maxLength {
+			return nil, fmt.Errorf("%w: %d bytes buffered without a newline beyond %d bytes",
+				errors.ErrFrameTooLarge, len(in), cc.maxLength)
+		}
+		c.SetCodecScratch(key, len(in))
+		return nil, nil
+	}
+	if scanned != 0 {
+		c.SetCodecScratch(key, 0)
+	}
+	end := scanned + i
+	lineLength := end
+	if end > 0 && in[end-1] == '\r' {
+		lineLength--
+	}
+	if lineLength > cc.maxLength {
+		_, _ = c.Discard(end + 1)
+		return nil, fmt.Errorf("%w: %d-byte line beyond %d bytes", errors.ErrFrameTooLarge, lineLength, cc.maxLength)
+	}
+	if cc.ValidateUTF8 && !utf8.Valid(in[:lineLength]) {
+		_, _ = c.Discard(end + 1)
+		return nil, errors.ErrInvalidUTF8
+	}
+	if !cc.stripDelimiter {
+		lineLength = end + 1
+	}
+	line := make([]byte, lineLength)
+	copy(line, in)
+	_, _ = c.Discard(end + 1)
+	return line, nil
+}
+
+// stripUTF8BOM strips the UTF-8 BOM off the first line of c, it returns false if more bytes are required
+// to tell whether the first line starts with the BOM.
+func (cc *LineBasedFrameCodec) stripUTF8BOM(c Conn) bool {
+	in, _ := c.Peek(c.InboundBuffered())
+	if len(in) < len(utf8BOM) && bytes.HasPrefix(utf8BOM, in) {
+		return false
+	}
+	if bytes.HasPrefix(in, utf8BOM) {
+		_, _ = c.Discard(len(utf8BOM))
+	}
+	c.SetCodecScratch(lineBOMKey{cc}, true)
+	return true
+}
+
+// skipLeadingWhitespace consumes the whitespace buffered ahead of the next line.
+func skipLeadingWhitespace(c Conn) {
+	in, _ := c.Peek(c.InboundBuffered())
+	n := 0
+	for n < len(in) && (in[n] == ' ' || in[n] == '\t' || in[n] == '\r' || in[n] == '\n') {
+		n++
+	}
+	if n > 0 {
+		_, _ = c.Discard(n)
+	}
+}
@@ -0,0 +1,129 @@
+// Copyright (c) 2019 Andy Pan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gnet
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	gerr "github.com/walkon/wsgnet/pkg/errors"
+)
+
+func TestLineBasedFrameCodec(t *testing.T) {
+	codec := NewLineBasedFrameCodec(8, true)
+	c := &mockConn{}
+
+	// both terminators within the same stream, the line accumulates across the reads.
+	for _, chunk := range []string{"PING\r", "\nPO", "NG\n", "\n"} {
+		c.feed([]byte(chunk))
+	}
+	var lines []string
+	for {
+		line, err := codec.Decode(c)
+		require.NoError(t, err)
+		if line == nil {
+			break
+		}
+		lines = append(lines, string(line))
+	}
+	assert.Equal(t, []string{"PING", "PONG", ""}, lines)
+
+	codec = NewLineBasedFrameCodec(8, false)
+	c.feed([]byte("a\r\nb\n"))
+	line, err := codec.Decode(c)
+	require.NoError(t, err)
+	assert.Equal(t, "a\r\n", string(line))
+	line, err = codec.Decode(c)
+	require.NoError(t, err)
+	assert.Equal(t, "b\n", string(line))
+
+	// the terminator of CRLF isn't counted against maxLength.
+	c.feed([]byte("12345678\r\n"))
+	line, err = codec.Decode(c)
+	require.NoError(t, err)
+	assert.Equal(t, "12345678\r\n", string(line))
+	c.feed([]byte("123456789"))
+	_, err = codec.Decode(c)
+	assert.ErrorIs(t, err, gerr.ErrFrameTooLarge)
+	_, _ = c.Discard(c.InboundBuffered())
+
+	out, err := codec.Encode(c, []byte("PING"))
+	require.NoError(t, err)
+	assert.Equal(t, "PING\n", string(out))
+	codec.EncodeCRLF = true
+	out, err = codec.Encode(c, []byte("PING"))
+	require.NoError(t, err)
+	assert.Equal(t, "PING\r\n", string(out))
+	_, err = codec.Encode(c, []byte("a\nb"))
+	assert.ErrorIs(t, err, gerr.ErrMalformedFrame)
+	_, err = codec.Encode(c, bytes.Repeat([]byte("x"), 9))
+	assert.ErrorIs(t, err, gerr.ErrEncodeLengthOverflow)
+	assert.ErrorIs(t, err, gerr.ErrFrameTooLarge)
+}
+
+func TestLineBasedFrameCodecSkipLeadingWhitespace(t *testing.T) {
+	codec := NewLineBasedFrameCodec(0, true)
+	codec.SkipLeadingWhitespace = true
+	c := &mockConn{}
+	c.feed([]byte("\r\n  \tGET\r\n\r\n"))
+	line, err := codec.Decode(c)
+	require.NoError(t, err)
+	assert.Equal(t, "GET", string(line))
+	line, err = codec.Decode(c)
+	require.NoError(t, err)
+	assert.Nil(t, line, "no empty line from the stray CRLF")
+	assert.Zero(t, c.InboundBuffered())
+}
+
+func TestLineBasedFrameCodecValidateUTF8(t *testing.T) {
+	codec := NewLineBasedFrameCodec(0, true)
+	codec.ValidateUTF8 = true
+	c := &mockConn{}
+	c.feed([]byte("h\xc3\xa9\n\xff\nok\n"))
+	line, err := codec.Decode(c)
+	require.NoError(t, err)
+	assert.Equal(t, "hé", string(line))
+	_, err = codec.Decode(c)
+	assert.ErrorIs(t, err, gerr.ErrInvalidUTF8)
+	line, err = codec.Decode(c)
+	require.NoError(t, err)
+	assert.Equal(t, "ok", string(line), "the invalid line is discarded")
+}
+
+func TestLineBasedFrameCodecStripUTF8BOM(t *testing.T) {
+	codec := NewLineBasedFrameCodec(0, true)
+	codec.StripUTF8BOM = true
+	c := &mockConn{}
+	for _, chunk := range []string{"\xef", "\xbb", "\xbfHELO\n\xef\xbb\xbfQUIT\n"} {
+		c.feed([]byte(chunk))
+		line, err := codec.Decode(c)
+		require.NoError(t, err)
+		if line != nil {
+			assert.Equal(t, "HELO", string(line))
+		}
+	}
+	line, err := codec.Decode(c)
+	require.NoError(t, err)
+	assert.Equal(t, "\xef\xbb\xbfQUIT", string(line), "only the first line is stripped")
+
+	c = &mockConn{}
+	c.feed([]byte("\xefHELO\n"))
+	line, err = codec.Decode(c)
+	require.NoError(t, err)
+	assert.Equal(t, "\xefHELO", string(line), "no BOM")
+}
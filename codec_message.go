@@ -0,0 +1,68 @@
+// Copyright (c) 2019 Andy Pan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gnet
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/walkon/wsgnet/pkg/errors"
+)
+
+// MessageCodec encodes and decodes the typed messages of T over the frames of its inner codec, which marshals
+// each message into the payload of a frame and unmarshals each decoded frame into a message, so that the handlers
+// needn't parse the frames into the protobuf or JSON structs by hand. The framing is delegated to the inner codec,
+// so it works with any ICodec, LengthFieldBasedFrameCodec or DelimiterBasedFrameCodec for instance.
+//
+// MessageCodec keeps no state of its own, so it can be shared between connections if the inner codec can.
+type MessageCodec[T any] struct {
+	codec     ICodec
+	marshal   func(T) ([]byte, error)
+	unmarshal func([]byte) (T, error)
+}
+
+// NewMessageCodec instantiates and returns a MessageCodec that frames the messages with codec,
+// marshaling them with marshal and unmarshaling them with unmarshal, json.Marshal for instance.
+func NewMessageCodec[T any](codec ICodec, marshal func(T) ([]byte, error),
+	unmarshal func([]byte) (T, error)) *MessageCodec[T] {
+	return &MessageCodec[T]{codec: codec, marshal: marshal, unmarshal: unmarshal}
+}
+
+// EncodeMessage marshals msg and encodes it with the inner codec.
+func (mc *MessageCodec[T]) EncodeMessage(c Conn, msg T) ([]byte, error) {
+	buf, err := mc.marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	return mc.codec.Encode(c, buf)
+}
+
+// DecodeMessage decodes the next frame with the inner codec and unmarshals it. It returns the zero value of T along
+// with io.ErrShortBuffer when more bytes are required to complete the frame, and the frames failing to unmarshal
+// are rejected by errors.ErrMalformedFrame.
+func (mc *MessageCodec[T]) DecodeMessage(c Conn) (msg T, err error) {
+	frame, err := mc.codec.Decode(c)
+	if err != nil {
+		return msg, err
+	}
+	if frame == nil {
+		return msg, io.ErrShortBuffer
+	}
+	if msg, err = mc.unmarshal(frame); err != nil {
+		var zero T
+		return zero, fmt.Errorf("%w: %v", errors.ErrMalformedFrame, err)
+	}
+	return msg, nil
+}
@@ -0,0 +1,119 @@
+// Copyright (c) 2019 Andy Pan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gnet
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	gerr "github.com/walkon/wsgnet/pkg/errors"
+)
+
+type testMessage struct {
+	ID   int    `json:"id"`
+	Body string `json:"body"`
+}
+
+func jsonMarshal[T any](msg T) ([]byte, error) {
+	return json.Marshal(msg)
+}
+
+func jsonUnmarshal[T any](buf []byte) (msg T, err error) {
+	err = json.Unmarshal(buf, &msg)
+	return
+}
+
+// testProtoMessage mimics a generated protobuf message.
+type testProtoMessage struct {
+	ID uint8
+}
+
+func (m *testProtoMessage) Marshal() ([]byte, error) {
+	return []byte{m.ID}, nil
+}
+
+func (m *testProtoMessage) Unmarshal(buf []byte) error {
+	if len(buf) != 1 {
+		return fmt.Errorf("%d bytes of testProtoMessage", len(buf))
+	}
+	m.ID = buf[0]
+	return nil
+}
+
+// protoMarshaler is implemented by the generated protobuf messages.
+type protoMarshaler interface {
+	Marshal() ([]byte, error)
+	Unmarshal([]byte) error
+}
+
+func protoAdapter[T protoMarshaler](newMessage func() T) (func(T) ([]byte, error), func([]byte) (T, error)) {
+	return func(msg T) ([]byte, error) {
+			return msg.Marshal()
+		}, func(buf []byte) (T, error) {
+			msg := newMessage()
+			return msg, msg.Unmarshal(buf)
+		}
+}
+
+func TestMessageCodec(t *testing.T) {
+	codec := NewMessageCodec[testMessage](NewLengthFieldBasedFrameCodec(
+		EncoderConfig{ByteOrder: binary.BigEndian, LengthFieldLength: 2},
+		DecoderConfig{ByteOrder: binary.BigEndian, LengthFieldLength: 2, InitialBytesToStrip: 2},
+	), jsonMarshal[testMessage], jsonUnmarshal[testMessage])
+	c := &mockConn{}
+	want := testMessage{ID: 7, Body: "hello"}
+	out, err := codec.EncodeMessage(c, want)
+	require.NoError(t, err)
+
+	// an incomplete frame is told from a decoded one.
+	c.feed(out[:5])
+	msg, err := codec.DecodeMessage(c)
+	assert.ErrorIs(t, err, io.ErrShortBuffer)
+	assert.Zero(t, msg)
+	c.feed(out[5:])
+	msg, err = codec.DecodeMessage(c)
+	require.NoError(t, err)
+	assert.Equal(t, want, msg)
+
+	c.feed([]byte{0x00, 0x01, '{'})
+	msg, err = codec.DecodeMessage(c)
+	assert.ErrorIs(t, err, gerr.ErrMalformedFrame)
+	assert.Zero(t, msg)
+
+	// a delimiter framing returning nil and nil error for the incomplete frames.
+	marshal, unmarshal := protoAdapter(func() *testProtoMessage { return &testProtoMessage{} })
+	proto := NewMessageCodec(NewDelimiterBasedFrameCodec([]byte("\r\n"), 0), marshal, unmarshal)
+	out, err = proto.EncodeMessage(c, &testProtoMessage{ID: 42})
+	require.NoError(t, err)
+	assert.Equal(t, "*\r\n", string(out))
+	c.feed(out[:1])
+	pm, err := proto.DecodeMessage(c)
+	assert.ErrorIs(t, err, io.ErrShortBuffer)
+	assert.Nil(t, pm)
+	c.feed(out[1:])
+	pm, err = proto.DecodeMessage(c)
+	require.NoError(t, err)
+	assert.Equal(t, uint8(42), pm.ID)
+	c.feed([]byte("ab\r\n"))
+	pm, err = proto.DecodeMessage(c)
+	assert.ErrorIs(t, err, gerr.ErrMalformedFrame)
+	assert.Nil(t, pm)
+}
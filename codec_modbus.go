@@ -0,0 +1,120 @@
+// Copyright (c) 2019 Andy Pan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gnet
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/walkon/wsgnet/pkg/errors"
+)
+
+const (
+	// modbusMBAPLength is the length of the MBAP header including the unit id.
+	modbusMBAPLength = 7
+	// modbusMaxPDULength is the maximum length of a Modbus PDU.
+	modbusMaxPDULength = 253
+)
+
+// ModbusTCPHeader is the MBAP header of a Modbus/TCP frame except for the protocol id and the length,
+// the protocol id is always 0 and the length is computed from the PDU.
+type ModbusTCPHeader struct {
+	// TransactionID pairs a response with its request.
+	TransactionID uint16
+	// UnitID identifies the remote slave behind a gateway.
+	UnitID uint8
+}
+
+// ModbusTCPCodec frames the Modbus/TCP application data units, each of which is made up of the 7-byte MBAP header,
+// [2-byte transaction id][2-byte protocol id][2-byte length][1-byte unit id], and the PDU, where the length
+// counts the unit id and the PDU. It's the length field preset of LengthFieldOffset=4, LengthFieldLength=2
+// and LengthAdjustment=0, with the whole MBAP header stripped from the decoded PDU.
+//
+// Decode exposes the MBAP header of the last decoded frame as a *ModbusTCPHeader in the connection context,
+// and Encode answers it by reusing the transaction id and the unit id found in the context, so the context of
+// a connection framed by ModbusTCPCodec must be left to it. ModbusTCPCodec itself is stateless, so it can be
+// shared between connections.
+type ModbusTCPCodec struct {
+	lfb *LengthFieldBasedFrameCodec
+}
+
+// NewModbusTCPCodec instantiates and returns a ModbusTCPCodec.
+func NewModbusTCPCodec() *ModbusTCPCodec {
+	return &ModbusTCPCodec{lfb: NewLengthFieldBasedFrameCodec(EncoderConfig{}, DecoderConfig{
+		ByteOrder:           binary.BigEndian,
+		LengthFieldOffset:   4,
+		LengthFieldLength:   2,
+		InitialBytesToStrip: StripNone,
+		VerifyInterHeader:   verifyMBAP,
+	})}
+}
+
+// verifyMBAP rejects a frame by its protocol id and length before the PDU is read.
+func verifyMBAP(header, _ []byte) error {
+	if protocolID := binary.BigEndian.Uint16(header[2:]); protocolID != 0 {
+		return fmt.Errorf("%w: Modbus protocol id %d", errors.ErrMalformedFrame, protocolID)
+	}
+	// the unit id and at least the function code.
+	if length := binary.BigEndian.Uint16(header[4:]); length < 2 || length > modbusMaxPDULength+1 {
+		return fmt.Errorf("%w: Modbus length %d", errors.ErrMalformedFrame, length)
+	}
+	return nil
+}
+
+// Encode encodes the PDU buf into a frame answering the last decoded frame, whose MBAP header is taken
+// from the connection context, see EncodeFrame to send a request.
+func (cc *ModbusTCPCodec) Encode(c Conn, buf []byte) ([]byte, error) {
+	header, ok := c.Context().(*ModbusTCPHeader)
+	if !ok {
+		return nil, fmt.Errorf("%w: no Modbus frame to answer", errors.ErrUnsupportedOp)
+	}
+	return cc.EncodeFrame(*header, buf)
+}
+
+// EncodeFrame encodes the PDU with the given MBAP header.
+func (cc *ModbusTCPCodec) EncodeFrame(header ModbusTCPHeader, pdu []byte) ([]byte, error) {
+	if len(pdu) == 0 {
+		return nil, fmt.Errorf("%w: empty Modbus PDU", errors.ErrMalformedFrame)
+	}
+	if len(pdu) > modbusMaxPDULength {
+		return nil, fmt.Errorf("%w: Modbus PDU length %d", errors.ErrEncodeLengthOverflow, len(pdu))
+	}
+	out := make([]byte, modbusMBAPLength+len(pdu))
+	binary.BigEndian.PutUint16(out, header.TransactionID)
+	binary.BigEndian.PutUint16(out[4:], uint16(len(pdu)+1))
+	out[6] = header.UnitID
+	copy(out[modbusMBAPLength:], pdu)
+	return out, nil
+}
+
+// Decode decodes the PDU of the next complete frame and stores its MBAP header in the connection context.
+func (cc *ModbusTCPCodec) Decode(c Conn) ([]byte, error) {
+	in, msgLength, err := cc.lfb.peekFrame(c)
+	if in == nil {
+		return nil, err
+	}
+
+	header, ok := c.Context().(*ModbusTCPHeader)
+	if !ok {
+		header = new(ModbusTCPHeader)
+		c.SetContext(header)
+	}
+	header.TransactionID = binary.BigEndian.Uint16(in)
+	header.UnitID = in[6]
+	pdu := make([]byte, msgLength-modbusMBAPLength)
+	copy(pdu, in[modbusMBAPLength:])
+	_, _ = c.Discard(msgLength)
+	return pdu, nil
+}
@@ -0,0 +1,62 @@
+// Copyright (c) 2019 Andy Pan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gnet
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	gerr "github.com/walkon/wsgnet/pkg/errors"
+)
+
+func TestModbusTCPCodec(t *testing.T) {
+	codec := NewModbusTCPCodec()
+	c := &mockConn{}
+	// Read Holding Registers (0x03) of unit 0x11: 3 registers starting at 0x006B, transaction 0x0001.
+	request := []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x06, 0x11, 0x03, 0x00, 0x6B, 0x00, 0x03}
+
+	_, err := codec.Encode(c, []byte{0x03})
+	assert.ErrorIs(t, err, gerr.ErrUnsupportedOp, "there is no request to answer yet")
+
+	c.feed(request[:7])
+	pdu, _ := codec.Decode(c)
+	assert.Nil(t, pdu)
+	c.feed(request[7:])
+	pdu, err = codec.Decode(c)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x03, 0x00, 0x6B, 0x00, 0x03}, pdu)
+	assert.Equal(t, &ModbusTCPHeader{TransactionID: 1, UnitID: 0x11}, c.Context())
+	assert.Zero(t, c.InboundBuffered())
+
+	// the response carries 6 bytes of register values: 0x022B, 0x0000, 0x0064.
+	out, err := codec.Encode(c, []byte{0x03, 0x06, 0x02, 0x2B, 0x00, 0x00, 0x00, 0x64})
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x09, 0x11, 0x03, 0x06, 0x02, 0x2B, 0x00, 0x00, 0x00, 0x64}, out)
+
+	out, err = codec.EncodeFrame(ModbusTCPHeader{TransactionID: 1, UnitID: 0x11}, pdu)
+	require.NoError(t, err)
+	assert.Equal(t, request, out)
+	_, err = codec.EncodeFrame(ModbusTCPHeader{}, nil)
+	assert.ErrorIs(t, err, gerr.ErrMalformedFrame)
+	_, err = codec.EncodeFrame(ModbusTCPHeader{}, make([]byte, modbusMaxPDULength+1))
+	assert.ErrorIs(t, err, gerr.ErrEncodeLengthOverflow)
+
+	// a frame of another protocol is rejected as soon as its header arrives.
+	c.feed([]byte{0x00, 0x02, 0x00, 0x01, 0x00, 0x06})
+	_, err = codec.Decode(c)
+	assert.ErrorIs(t, err, gerr.ErrMalformedFrame)
+}
@@ -0,0 +1,124 @@
+// Copyright (c) 2019 Andy Pan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gnet
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/walkon/wsgnet/pkg/errors"
+)
+
+// The opcodes of the OpenVPN packets.
+const (
+	OpenVPNControlHardResetClientV1 = 1
+	OpenVPNControlHardResetServerV1 = 2
+	OpenVPNControlSoftResetV1       = 3
+	OpenVPNControlV1                = 4
+	OpenVPNAckV1                    = 5
+	OpenVPNDataV1                   = 6
+	OpenVPNControlHardResetClientV2 = 7
+	OpenVPNControlHardResetServerV2 = 8
+	OpenVPNDataV2                   = 9
+	OpenVPNControlHardResetClientV3 = 10
+	OpenVPNControlWKCV1             = 11
+
+	// openVPNMaxPacketLength is the limit of the 2-byte packet length.
+	openVPNMaxPacketLength = 1<<16 - 1
+)
+
+// OpenVPNPacketHeader is the leading byte of an OpenVPN packet, [5-bit opcode][3-bit key id].
+type OpenVPNPacketHeader struct {
+	// Opcode is the opcode of the packet, one of OpenVPNControlHardResetClientV1 through OpenVPNControlWKCV1.
+	Opcode uint8
+	// KeyID is the id of the key the packet is protected by.
+	KeyID uint8
+}
+
+// IsData reports whether the packet belongs to the data channel, the control channel otherwise.
+func (h *OpenVPNPacketHeader) IsData() bool {
+	return h.Opcode == OpenVPNDataV1 || h.Opcode == OpenVPNDataV2
+}
+
+// OpenVPNTCPCodec frames the OpenVPN packets over TCP, each of which is prefixed with a 2-byte big-endian length,
+// [2-byte length][1-byte opcode and key id][payload], where the length counts the opcode and the payload.
+// It's the length field preset of LengthFieldLength=2, InterHeaderSkip=1, LengthAdjustment=-1 and
+// InitialBytesToStrip=2, so the decoded packet still starts with its opcode, and a packet of no opcode
+// or an unknown one is rejected by ErrMalformedFrame as soon as its header arrives.
+//
+// Decode exposes the opcode and the key id of the last decoded packet as an *OpenVPNPacketHeader in the connection
+// context, to route the packets to the control channel or the data channel, so the context of a connection framed
+// by OpenVPNTCPCodec must be left to it. Encode frames a whole packet starting with its opcode.
+// OpenVPNTCPCodec itself is stateless, so it can be shared between connections.
+type OpenVPNTCPCodec struct {
+	lfb *LengthFieldBasedFrameCodec
+}
+
+// NewOpenVPNTCPCodec instantiates and returns an OpenVPNTCPCodec.
+func NewOpenVPNTCPCodec() *OpenVPNTCPCodec {
+	return &OpenVPNTCPCodec{lfb: NewLengthFieldBasedFrameCodec(EncoderConfig{}, DecoderConfig{
+		ByteOrder:           binary.BigEndian,
+		LengthFieldLength:   2,
+		InterHeaderSkip:     1,
+		LengthAdjustment:    -1,
+		InitialBytesToStrip: 2,
+		VerifyInterHeader:   verifyOpenVPNOpcode,
+	})}
+}
+
+// verifyOpenVPNOpcode rejects a packet by its opcode before the rest of it is read.
+func verifyOpenVPNOpcode(_, opcode []byte) error {
+	if op := opcode[0] >> 3; op < OpenVPNControlHardResetClientV1 || op > OpenVPNControlWKCV1 {
+		return fmt.Errorf("%w: OpenVPN opcode %d", errors.ErrMalformedFrame, op)
+	}
+	return nil
+}
+
+// Encode frames the packet buf, which starts with its opcode.
+func (cc *OpenVPNTCPCodec) Encode(_ Conn, buf []byte) ([]byte, error) {
+	if len(buf) == 0 {
+		return nil, fmt.Errorf("%w: empty OpenVPN packet", errors.ErrMalformedFrame)
+	}
+	if len(buf) > openVPNMaxPacketLength {
+		return nil, fmt.Errorf("%w: OpenVPN packet of %d bytes", errors.ErrEncodeLengthOverflow, len(buf))
+	}
+	if err := verifyOpenVPNOpcode(nil, buf); err != nil {
+		return nil, err
+	}
+	out := make([]byte, 2+len(buf))
+	binary.BigEndian.PutUint16(out, uint16(len(buf)))
+	copy(out[2:], buf)
+	return out, nil
+}
+
+// Decode decodes the next complete packet starting with its opcode and stores its opcode and key id
+// in the connection context.
+func (cc *OpenVPNTCPCodec) Decode(c Conn) ([]byte, error) {
+	in, msgLength, err := cc.lfb.peekFrame(c)
+	if in == nil {
+		return nil, err
+	}
+
+	header, ok := c.Context().(*OpenVPNPacketHeader)
+	if !ok {
+		header = new(OpenVPNPacketHeader)
+		c.SetContext(header)
+	}
+	header.Opcode, header.KeyID = in[0]>>3, in[0]&0x07
+	packet := make([]byte, len(in))
+	copy(packet, in)
+	_, _ = c.Discard(msgLength)
+	return packet, nil
+}
@@ -0,0 +1,80 @@
+// Copyright (c) 2019 Andy Pan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gnet
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	gerr "github.com/walkon/wsgnet/pkg/errors"
+)
+
+func TestOpenVPNTCPCodec(t *testing.T) {
+	codec := NewOpenVPNTCPCodec()
+	c := &mockConn{}
+	// P_CONTROL_HARD_RESET_CLIENT_V2 of key id 0 without tls-auth: the opcode, the 8-byte session id,
+	// an empty ack array and the 4-byte message packet id 0.
+	packet := []byte{
+		0x00, 0x0e,
+		0x38,
+		0x5e, 0x8f, 0x1b, 0x2c, 0x9a, 0x47, 0xd3, 0x60,
+		0x00,
+		0x00, 0x00, 0x00, 0x00,
+	}
+
+	c.feed(packet[:3])
+	frame, _ := codec.Decode(c)
+	assert.Nil(t, frame)
+	c.feed(packet[3:])
+	frame, err := codec.Decode(c)
+	require.NoError(t, err)
+	assert.Equal(t, packet[2:], frame)
+	header := c.Context().(*OpenVPNPacketHeader)
+	assert.Equal(t, &OpenVPNPacketHeader{Opcode: OpenVPNControlHardResetClientV2, KeyID: 0}, header)
+	assert.False(t, header.IsData())
+	assert.Zero(t, c.InboundBuffered())
+
+	out, err := codec.Encode(c, frame)
+	require.NoError(t, err)
+	assert.Equal(t, packet, out)
+
+	// P_DATA_V2 of key id 1.
+	c.feed([]byte{0x00, 0x04, 0x49, 0x00, 0x00, 0x01})
+	frame, err = codec.Decode(c)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x49, 0x00, 0x00, 0x01}, frame)
+	assert.Equal(t, &OpenVPNPacketHeader{Opcode: OpenVPNDataV2, KeyID: 1}, c.Context())
+	assert.True(t, c.Context().(*OpenVPNPacketHeader).IsData())
+
+	_, err = codec.Encode(c, []byte{0x00})
+	assert.ErrorIs(t, err, gerr.ErrMalformedFrame, "opcode 0 is unknown")
+	_, err = codec.Encode(c, nil)
+	assert.ErrorIs(t, err, gerr.ErrMalformedFrame)
+	_, err = codec.Encode(c, make([]byte, openVPNMaxPacketLength+1))
+	assert.ErrorIs(t, err, gerr.ErrEncodeLengthOverflow)
+
+	// an unknown opcode is rejected as soon as the header arrives.
+	c.feed([]byte{0x01, 0x00, 0xf8})
+	_, err = codec.Decode(c)
+	assert.ErrorIs(t, err, gerr.ErrMalformedFrame)
+
+	// a packet of no opcode.
+	c = &mockConn{}
+	c.feed([]byte{0x00, 0x00, 0x38})
+	_, err = codec.Decode(c)
+	assert.ErrorIs(t, err, gerr.ErrMalformedFrame)
+}
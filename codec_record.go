@@ -0,0 +1,102 @@
+// Copyright (c) 2019 Andy Pan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gnet
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+
+	"github.com/walkon/wsgnet/pkg/errors"
+)
+
+// recordCountLength is the length of the record count ahead of the records.
+const recordCountLength = 4
+
+// RecordCountCodec frames the bulks of fixed-size records, each of which is [4-byte record count][record]*count
+// where every record is exactly recordLength bytes. It's the length field preset of LengthFieldLength=4 and
+// LengthFieldUnit=recordLength with the record count stripped, so the bulks whose count×recordLength overflows
+// or goes beyond the limit are rejected by their headers before the records are buffered.
+//
+// Decode returns the records of a bulk as a single block of count×recordLength bytes, use DecodeRecords for the
+// individual records. Encode takes the records concatenated and prepends their count. RecordCountCodec is
+// stateless, so it can be shared between connections.
+type RecordCountCodec struct {
+	byteOrder    binary.ByteOrder
+	recordLength int
+	lfb          *LengthFieldBasedFrameCodec
+}
+
+// NewRecordCountCodec instantiates and returns a RecordCountCodec of the records of recordLength bytes, which must
+// be positive, counted in byteOrder. maxRecords is the limit of the records of a bulk, zero means up to 10MB.
+func NewRecordCountCodec(byteOrder binary.ByteOrder, recordLength, maxRecords int) *RecordCountCodec {
+	cc := &RecordCountCodec{byteOrder: byteOrder, recordLength: recordLength}
+	dc := DecoderConfig{
+		ByteOrder:           byteOrder,
+		LengthFieldLength:   recordCountLength,
+		LengthFieldUnit:     recordLength,
+		InitialBytesToStrip: recordCountLength,
+	}
+	if maxRecords > 0 && recordLength > 0 && maxRecords <= (math.MaxInt-recordCountLength)/recordLength {
+		dc.MaxFrameLength = recordCountLength + maxRecords*recordLength
+	}
+	cc.lfb = NewLengthFieldBasedFrameCodec(EncoderConfig{}, dc)
+	return cc
+}
+
+// RecordLength returns the length of each record.
+func (cc *RecordCountCodec) RecordLength() int {
+	return cc.recordLength
+}
+
+// Encode prepends the record count to buf, which is required to be the records concatenated.
+func (cc *RecordCountCodec) Encode(_ Conn, buf []byte) ([]byte, error) {
+	if cc.recordLength <= 0 {
+		return nil, fmt.Errorf("%w: record length %d", errors.ErrUnsupportedLength, cc.recordLength)
+	}
+	if len(buf)%cc.recordLength != 0 {
+		return nil, fmt.Errorf("%w: %d bytes of the %d-byte records", errors.ErrMalformedFrame, len(buf), cc.recordLength)
+	}
+	count := uint64(len(buf) / cc.recordLength)
+	if count > math.MaxUint32 {
+		return nil, fmt.Errorf("%w: %d records", errors.ErrEncodeLengthOverflow, count)
+	}
+	out := make([]byte, recordCountLength+len(buf))
+	cc.byteOrder.PutUint32(out, uint32(count))
+	copy(out[recordCountLength:], buf)
+	return out, nil
+}
+
+// Decode decodes the records of the next complete bulk as a single block.
+func (cc *RecordCountCodec) Decode(c Conn) ([]byte, error) {
+	if cc.recordLength <= 0 {
+		return nil, fmt.Errorf("%w: record length %d", errors.ErrUnsupportedLength, cc.recordLength)
+	}
+	return cc.lfb.Decode(c)
+}
+
+// DecodeRecords decodes the records of the next complete bulk, which are the sub-slices of a single block,
+// it returns nil records and nil error when more bytes are required to complete the bulk.
+func (cc *RecordCountCodec) DecodeRecords(c Conn) ([][]byte, error) {
+	block, err := cc.Decode(c)
+	if block == nil {
+		return nil, err
+	}
+	records := make([][]byte, 0, len(block)/cc.recordLength)
+	for off := 0; off < len(block); off += cc.recordLength {
+		records = append(records, block[off:off+cc.recordLength:off+cc.recordLength])
+	}
+	return records, nil
+}
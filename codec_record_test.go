@@ -0,0 +1,72 @@
+// Copyright (c) 2019 Andy Pan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gnet
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	gerr "github.com/walkon/wsgnet/pkg/errors"
+)
+
+func TestRecordCountCodec(t *testing.T) {
+	codec := NewRecordCountCodec(binary.BigEndian, 3, 4)
+	assert.Equal(t, 3, codec.RecordLength())
+	c := &mockConn{}
+
+	out, err := codec.Encode(c, []byte("abcdefghi"))
+	require.NoError(t, err)
+	assert.Equal(t, "\x00\x00\x00\x03abcdefghi", string(out))
+	_, err = codec.Encode(c, []byte("abcd"))
+	assert.ErrorIs(t, err, gerr.ErrMalformedFrame, "the records are whole")
+
+	// a bulk accumulates across the reads.
+	c.feed(out[:7])
+	records, err := codec.DecodeRecords(c)
+	assert.Nil(t, records)
+	assert.ErrorIs(t, err, io.ErrShortBuffer)
+	c.feed(out[7:])
+	records, err = codec.DecodeRecords(c)
+	require.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("abc"), []byte("def"), []byte("ghi")}, records)
+	assert.Equal(t, 3, cap(records[0]), "appending to a record doesn't clobber the next one")
+
+	// an empty bulk.
+	c.feed([]byte{0, 0, 0, 0})
+	block, err := codec.Decode(c)
+	require.NoError(t, err)
+	assert.NotNil(t, block)
+	assert.Empty(t, block)
+
+	// the count beyond maxRecords is rejected by the header.
+	c.feed([]byte{0, 0, 0, 5})
+	_, err = codec.Decode(c)
+	assert.ErrorIs(t, err, gerr.ErrFrameTooLarge)
+	_, _ = c.Discard(c.InboundBuffered())
+
+	// count×recordLength overflows.
+	codec = NewRecordCountCodec(binary.BigEndian, math.MaxInt/2, 0)
+	c.feed([]byte{0xFF, 0xFF, 0xFF, 0xFF})
+	_, err = codec.Decode(c)
+	assert.ErrorIs(t, err, gerr.ErrMalformedFrame)
+
+	_, err = NewRecordCountCodec(binary.BigEndian, 0, 0).Decode(c)
+	assert.ErrorIs(t, err, gerr.ErrUnsupportedLength)
+}
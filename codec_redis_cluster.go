@@ -0,0 +1,121 @@
+// Copyright (c) 2019 Andy Pan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gnet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/walkon/wsgnet/pkg/errors"
+)
+
+// The types of the Redis cluster bus messages.
+const (
+	RedisClusterMsgPing    = 0
+	RedisClusterMsgPong    = 1
+	RedisClusterMsgMeet    = 2
+	RedisClusterMsgFail    = 3
+	RedisClusterMsgPublish = 4
+)
+
+// redisClusterHeaderLength is the length of the leading fields of clusterMsg through count.
+const redisClusterHeaderLength = 16
+
+// redisClusterSignature starts every Redis cluster bus message.
+var redisClusterSignature = []byte("RCmb")
+
+// RedisClusterMessage is a Redis cluster bus message.
+type RedisClusterMessage struct {
+	// Version is the protocol version of the message.
+	Version uint16
+	// Type is the message type, RedisClusterMsgPing for instance.
+	Type uint16
+	// Message is the whole message including the header.
+	Message []byte
+}
+
+// RedisClusterCodec frames the binary messages of the Redis cluster bus, each of which starts with the fields
+// [4-byte signature "RCmb"][4-byte totlen][2-byte version][2-byte port][2-byte type][2-byte count] in big-endian,
+// where totlen counts the whole message. It's the length field preset of LengthFieldOffset=4, LengthFieldLength=4
+// and LengthAdjustment=-16, the rest of the 16 bytes are skipped by InterHeaderSkip, and nothing is stripped.
+//
+// Decode returns the whole message, use DecodeMessage for its type. RedisClusterCodec is stateless, so it
+// can be shared between connections.
+type RedisClusterCodec struct {
+	lfb *LengthFieldBasedFrameCodec
+}
+
+// NewRedisClusterCodec instantiates and returns a RedisClusterCodec.
+func NewRedisClusterCodec() *RedisClusterCodec {
+	return &RedisClusterCodec{lfb: NewLengthFieldBasedFrameCodec(EncoderConfig{}, DecoderConfig{
+		ByteOrder:           binary.BigEndian,
+		LengthFieldOffset:   4,
+		LengthFieldLength:   4,
+		LengthAdjustment:    -redisClusterHeaderLength,
+		InitialBytesToStrip: StripNone,
+		InterHeaderSkip:     redisClusterHeaderLength - 8,
+		VerifyInterHeader:   verifyRedisClusterHeader,
+	})}
+}
+
+// verifyRedisClusterHeader rejects a message by its signature before the rest of it is read.
+func verifyRedisClusterHeader(header, _ []byte) error {
+	if !bytes.Equal(header[:len(redisClusterSignature)], redisClusterSignature) {
+		return fmt.Errorf("%w: Redis cluster bus signature %q", errors.ErrMalformedFrame, header[:len(redisClusterSignature)])
+	}
+	return nil
+}
+
+// Encode validates that buf is a whole message assembled by the caller and passes it through.
+func (cc *RedisClusterCodec) Encode(_ Conn, buf []byte) ([]byte, error) {
+	if len(buf) < redisClusterHeaderLength {
+		return nil, fmt.Errorf("%w: Redis cluster bus message of %d bytes", errors.ErrShortFrame, len(buf))
+	}
+	if err := verifyRedisClusterHeader(buf, nil); err != nil {
+		return nil, err
+	}
+	if totlen := binary.BigEndian.Uint32(buf[4:]); int(totlen) != len(buf) {
+		return nil, fmt.Errorf("%w: Redis cluster bus totlen %d of a %d-byte message",
+			errors.ErrMalformedFrame, totlen, len(buf))
+	}
+	return buf, nil
+}
+
+// Decode decodes the next complete message including its header.
+func (cc *RedisClusterCodec) Decode(c Conn) ([]byte, error) {
+	msg, err := cc.DecodeMessage(c)
+	if msg == nil {
+		return nil, err
+	}
+	return msg.Message, nil
+}
+
+// DecodeMessage decodes the next complete message along with its version and type, it returns nil message
+// and nil error when more bytes are required to complete the message.
+func (cc *RedisClusterCodec) DecodeMessage(c Conn) (*RedisClusterMessage, error) {
+	in, msgLength, err := cc.lfb.peekFrame(c)
+	if in == nil {
+		return nil, err
+	}
+	msg := &RedisClusterMessage{
+		Version: binary.BigEndian.Uint16(in[8:]),
+		Type:    binary.BigEndian.Uint16(in[12:]),
+		Message: make([]byte, msgLength),
+	}
+	copy(msg.Message, in)
+	_, _ = c.Discard(msgLength)
+	return msg, nil
+}
@@ -0,0 +1,72 @@
+// Copyright (c) 2019 Andy Pan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gnet
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	gerr "github.com/walkon/wsgnet/pkg/errors"
+)
+
+func redisClusterMessage(msgType uint16, body string) []byte {
+	msg := make([]byte, redisClusterHeaderLength, redisClusterHeaderLength+len(body))
+	copy(msg, "RCmb")
+	binary.BigEndian.PutUint32(msg[4:], uint32(redisClusterHeaderLength+len(body)))
+	binary.BigEndian.PutUint16(msg[8:], 1)
+	binary.BigEndian.PutUint16(msg[10:], 6379)
+	binary.BigEndian.PutUint16(msg[12:], msgType)
+	return append(msg, body...)
+}
+
+func TestRedisClusterCodec(t *testing.T) {
+	codec := NewRedisClusterCodec()
+	ping := redisClusterMessage(RedisClusterMsgPing, "node-id-and-slots")
+	fail := redisClusterMessage(RedisClusterMsgFail, "")
+	out, err := codec.Encode(nil, ping)
+	require.NoError(t, err)
+	assert.Equal(t, ping, out)
+
+	c := &mockConn{}
+	c.feed(ping[:redisClusterHeaderLength])
+	msg, _ := codec.DecodeMessage(c)
+	assert.Nil(t, msg)
+	c.feed(ping[redisClusterHeaderLength:])
+	c.feed(fail)
+	msg, err = codec.DecodeMessage(c)
+	require.NoError(t, err)
+	assert.Equal(t, &RedisClusterMessage{Version: 1, Type: RedisClusterMsgPing, Message: ping}, msg)
+	frame, err := codec.Decode(c)
+	require.NoError(t, err)
+	assert.Equal(t, fail, frame)
+	assert.Zero(t, c.InboundBuffered())
+
+	// totlen must count at least the header.
+	short := redisClusterMessage(RedisClusterMsgPong, "")
+	binary.BigEndian.PutUint32(short[4:], 8)
+	c.feed(short)
+	_, err = codec.Decode(c)
+	assert.ErrorIs(t, err, gerr.ErrMalformedFrame)
+	_, err = codec.Encode(nil, short)
+	assert.ErrorIs(t, err, gerr.ErrMalformedFrame)
+
+	bad := redisClusterMessage(RedisClusterMsgPong, "")
+	copy(bad, "RESP")
+	_, err = codec.Decode(&mockConn{inbound: bad})
+	assert.ErrorIs(t, err, gerr.ErrMalformedFrame)
+}
@@ -0,0 +1,345 @@
+// Copyright (c) 2019 Andy Pan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gnet
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/walkon/wsgnet/pkg/errors"
+)
+
+const (
+	// RTMPDefaultChunkSize is the chunk size used by both sides until a Set Chunk Size message is received.
+	RTMPDefaultChunkSize = 128
+
+	// RTMPTypeSetChunkSize is the message type id of the protocol control message Set Chunk Size.
+	RTMPTypeSetChunkSize = 1
+	// RTMPTypeAbort is the message type id of the protocol control message Abort Message.
+	RTMPTypeAbort = 2
+
+	rtmpMaxChunkSize       = 0xFFFFFF
+	rtmpExtendedTimestamp  = 0xFFFFFF
+	rtmpExtendedTimeLength = 4
+)
+
+// rtmpMessageHeaderLength indexed by the chunk format type.
+var rtmpMessageHeaderLength = [4]int{11, 7, 3, 0}
+
+// RTMPMessage is a message assembled from the chunks of a chunk stream.
+type RTMPMessage struct {
+	// ChunkStreamID is the id of the chunk stream that carried the message.
+	ChunkStreamID uint32
+	// Timestamp is the absolute timestamp of the message.
+	Timestamp uint32
+	// TypeID is the message type id.
+	TypeID uint8
+	// StreamID is the message stream id.
+	StreamID uint32
+	// Payload is the message body.
+	Payload []byte
+}
+
+// rtmpChunkStream keeps the last header and the message being assembled on a chunk stream.
+type rtmpChunkStream struct {
+	timestamp uint32
+	delta     uint32
+	length    uint32
+	typeID    uint8
+	streamID  uint32
+	extended  bool
+	assembled bool
+	payload   []byte
+}
+
+// RTMPChunkCodec decodes the RTMP chunk stream into messages and encodes messages into chunks,
+// it is meant to be used after the RTMP handshake is done.
+//
+// RTMPChunkCodec keeps the state of the chunk streams, so it must not be shared between connections,
+// instantiate one per connection instead, by Conn.SetCodec() in EventHandler.OnOpen for instance.
+type RTMPChunkCodec struct {
+	inChunkSize      int
+	outChunkSize     int
+	maxMessageLength int
+	streams          map[uint32]*rtmpChunkStream
+}
+
+// NewRTMPChunkCodec instantiates and returns a RTMPChunkCodec with the default chunk size of both directions
+// and the inbound messages of up to 10MB.
+func NewRTMPChunkCodec() *RTMPChunkCodec {
+	return &RTMPChunkCodec{
+		inChunkSize:      RTMPDefaultChunkSize,
+		outChunkSize:     RTMPDefaultChunkSize,
+		maxMessageLength: defaultMaxFrameLength,
+		streams:          make(map[uint32]*rtmpChunkStream),
+	}
+}
+
+// SetMaxMessageLength sets the limit of the length of the inbound messages, the messages declaring a longer
+// length are rejected by errors.ErrFrameTooLarge as soon as the header of their first chunk arrives, so the
+// connection is supposed to be closed.
+func (cc *RTMPChunkCodec) SetMaxMessageLength(length int) {
+	if length > 0 {
+		cc.maxMessageLength = length
+	}
+}
+
+// SetOutChunkSize sets the chunk size of the outbound messages, the caller is responsible for sending
+// the Set Chunk Size message to the peer before the messages encoded with the new chunk size.
+func (cc *RTMPChunkCodec) SetOutChunkSize(size int) {
+	if size > 0 && size <= rtmpMaxChunkSize {
+		cc.outChunkSize = size
+	}
+}
+
+// Encode is not supported since a message can't be chunked without its RTMP header, use EncodeMessage instead.
+func (cc *RTMPChunkCodec) Encode(_ Conn, _ []byte) ([]byte, error) {
+	return nil, errors.ErrUnsupportedOp
+}
+
+// Decode decodes the payload of the next complete message, see DecodeMessage.
+func (cc *RTMPChunkCodec) Decode(c Conn) ([]byte, error) {
+	msg, err := cc.DecodeMessage(c)
+	if msg == nil {
+		return nil, err
+	}
+	return msg.Payload, nil
+}
+
+// DecodeN is like Decode but it also returns the number of bytes consumed, the chunks of the messages
+// not completed yet are consumed as well, so consumed may be non-zero when no payload is returned.
+func (cc *RTMPChunkCodec) DecodeN(c Conn) ([]byte, int, error) {
+	msg, consumed, err := cc.decodeMessage(c)
+	if msg == nil {
+		return nil, consumed, err
+	}
+	return msg.Payload, consumed, nil
+}
+
+// DecodeMessage consumes all complete chunks buffered in c until a message has been assembled,
+// it returns nil message and nil error when more bytes are required to complete a message.
+//
+// The protocol control messages Set Chunk Size and Abort Message are applied to the codec
+// before they are returned to the caller.
+func (cc *RTMPChunkCodec) DecodeMessage(c Conn) (*RTMPMessage, error) {
+	msg, _, err := cc.decodeMessage(c)
+	return msg, err
+}
+
+func (cc *RTMPChunkCodec) decodeMessage(c Conn) (*RTMPMessage, int, error) {
+	var consumed int
+	for {
+		msg, n, err := cc.decodeChunk(c)
+		consumed += n
+		if err != nil || n == 0 {
+			return nil, consumed, err
+		}
+		if msg != nil {
+			cc.control(msg)
+			return msg, consumed, nil
+		}
+	}
+}
+
+// decodeChunk consumes the next chunk if it's complete, it returns the number of bytes consumed
+// and the message completed by this chunk if any.
+func (cc *RTMPChunkCodec) decodeChunk(c Conn) (msg *RTMPMessage, n int, err error) {
+	// Only the bytes of the current chunk are peeked, the header first and then the whole chunk.
+	in, _ := c.Peek(1)
+	if len(in) < 1 {
+		return
+	}
+
+	format := in[0] >> 6
+	csid := uint32(in[0] & 0x3f)
+	basicLength := 1
+	switch csid {
+	case 0:
+		basicLength = 2
+	case 1:
+		basicLength = 3
+	}
+	headerEnd := basicLength + rtmpMessageHeaderLength[format]
+	if in, _ = c.Peek(headerEnd); len(in) < headerEnd {
+		return nil, 0, nil
+	}
+	switch csid {
+	case 0:
+		csid = uint32(in[1]) + 64
+	case 1:
+		csid = uint32(in[1]) + uint32(in[2])<<8 + 64
+	}
+
+	cs, ok := cc.streams[csid]
+	if !ok {
+		if format != 0 {
+			return nil, 0, fmt.Errorf("%w: chunk stream %d starts with format %d", errors.ErrMalformedFrame, csid, format)
+		}
+		cs = new(rtmpChunkStream)
+	}
+	next := *cs
+	next.assembled = false
+	h := in[basicLength:headerEnd]
+	var timestamp uint32
+	if format < 3 {
+		timestamp = uint32(readUint24(binary.BigEndian, h))
+		next.extended = timestamp == rtmpExtendedTimestamp
+	}
+	if format < 2 {
+		next.length = uint32(readUint24(binary.BigEndian, h[3:]))
+		next.typeID = h[6]
+		if int(next.length) > cc.maxMessageLength {
+			return nil, 0, fmt.Errorf("%w: %d-byte message of chunk stream %d beyond %d bytes",
+				errors.ErrFrameTooLarge, next.length, csid, cc.maxMessageLength)
+		}
+	}
+	if format == 0 {
+		next.streamID = binary.LittleEndian.Uint32(h[7:])
+	}
+	if next.extended {
+		if in, _ = c.Peek(headerEnd + rtmpExtendedTimeLength); len(in) < headerEnd+rtmpExtendedTimeLength {
+			return nil, 0, nil
+		}
+		if format < 3 {
+			timestamp = binary.BigEndian.Uint32(in[headerEnd:])
+		}
+		headerEnd += rtmpExtendedTimeLength
+	}
+	if format < 3 && len(cs.payload) > 0 && next.length != cs.length {
+		return nil, 0, fmt.Errorf("%w: message length of chunk stream %d changed in the middle of a message",
+			errors.ErrMalformedFrame, csid)
+	}
+
+	// The timestamp only advances when a new message starts.
+	if len(cs.payload) == 0 {
+		switch format {
+		case 0:
+			next.timestamp, next.delta = timestamp, 0
+		case 1, 2:
+			next.delta = timestamp
+			next.timestamp += timestamp
+		case 3:
+			next.timestamp += next.delta
+		}
+	}
+
+	chunkLength := int(next.length) - len(cs.payload)
+	if chunkLength > cc.inChunkSize {
+		chunkLength = cc.inChunkSize
+	}
+	n = headerEnd + chunkLength
+	if in, _ = c.Peek(n); len(in) < n {
+		return nil, 0, nil
+	}
+
+	// The payload grows as the chunks arrive rather than being allocated upon the declared length.
+	if len(cs.payload) == 0 {
+		next.payload = make([]byte, 0, chunkLength)
+	}
+	next.payload = append(next.payload, in[headerEnd:n]...)
+	if len(next.payload) == int(next.length) {
+		msg = &RTMPMessage{
+			ChunkStreamID: csid,
+			Timestamp:     next.timestamp,
+			TypeID:        next.typeID,
+			StreamID:      next.streamID,
+			Payload:       next.payload,
+		}
+		next.payload = nil
+	}
+	*cs = next
+	cc.streams[csid] = cs
+	_, _ = c.Discard(n)
+	return
+}
+
+func (cc *RTMPChunkCodec) control(msg *RTMPMessage) {
+	if msg.StreamID != 0 || len(msg.Payload) < 4 {
+		return
+	}
+	switch msg.TypeID {
+	case RTMPTypeSetChunkSize:
+		if size := int(binary.BigEndian.Uint32(msg.Payload) & 0x7fffffff); size > 0 {
+			if size > rtmpMaxChunkSize {
+				size = rtmpMaxChunkSize
+			}
+			cc.inChunkSize = size
+		}
+	case RTMPTypeAbort:
+		if cs, ok := cc.streams[binary.BigEndian.Uint32(msg.Payload)]; ok {
+			cs.payload = nil
+		}
+	}
+}
+
+// EncodeMessage encodes msg into chunks of the outbound chunk size, the first chunk carries
+// a full (format 0) header and the following chunks carry format 3 headers.
+func (cc *RTMPChunkCodec) EncodeMessage(msg *RTMPMessage) ([]byte, error) {
+	if msg.ChunkStreamID < 2 || msg.ChunkStreamID > 65599 {
+		return nil, fmt.Errorf("%w: chunk stream id %d is out of range", errors.ErrMalformedFrame, msg.ChunkStreamID)
+	}
+	if len(msg.Payload) > rtmpMaxChunkSize {
+		return nil, fmt.Errorf("%w: %d into a medium integer", errors.ErrEncodeLengthOverflow, len(msg.Payload))
+	}
+
+	extended := msg.Timestamp >= rtmpExtendedTimestamp
+	chunks := (len(msg.Payload) + cc.outChunkSize - 1) / cc.outChunkSize
+	if chunks == 0 {
+		chunks = 1
+	}
+	out := make([]byte, 0, len(msg.Payload)+chunks*(3+rtmpExtendedTimeLength)+rtmpMessageHeaderLength[0])
+
+	var ext [rtmpExtendedTimeLength]byte
+	binary.BigEndian.PutUint32(ext[:], msg.Timestamp)
+	var header [11]byte
+	timestamp := msg.Timestamp
+	if extended {
+		timestamp = rtmpExtendedTimestamp
+	}
+	writeUint24(binary.BigEndian, int(timestamp), header[:])
+	writeUint24(binary.BigEndian, len(msg.Payload), header[3:])
+	header[6] = msg.TypeID
+	binary.LittleEndian.PutUint32(header[7:], msg.StreamID)
+
+	payload := msg.Payload
+	for format := byte(0); ; format = 3 {
+		out = appendRTMPBasicHeader(out, format, msg.ChunkStreamID)
+		if format == 0 {
+			out = append(out, header[:]...)
+		}
+		if extended {
+			out = append(out, ext[:]...)
+		}
+		n := len(payload)
+		if n > cc.outChunkSize {
+			n = cc.outChunkSize
+		}
+		out = append(out, payload[:n]...)
+		if payload = payload[n:]; len(payload) == 0 {
+			return out, nil
+		}
+	}
+}
+
+func appendRTMPBasicHeader(out []byte, format byte, csid uint32) []byte {
+	switch {
+	case csid < 64:
+		return append(out, format<<6|byte(csid))
+	case csid < 320:
+		return append(out, format<<6, byte(csid-64))
+	default:
+		return append(out, format<<6|1, byte(csid-64), byte((csid-64)>>8))
+	}
+}
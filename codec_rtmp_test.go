@@ -0,0 +1,161 @@
+// Copyright (c) 2019 Andy Pan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gnet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	gerr "github.com/walkon/wsgnet/pkg/errors"
+)
+
+func TestRTMPChunkCodecRoundTrip(t *testing.T) {
+	enc, dec := NewRTMPChunkCodec(), NewRTMPChunkCodec()
+	msgs := []*RTMPMessage{
+		{ChunkStreamID: 3, Timestamp: 1000, TypeID: 20, StreamID: 1, Payload: bytes.Repeat([]byte{'a'}, 300)},
+		{ChunkStreamID: 100, Timestamp: 2000, TypeID: 9, StreamID: 1, Payload: []byte("video")},
+		{ChunkStreamID: 1000, Timestamp: 0x01000000, TypeID: 8, StreamID: 1, Payload: bytes.Repeat([]byte{'b'}, 129)},
+		{ChunkStreamID: 4, Timestamp: 3000, TypeID: 18, StreamID: 1, Payload: []byte{}},
+	}
+	c := &mockConn{}
+	for _, msg := range msgs {
+		out, err := enc.EncodeMessage(msg)
+		require.NoError(t, err)
+		c.feed(out)
+	}
+
+	// feed byte by byte to make sure partial chunks are never consumed.
+	all := c.inbound
+	c.inbound = nil
+	var got []*RTMPMessage
+	for _, b := range all {
+		c.feed([]byte{b})
+		msg, err := dec.DecodeMessage(c)
+		require.NoError(t, err)
+		if msg != nil {
+			got = append(got, msg)
+		}
+	}
+	assert.Equal(t, msgs, got)
+	assert.Zero(t, c.InboundBuffered())
+}
+
+func TestRTMPChunkCodecHeaderFormats(t *testing.T) {
+	dec := NewRTMPChunkCodec()
+	c := &mockConn{}
+	// format 0 on chunk stream 3: timestamp 1000, length 4, type 9, stream 1.
+	c.feed([]byte{0x03, 0x00, 0x03, 0xE8, 0x00, 0x00, 0x04, 0x09, 0x01, 0x00, 0x00, 0x00})
+	c.feed([]byte("win1"))
+	// format 1: timestamp delta 40, length 2, type 8.
+	c.feed([]byte{0x43, 0x00, 0x00, 0x28, 0x00, 0x00, 0x02, 0x08})
+	c.feed([]byte("w2"))
+	// format 2: timestamp delta 20.
+	c.feed([]byte{0x83, 0x00, 0x00, 0x14})
+	c.feed([]byte("w3"))
+	// format 3: reuses the delta of 20.
+	c.feed([]byte{0xC3})
+	c.feed([]byte("w4"))
+
+	expected := []RTMPMessage{
+		{ChunkStreamID: 3, Timestamp: 1000, TypeID: 9, StreamID: 1, Payload: []byte("win1")},
+		{ChunkStreamID: 3, Timestamp: 1040, TypeID: 8, StreamID: 1, Payload: []byte("w2")},
+		{ChunkStreamID: 3, Timestamp: 1060, TypeID: 8, StreamID: 1, Payload: []byte("w3")},
+		{ChunkStreamID: 3, Timestamp: 1080, TypeID: 8, StreamID: 1, Payload: []byte("w4")},
+	}
+	for _, e := range expected {
+		msg, err := dec.DecodeMessage(c)
+		require.NoError(t, err)
+		require.NotNil(t, msg)
+		assert.Equal(t, e, *msg)
+	}
+	msg, err := dec.DecodeMessage(c)
+	assert.NoError(t, err)
+	assert.Nil(t, msg)
+
+	// a new chunk stream can't start without a full header.
+	c.feed([]byte{0xC5})
+	_, err = dec.Decode(c)
+	assert.ErrorIs(t, err, gerr.ErrMalformedFrame)
+}
+
+func TestRTMPChunkCodecSetChunkSize(t *testing.T) {
+	enc, dec := NewRTMPChunkCodec(), NewRTMPChunkCodec()
+	c := &mockConn{}
+	size := make([]byte, 4)
+	binary.BigEndian.PutUint32(size, 4096)
+	out, err := enc.EncodeMessage(&RTMPMessage{ChunkStreamID: 2, TypeID: RTMPTypeSetChunkSize, Payload: size})
+	require.NoError(t, err)
+	c.feed(out)
+	enc.SetOutChunkSize(4096)
+
+	payload := bytes.Repeat([]byte{'x'}, 4000)
+	out, err = enc.EncodeMessage(&RTMPMessage{ChunkStreamID: 6, TypeID: 9, StreamID: 1, Payload: payload})
+	require.NoError(t, err)
+	assert.Len(t, out, 12+len(payload), "the message should fit into a single chunk")
+	c.feed(out)
+
+	frame, err := dec.Decode(c)
+	require.NoError(t, err)
+	assert.Equal(t, size, frame)
+	frame, err = dec.Decode(c)
+	require.NoError(t, err)
+	assert.Equal(t, payload, frame)
+
+	_, err = dec.Encode(c, payload)
+	assert.ErrorIs(t, err, gerr.ErrUnsupportedOp)
+}
+
+func TestRTMPChunkCodecDecodeN(t *testing.T) {
+	enc, dec := NewRTMPChunkCodec(), NewRTMPChunkCodec()
+	out, err := enc.EncodeMessage(&RTMPMessage{ChunkStreamID: 3, TypeID: 9, StreamID: 1, Payload: bytes.Repeat([]byte{'v'}, 200)})
+	require.NoError(t, err)
+	c := &mockConn{}
+	// the full first chunk and part of the second one.
+	split := 12 + RTMPDefaultChunkSize + 3
+	c.feed(out[:split])
+	payload, consumed, err := dec.DecodeN(c)
+	require.NoError(t, err)
+	assert.Nil(t, payload)
+	assert.Equal(t, 12+RTMPDefaultChunkSize, consumed, "the complete chunk should be consumed")
+
+	c.feed(out[split:])
+	payload, consumed, err = dec.DecodeN(c)
+	require.NoError(t, err)
+	assert.Len(t, payload, 200)
+	assert.Equal(t, len(out)-12-RTMPDefaultChunkSize, consumed)
+}
+
+func TestRTMPChunkCodecMaxMessageLength(t *testing.T) {
+	dec := NewRTMPChunkCodec()
+	dec.SetMaxMessageLength(8)
+	c := &mockConn{}
+	// format 0 on chunk stream 3 declaring a 16MB-1 message.
+	c.feed([]byte{0x03, 0x00, 0x00, 0x00, 0xFF, 0xFF, 0xFF, 0x09, 0x01, 0x00, 0x00, 0x00})
+	msg, err := dec.DecodeMessage(c)
+	assert.ErrorIs(t, err, gerr.ErrFrameTooLarge)
+	assert.Nil(t, msg)
+
+	dec, c = NewRTMPChunkCodec(), &mockConn{}
+	dec.SetMaxMessageLength(8)
+	c.feed([]byte{0x03, 0x00, 0x00, 0x00, 0x00, 0x00, 0x08, 0x09, 0x01, 0x00, 0x00, 0x00})
+	c.feed([]byte("12345678"))
+	msg, err = dec.DecodeMessage(c)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("12345678"), msg.Payload)
+}
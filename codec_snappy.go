@@ -0,0 +1,189 @@
+// Copyright (c) 2019 Andy Pan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gnet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+
+	"github.com/walkon/wsgnet/internal/snappy"
+	"github.com/walkon/wsgnet/pkg/errors"
+)
+
+// The chunk types of the Snappy framing format.
+const (
+	snappyChunkCompressed   = 0x00
+	snappyChunkUncompressed = 0x01
+	snappyChunkStreamID     = 0xff
+
+	// snappyChunkHeaderLength is the length of [1-byte chunk type][3-byte little-endian length].
+	snappyChunkHeaderLength = 4
+	// snappyMaxBlockLength is the limit of the uncompressed data of a chunk.
+	snappyMaxBlockLength = 65536
+	// snappyMaxChunkLength is the limit of the length of a compressed or uncompressed chunk,
+	// the checksum plus the worst case of compressing snappyMaxBlockLength bytes.
+	snappyMaxChunkLength = 4 + 32 + snappyMaxBlockLength + snappyMaxBlockLength/6
+)
+
+// snappyStreamID is the stream identifier chunk starting every Snappy stream.
+var snappyStreamID = []byte{snappyChunkStreamID, 0x06, 0x00, 0x00, 's', 'N', 'a', 'P', 'p', 'Y'}
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// snappyStreamKey is the key of the per-connection state of a SnappyCodec stored by Conn.SetCodecScratch,
+// which tells whether the stream identifier has been sent by encoder or received otherwise.
+type snappyStreamKey struct {
+	cc      *SnappyCodec
+	encoder bool
+}
+
+// SnappyCodec speaks the Snappy framing format, see https://github.com/google/snappy/blob/main/framing_format.txt,
+// where a stream starts with the stream identifier chunk and goes on with the chunks of
+// [1-byte chunk type][3-byte little-endian length][data], the compressed and uncompressed chunks carrying the masked
+// CRC-32C of the uncompressed data at the start of their data. The chunks are framed by the length field preset
+// LengthFieldOffset=1, LengthFieldLength=3 and nothing is stripped.
+//
+// Decode skips the stream identifier, the padding and the skippable chunks, and returns the uncompressed data of
+// the next compressed or uncompressed chunk, a reserved unskippable chunk is rejected by ErrMalformedFrame.
+// Encode prepends the stream identifier to the first output of a connection and splits buf into chunks of
+// at most 64KB, each of which is compressed unless that doesn't make it smaller.
+//
+// Note that Decode and Encode are asymmetric for the buffers beyond 64KB: the framing format carries no boundary
+// of the buffers split into chunks, so a buffer of more than 64KB comes out of Decode as the data of several
+// chunks, one per call, which must be concatenated by the receiver if the boundaries matter, or framed by another
+// codec inside the Snappy stream.
+//
+// The stream identifier is tracked per connection by Conn.SetCodecScratch, so SnappyCodec can be shared
+// between connections.
+type SnappyCodec struct {
+	lfb *LengthFieldBasedFrameCodec
+}
+
+// NewSnappyCodec instantiates and returns a SnappyCodec.
+func NewSnappyCodec() *SnappyCodec {
+	return &SnappyCodec{lfb: NewLengthFieldBasedFrameCodec(EncoderConfig{}, DecoderConfig{
+		ByteOrder:           binary.LittleEndian,
+		LengthFieldOffset:   1,
+		LengthFieldLength:   3,
+		InitialBytesToStrip: StripNone,
+		VerifyInterHeader:   verifySnappyChunkHeader,
+	})}
+}
+
+// verifySnappyChunkHeader rejects a chunk by its type and length before the rest of it is read.
+func verifySnappyChunkHeader(header, _ []byte) error {
+	length := readUint24(binary.LittleEndian, header[1:])
+	switch chunkType := header[0]; {
+	case chunkType == snappyChunkCompressed || chunkType == snappyChunkUncompressed:
+		if length < 4 || length > snappyMaxChunkLength {
+			return fmt.Errorf("%w: Snappy chunk of %d bytes", errors.ErrMalformedFrame, length)
+		}
+	case chunkType == snappyChunkStreamID:
+		if int(length) != len(snappyStreamID)-snappyChunkHeaderLength {
+			return fmt.Errorf("%w: Snappy stream identifier of %d bytes", errors.ErrMalformedFrame, length)
+		}
+	case chunkType < 0x80:
+		return fmt.Errorf("%w: reserved unskippable Snappy chunk type %#x", errors.ErrMalformedFrame, chunkType)
+	}
+	return nil
+}
+
+// maskedCRC32C returns the masked CRC-32C of b the Snappy framing format checksums the uncompressed data with.
+func maskedCRC32C(b []byte) uint32 {
+	c := crc32.Checksum(b, crc32cTable)
+	return (c>>15 | c<<17) + 0xa282ead8
+}
+
+// Encode compresses buf into the chunks of at most 64KB each, it's prepended by the stream identifier if it's
+// the first output of c.
+func (cc *SnappyCodec) Encode(c Conn, buf []byte) ([]byte, error) {
+	var out []byte
+	key := snappyStreamKey{cc, true}
+	if c == nil || c.CodecScratch(key) == nil {
+		out = append(out, snappyStreamID...)
+		if c != nil {
+			c.SetCodecScratch(key, true)
+		}
+	}
+	for {
+		block := buf
+		if len(block) > snappyMaxBlockLength {
+			block = block[:snappyMaxBlockLength]
+		}
+		buf = buf[len(block):]
+
+		chunkType, data := byte(snappyChunkUncompressed), block
+		if compressed := snappy.Encode(block); len(compressed) < len(block) {
+			chunkType, data = snappyChunkCompressed, compressed
+		}
+		out = append(out, chunkType, 0, 0, 0)
+		writeUint24(binary.LittleEndian, 4+len(data), out[len(out)-3:])
+		out = binary.LittleEndian.AppendUint32(out, maskedCRC32C(block))
+		out = append(out, data...)
+		if len(buf) == 0 {
+			return out, nil
+		}
+	}
+}
+
+// Decode decodes the uncompressed data of the next compressed or uncompressed chunk, which is at most 64KB
+// rather than a whole buffer passed to Encode, the other chunks ahead of it are consumed, it returns nil
+// and nil error when more bytes are required.
+func (cc *SnappyCodec) Decode(c Conn) ([]byte, error) {
+	key := snappyStreamKey{cc, false}
+	for {
+		chunk, msgLength, err := cc.lfb.peekFrame(c)
+		if chunk == nil {
+			return nil, err
+		}
+		chunkType, data := chunk[0], chunk[snappyChunkHeaderLength:]
+		if chunkType == snappyChunkStreamID {
+			if !bytes.Equal(chunk, snappyStreamID) {
+				return nil, fmt.Errorf("%w: Snappy stream identifier %q", errors.ErrMalformedFrame, data)
+			}
+			c.SetCodecScratch(key, true)
+			_, _ = c.Discard(msgLength)
+			continue
+		}
+		if c.CodecScratch(key) == nil {
+			return nil, fmt.Errorf("%w: Snappy stream without the stream identifier", errors.ErrMalformedFrame)
+		}
+
+		var block []byte
+		switch chunkType {
+		case snappyChunkCompressed:
+			if block, err = snappy.Decode(data[4:], snappyMaxBlockLength); err != nil {
+				return nil, fmt.Errorf("%w: %v", errors.ErrMalformedFrame, err)
+			}
+		case snappyChunkUncompressed:
+			if len(data)-4 > snappyMaxBlockLength {
+				return nil, fmt.Errorf("%w: Snappy uncompressed chunk of %d bytes", errors.ErrMalformedFrame, len(data)-4)
+			}
+			block = make([]byte, len(data)-4)
+			copy(block, data[4:])
+		default:
+			// the padding and the skippable chunks.
+			_, _ = c.Discard(msgLength)
+			continue
+		}
+		_, _ = c.Discard(msgLength)
+		if maskedCRC32C(block) != binary.LittleEndian.Uint32(data) {
+			return nil, errors.ErrInvalidChecksum
+		}
+		return block, nil
+	}
+}
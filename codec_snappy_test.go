@@ -0,0 +1,90 @@
+// Copyright (c) 2019 Andy Pan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gnet
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	gerr "github.com/walkon/wsgnet/pkg/errors"
+)
+
+func TestSnappyCodec(t *testing.T) {
+	codec := NewSnappyCodec()
+	c := &mockConn{}
+	small := []byte("tiny")
+	large := bytes.Repeat([]byte("snappy "), 20000)
+	var stream []byte
+	for _, payload := range [][]byte{small, large} {
+		out, err := codec.Encode(c, payload)
+		require.NoError(t, err)
+		stream = append(stream, out...)
+	}
+	// the stream identifier goes first and only once, the small block is sent uncompressed.
+	assert.Equal(t, snappyStreamID, stream[:len(snappyStreamID)])
+	assert.Equal(t, []byte{snappyChunkUncompressed, 8, 0, 0}, stream[len(snappyStreamID):len(snappyStreamID)+4])
+	assert.Equal(t, 1, bytes.Count(stream, snappyStreamID))
+	assert.Less(t, len(stream), len(large)/10)
+
+	// a padding chunk and a skippable chunk in between are skipped.
+	stream = append(stream, 0xfe, 2, 0, 0, 0, 0, 0x80, 1, 0, 0, 'x')
+	out, err := codec.Encode(c, nil)
+	require.NoError(t, err)
+	stream = append(stream, out...)
+
+	// feed byte by byte to make sure partial chunks are never consumed.
+	var got [][]byte
+	for _, b := range stream {
+		c.feed([]byte{b})
+		for {
+			block, err := codec.Decode(c)
+			if err == io.ErrShortBuffer {
+				break
+			}
+			require.NoError(t, err)
+			if block == nil {
+				break
+			}
+			got = append(got, block)
+		}
+	}
+	// the large payload is split into the blocks of 64KB.
+	want := [][]byte{small}
+	for rest := large; len(rest) > 0; {
+		n := len(rest)
+		if n > snappyMaxBlockLength {
+			n = snappyMaxBlockLength
+		}
+		want, rest = append(want, rest[:n]), rest[n:]
+	}
+	want = append(want, []byte{})
+	assert.Equal(t, want, got)
+	assert.Equal(t, large, bytes.Join(got[1:len(got)-1], nil), "the receiver concatenates the blocks")
+	assert.Zero(t, c.InboundBuffered())
+
+	c.feed([]byte{snappyChunkUncompressed, 5, 0, 0, 0, 0, 0, 0, 'x'})
+	_, err = codec.Decode(c)
+	assert.ErrorIs(t, err, gerr.ErrInvalidChecksum)
+	c.feed([]byte{0x02, 0, 0, 0})
+	_, err = codec.Decode(c)
+	assert.ErrorIs(t, err, gerr.ErrMalformedFrame, "a reserved unskippable chunk must be rejected")
+
+	_, err = NewSnappyCodec().Decode(&mockConn{inbound: []byte{snappyChunkUncompressed, 4, 0, 0, 0, 0, 0, 0}})
+	assert.ErrorIs(t, err, gerr.ErrMalformedFrame, "a stream must start with the stream identifier")
+}
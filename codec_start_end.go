@@ -0,0 +1,134 @@
+// Copyright (c) 2019 Andy Pan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gnet
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/walkon/wsgnet/pkg/errors"
+)
+
+const (
+	// ASCIISTX is the start of text of ASCII, which starts the frames of many serial-line protocols.
+	ASCIISTX = 0x02
+	// ASCIIETX is the end of text of ASCII, which ends the frames started by ASCIISTX.
+	ASCIIETX = 0x03
+)
+
+// startEndScanKey is the key of the number of the bytes of the buffered frame that have been scanned
+// for the end delimiter without finding it, stored by Conn.SetCodecScratch.
+type startEndScanKey struct {
+	cc *StartEndFrameCodec
+}
+
+// StartEndFrameCodec frames the protocols wrapping each frame in a start and an end delimiter,
+// [STX][payload][ETX] for instance, where the payload contains neither of the delimiters.
+//
+// Decode resynchronizes to the frames by discarding the garbage ahead of the start delimiter, and a start
+// delimiter found before the end delimiter restarts the frame, dropping the one missing its end. A frame
+// whose end delimiter is missing within maxFrameLength bytes of payload is dropped up to the next start
+// delimiter with errors.ErrFrameTooLarge, after which the connection can keep decoding the following frames.
+// Encode wraps buf in the delimiters, which must not be contained by buf.
+//
+// The bytes scanned for the end delimiter of an incomplete frame are tracked per connection by
+// Conn.SetCodecScratch, so StartEndFrameCodec can be shared between connections.
+type StartEndFrameCodec struct {
+	start, end     byte
+	maxFrameLength int
+}
+
+// NewStartEndFrameCodec instantiates and returns a StartEndFrameCodec of the start and end delimiters, which must
+// differ, maxFrameLength is the limit of the payload, which defaults to 10MB if it's not positive.
+func NewStartEndFrameCodec(start, end byte, maxFrameLength int) *StartEndFrameCodec {
+	if maxFrameLength <= 0 {
+		maxFrameLength = defaultMaxFrameLength
+	}
+	return &StartEndFrameCodec{start: start, end: end, maxFrameLength: maxFrameLength}
+}
+
+// Encode wraps buf in the start and end delimiters.
+func (cc *StartEndFrameCodec) Encode(_ Conn, buf []byte) ([]byte, error) {
+	if len(buf) > cc.maxFrameLength {
+		return nil, fmt.Errorf("%w: %w: %d-byte frame beyond %d bytes",
+			errors.ErrEncodeLengthOverflow, errors.ErrFrameTooLarge, len(buf), cc.maxFrameLength)
+	}
+	if bytes.IndexByte(buf, cc.start) >= 0 || bytes.IndexByte(buf, cc.end) >= 0 {
+		return nil, fmt.Errorf("%w: frame containing the delimiters %#x and %#x", errors.ErrMalformedFrame, cc.start, cc.end)
+	}
+	out := make([]byte, len(buf)+2)
+	out[0] = cc.start
+	copy(out[1:], buf)
+	out[len(out)-1] = cc.end
+	return out, nil
+}
+
+// Decode decodes the payload of the next frame, it returns nil and nil error when the end delimiter
+// of the next frame hasn't arrived yet.
+func (cc *StartEndFrameCodec) Decode(c Conn) ([]byte, error) {
+	if cc.start == cc.end {
+		return nil, fmt.Errorf("%w: identical delimiters %#x", errors.ErrUnsupportedOp, cc.start)
+	}
+	key := startEndScanKey{cc}
+	in, _ := c.Peek(c.InboundBuffered())
+	// Resynchronize to the start delimiter.
+	if i := bytes.IndexByte(in, cc.start); i != 0 {
+		if i < 0 {
+			i = len(in)
+		}
+		_, _ = c.Discard(i)
+		c.SetCodecScratch(key, 0)
+		if in = in[i:]; len(in) == 0 {
+			return nil, nil
+		}
+	}
+	scanned, _ := c.CodecScratch(key).(int)
+	if scanned < 1 || scanned > len(in) {
+		scanned = 1
+	}
+	i := bytes.IndexByte(in[scanned:], cc.end)
+	if i < 0 {
+		if len(in)-1 > cc.maxFrameLength {
+			return nil, cc.drop(c, in, len(in)-1)
+		}
+		c.SetCodecScratch(key, len(in))
+		return nil, nil
+	}
+	c.SetCodecScratch(key, 0)
+	end := scanned + i
+	// A start delimiter ahead of the end one restarts the frame.
+	if restart := bytes.LastIndexByte(in[1:end], cc.start); restart >= 0 {
+		_, _ = c.Discard(1 + restart)
+		in, end = in[1+restart:], end-1-restart
+	}
+	if end-1 > cc.maxFrameLength {
+		return nil, cc.drop(c, in, end-1)
+	}
+	frame := make([]byte, end-1)
+	copy(frame, in[1:end])
+	_, _ = c.Discard(end + 1)
+	return frame, nil
+}
+
+// drop drops the frame of in going beyond maxFrameLength up to the next start delimiter.
+func (cc *StartEndFrameCodec) drop(c Conn, in []byte, frameLength int) error {
+	n := len(in)
+	if next := bytes.IndexByte(in[1:], cc.start); next >= 0 {
+		n = 1 + next
+	}
+	_, _ = c.Discard(n)
+	c.SetCodecScratch(startEndScanKey{cc}, 0)
+	return fmt.Errorf("%w: %d-byte frame beyond %d bytes", errors.ErrFrameTooLarge, frameLength, cc.maxFrameLength)
+}
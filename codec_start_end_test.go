@@ -0,0 +1,77 @@
+// Copyright (c) 2019 Andy Pan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gnet
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	gerr "github.com/walkon/wsgnet/pkg/errors"
+)
+
+func TestStartEndFrameCodec(t *testing.T) {
+	codec := NewStartEndFrameCodec(ASCIISTX, ASCIIETX, 4)
+	c := &mockConn{}
+
+	out, err := codec.Encode(c, []byte("ab"))
+	require.NoError(t, err)
+	assert.Equal(t, "\x02ab\x03", string(out))
+	_, err = codec.Encode(c, []byte("a\x03"))
+	assert.ErrorIs(t, err, gerr.ErrMalformedFrame)
+	_, err = codec.Encode(c, []byte("abcde"))
+	assert.ErrorIs(t, err, gerr.ErrEncodeLengthOverflow)
+	assert.ErrorIs(t, err, gerr.ErrFrameTooLarge)
+
+	// the garbage ahead of STX is discarded, and the frame accumulates across the reads.
+	c.feed([]byte("noise"))
+	frame, err := codec.Decode(c)
+	require.NoError(t, err)
+	assert.Nil(t, frame)
+	assert.Zero(t, c.InboundBuffered())
+	for _, chunk := range []string{"xx\x02a", "b", "c\x03\x02\x03"} {
+		c.feed([]byte(chunk))
+		frame, err = codec.Decode(c)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, "abc", string(frame))
+	frame, err = codec.Decode(c)
+	require.NoError(t, err)
+	assert.NotNil(t, frame)
+	assert.Empty(t, frame)
+
+	// a frame missing its ETX is restarted by the next STX.
+	c.feed([]byte("\x02lost\x02ok\x03"))
+	frame, err = codec.Decode(c)
+	require.NoError(t, err)
+	assert.Equal(t, "ok", string(frame))
+
+	// ETX missing within the window, the decoding resumes at the next STX.
+	c.feed([]byte("\x02abcde"))
+	frame, err = codec.Decode(c)
+	assert.Nil(t, frame)
+	assert.ErrorIs(t, err, gerr.ErrFrameTooLarge)
+	c.feed([]byte("\x03\x02abcdef\x03\x02next\x03"))
+	_, err = codec.Decode(c)
+	assert.ErrorIs(t, err, gerr.ErrFrameTooLarge)
+	frame, err = codec.Decode(c)
+	require.NoError(t, err)
+	assert.Equal(t, "next", string(frame))
+	assert.Zero(t, c.InboundBuffered())
+
+	_, err = NewStartEndFrameCodec('|', '|', 0).Decode(c)
+	assert.ErrorIs(t, err, gerr.ErrUnsupportedOp)
+}
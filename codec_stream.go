@@ -0,0 +1,213 @@
+// Copyright (c) 2019 Andy Pan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gnet
+
+import (
+	"bytes"
+	"io"
+	"sync"
+
+	"github.com/walkon/wsgnet/pkg/errors"
+)
+
+// DefaultFrameStreamBufferCap is the maximum number of bytes a FrameStream buffers before
+// it stops draining the connection and waits for the reader to catch up.
+var DefaultFrameStreamBufferCap = 64 * 1024 // 64KB
+
+// FrameStream is an io.Reader bounded to the body of a single frame, it is fed with the bytes of
+// the frame by the event-loop as they arrive and read by another goroutine, it makes it possible
+// to process a large frame like json.NewDecoder(stream) without materializing the whole frame.
+//
+// Read blocks until more bytes of the frame arrive, thus a FrameStream must never be read in
+// the event-loop goroutine, hand it over to an individual goroutine instead.
+type FrameStream struct {
+	mu        sync.Mutex
+	cond      *sync.Cond
+	c         Conn
+	buf       bytes.Buffer
+	bufferCap int
+	skip      int   // bytes to skip from the connection before feeding the stream
+	remaining int   // bytes of the frame still in the connection
+	paused    bool  // stopped draining the connection due to bufferCap
+	err       error // error to return once buf is drained
+}
+
+func newFrameStream(c Conn, prefix []byte, skip, remaining int) *FrameStream {
+	fs := &FrameStream{c: c, bufferCap: DefaultFrameStreamBufferCap, skip: skip, remaining: remaining}
+	fs.cond = sync.NewCond(&fs.mu)
+	fs.buf.Write(prefix)
+	return fs
+}
+
+// Feed moves the bytes of the frame buffered in c into the stream, it must be called in the event-loop
+// goroutine, typically in EventHandler.OnTraffic, until it returns true which means the whole frame
+// has been fed and the following bytes in c belong to the next frame.
+//
+// When the stream holds more than DefaultFrameStreamBufferCap bytes, Feed leaves the bytes in c
+// and the reader calls Conn.Wake() to get OnTraffic fired again after it has caught up.
+func (fs *FrameStream) Feed(c Conn) (done bool) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if fs.skip > 0 {
+		n, _ := c.Discard(fs.skip)
+		fs.skip -= n
+		if fs.skip > 0 {
+			return false
+		}
+	}
+
+	n := fs.remaining
+	if buffered := c.InboundBuffered(); n > buffered {
+		n = buffered
+	}
+	if room := fs.bufferCap - fs.buf.Len(); n > room {
+		n = room
+	}
+	if n > 0 {
+		in, _ := c.Peek(n)
+		fs.buf.Write(in)
+		_, _ = c.Discard(n)
+		fs.remaining -= n
+		fs.cond.Broadcast()
+	}
+	fs.paused = fs.remaining > 0 && fs.buf.Len() >= fs.bufferCap
+	if fs.remaining == 0 && fs.err == nil {
+		fs.err = io.EOF
+		fs.cond.Broadcast()
+	}
+	return fs.remaining == 0
+}
+
+// CloseWithError aborts the stream, the pending and following reads return err once the bytes
+// fed so far are drained, it is usually called in EventHandler.OnClose.
+func (fs *FrameStream) CloseWithError(err error) {
+	if err == nil {
+		err = io.ErrUnexpectedEOF
+	}
+	fs.mu.Lock()
+	if fs.remaining > 0 {
+		fs.err = err
+	}
+	fs.cond.Broadcast()
+	fs.mu.Unlock()
+}
+
+// Read implements io.Reader, it returns io.EOF at the end of the frame.
+func (fs *FrameStream) Read(p []byte) (n int, err error) {
+	fs.mu.Lock()
+	for fs.buf.Len() == 0 && fs.err == nil {
+		fs.cond.Wait()
+	}
+	if fs.buf.Len() == 0 {
+		err = fs.err
+		fs.mu.Unlock()
+		return
+	}
+	n, _ = fs.buf.Read(p)
+	wake := fs.paused && fs.buf.Len() <= fs.bufferCap/2
+	if wake {
+		fs.paused = false
+	}
+	fs.mu.Unlock()
+
+	if wake {
+		_ = fs.c.Wake(nil)
+	}
+	return
+}
+
+// DecodeStream decodes the header of the next frame and returns a FrameStream of its body instead
+// of the whole frame, it returns nil stream and nil error until the header is complete.
+// The rest of the frame must be fed to the stream by FrameStream.Feed() before decoding the next frame.
+//
+// The header goes through the same checks as Decode, MaxFrameLength and OnHeader included, and the sequence
+// number and the length delta are tracked once the header is consumed, which waits for the sequence number
+// if it lies beyond the header. DecodeStream doesn't support checksums since they can't be verified before
+// the whole frame is read.
+func (cc *LengthFieldBasedFrameCodec) DecodeStream(c Conn) (*FrameStream, error) {
+	if cc.decoderConfig.CRCScope != CRCNone {
+		return nil, errors.ErrUnsupportedOp
+	}
+	if cc.decoderConfig.SkipInterFrameByte {
+		cc.skipInterFrameBytes(c)
+	}
+	if cc.byteOrderCodecs != nil {
+		variant, err := cc.byteOrderCodec(c)
+		if variant == nil {
+			return nil, err
+		}
+		return variant.DecodeStream(c)
+	}
+
+	header, strip, _, msgLength, err := cc.peekFrameHeader(c)
+	if header == nil {
+		if err == io.ErrShortBuffer {
+			err = nil
+		}
+		return nil, err
+	}
+	headerLength := len(header)
+	if err = cc.trackStreamHeader(c, headerLength, msgLength); err != nil {
+		if err == io.ErrShortBuffer {
+			err = nil
+		}
+		return nil, err
+	}
+	if cc.decoderConfig.OnHeader != nil {
+		c.SetCodecScratch(headerNotifiedKey{cc.owner()}, false)
+	}
+
+	var (
+		prefix []byte
+		skip   int
+	)
+	if strip < headerLength {
+		prefix = append(prefix, header[strip:]...)
+	} else {
+		skip = strip - headerLength
+	}
+	_, _ = c.Discard(headerLength)
+
+	fs := newFrameStream(c, prefix, skip, msgLength-headerLength-skip)
+	fs.Feed(c)
+	return fs, nil
+}
+
+// trackStreamHeader tracks the sequence number and the length delta of the frame whose header is about to be
+// consumed by DecodeStream, it returns io.ErrShortBuffer if the sequence number hasn't arrived yet.
+func (cc *LengthFieldBasedFrameCodec) trackStreamHeader(c Conn, headerLength, msgLength int) error {
+	field := cc.decoderConfig.SequenceField
+	if field.Length == 0 && !cc.decoderConfig.LengthDelta {
+		return nil
+	}
+	n := headerLength
+	if end := field.Offset + field.Length; field.Length > 0 && end > n && end <= msgLength {
+		n = end
+	}
+	msg, err := c.Peek(n)
+	if err != nil || len(msg) < n {
+		return io.ErrShortBuffer
+	}
+	if field.Length > 0 {
+		if err = cc.trackSequence(c, msg); err != nil {
+			return err
+		}
+	}
+	if cc.decoderConfig.LengthDelta {
+		cc.trackLengthDelta(c, msg)
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+// Copyright (c) 2019 Andy Pan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gnet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	gerr "github.com/walkon/wsgnet/pkg/errors"
+)
+
+func TestFrameStream(t *testing.T) {
+	codec := NewLengthFieldBasedFrameCodec(
+		EncoderConfig{ByteOrder: binary.BigEndian, LengthFieldLength: 4},
+		DecoderConfig{ByteOrder: binary.BigEndian, LengthFieldLength: 4},
+	)
+	payload := make([]byte, 256*1024)
+	_, _ = rand.Read(payload)
+	frame, err := codec.Encode(nil, payload)
+	require.NoError(t, err)
+	next, err := codec.Encode(nil, []byte("next"))
+	require.NoError(t, err)
+	wire := append(frame, next...)
+
+	c := &mockConn{wakeCh: make(chan struct{}, 1)}
+	c.feed(wire[:2])
+	fs, err := codec.DecodeStream(c)
+	require.NoError(t, err)
+	require.Nil(t, fs, "the header is incomplete")
+
+	c.feed(wire[2:100])
+	wire = wire[100:]
+	fs, err = codec.DecodeStream(c)
+	require.NoError(t, err)
+	require.NotNil(t, fs)
+	fs.bufferCap = 1024
+
+	result := make(chan []byte)
+	go func() {
+		data, err := io.ReadAll(fs)
+		assert.NoError(t, err)
+		result <- data
+	}()
+
+	for done := false; !done; {
+		if len(wire) > 0 {
+			n := 10 * 1024
+			if n > len(wire) {
+				n = len(wire)
+			}
+			c.feed(wire[:n])
+			wire = wire[n:]
+		}
+		if done = fs.Feed(c); !done && c.InboundBuffered() > 0 {
+			<-c.wakeCh
+		}
+	}
+	assert.Equal(t, payload, <-result)
+
+	data, err := codec.Decode(c)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("next"), data)
+}
+
+func TestFrameStreamAbort(t *testing.T) {
+	codec := NewLengthFieldBasedFrameCodec(EncoderConfig{},
+		DecoderConfig{ByteOrder: binary.BigEndian, LengthFieldLength: 2, InitialBytesToStrip: StripNone})
+	c := &mockConn{}
+	c.feed([]byte{0x00, 0x05, 'a', 'b'})
+	fs, err := codec.DecodeStream(c)
+	require.NoError(t, err)
+	fs.CloseWithError(nil)
+	data, err := io.ReadAll(fs)
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	assert.True(t, bytes.Equal([]byte{0x00, 0x05, 'a', 'b'}, data), "the header is kept with StripNone")
+}
+
+func TestFrameStreamHeaderChecks(t *testing.T) {
+	var (
+		tooLarge []int
+		headers  int
+		gaps     [][2]uint32
+	)
+	codec := NewLengthFieldBasedFrameCodec(
+		EncoderConfig{ByteOrder: binary.BigEndian, LengthFieldLength: 2},
+		DecoderConfig{
+			ByteOrder:           binary.BigEndian,
+			LengthFieldOffset:   1,
+			LengthFieldLength:   2,
+			InitialBytesToStrip: 3,
+			MaxFrameLength:      16,
+			SequenceField:       HeaderField{Offset: 0, Length: 1},
+			OnFrameTooLarge: func(_ Conn, declaredLen int) {
+				tooLarge = append(tooLarge, declaredLen)
+			},
+			OnHeader: func(_ Conn, _ []byte) {
+				headers++
+			},
+			OnSequenceGap: func(_ Conn, expected, got uint32) {
+				gaps = append(gaps, [2]uint32{expected, got})
+			},
+		},
+	)
+	c := &mockConn{}
+	for _, seq := range []byte{1, 3} {
+		c.feed([]byte{seq, 0x00, 0x02, 'o', 'k'})
+		fs, err := codec.DecodeStream(c)
+		require.NoError(t, err)
+		require.NotNil(t, fs)
+		data, err := io.ReadAll(fs)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("ok"), data)
+	}
+	assert.Equal(t, 2, headers)
+	assert.Equal(t, [][2]uint32{{2, 3}}, gaps)
+
+	c.feed([]byte{4, 0x00, 0x20})
+	_, err := codec.DecodeStream(c)
+	assert.ErrorIs(t, err, gerr.ErrFrameTooLarge)
+	assert.Equal(t, []int{35}, tooLarge)
+}
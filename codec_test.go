@@ -0,0 +1,1509 @@
+// Copyright (c) 2019 Andy Pan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gnet
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"math"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	gerr "github.com/walkon/wsgnet/pkg/errors"
+)
+
+// mockConn is a Conn backed by an in-memory inbound buffer, only the reading methods used by codecs are implemented.
+type mockConn struct {
+	Conn
+	inbound    []byte
+	outbound   []byte
+	codec      ICodec
+	writes     int
+	isDatagram bool
+	wakeCh     chan struct{}
+	ctx        interface{}
+	scratch    map[interface{}]interface{}
+	info       interface{}
+	arrivedAt  time.Time
+}
+
+func (c *mockConn) feed(b []byte) {
+	c.inbound = append(c.inbound, b...)
+}
+
+func (c *mockConn) Peek(n int) ([]byte, error) {
+	if n > len(c.inbound) {
+		return nil, io.ErrShortBuffer
+	} else if n <= 0 {
+		n = len(c.inbound)
+	}
+	return c.inbound[:n], nil
+}
+
+func (c *mockConn) Next(n int) ([]byte, error) {
+	buf, err := c.Peek(n)
+	if err != nil {
+		return nil, err
+	}
+	c.inbound = c.inbound[len(buf):]
+	return buf, nil
+}
+
+func (c *mockConn) Discard(n int) (int, error) {
+	if n <= 0 || n > len(c.inbound) {
+		n = len(c.inbound)
+	}
+	c.inbound = c.inbound[n:]
+	return n, nil
+}
+
+func (c *mockConn) InboundBuffered() int {
+	return len(c.inbound)
+}
+
+func (c *mockConn) Write(p []byte) (int, error) {
+	c.writes++
+	c.outbound = append(c.outbound, p...)
+	return len(p), nil
+}
+
+func (c *mockConn) Writev(bs [][]byte) (n int, err error) {
+	if c.isDatagram {
+		return -1, gerr.ErrUnsupportedOp
+	}
+	c.writes++
+	for _, b := range bs {
+		n += len(b)
+		c.outbound = append(c.outbound, b...)
+	}
+	return
+}
+
+func (c *mockConn) Wake(callback AsyncCallback) error {
+	if c.wakeCh != nil {
+		select {
+		case c.wakeCh <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+func (c *mockConn) ArrivedAt() time.Time { return c.arrivedAt }
+
+func (c *mockConn) Context() interface{}       { return c.ctx }
+func (c *mockConn) SetContext(ctx interface{}) { c.ctx = ctx }
+
+func (c *mockConn) ProtocolInfo() interface{} { return c.info }
+
+func (c *mockConn) Codec() ICodec { return c.codec }
+
+func (c *mockConn) CodecScratch(key interface{}) interface{} { return c.scratch[key] }
+
+func (c *mockConn) SetCodecScratch(key, value interface{}) {
+	if c.scratch == nil {
+		c.scratch = make(map[interface{}]interface{})
+	}
+	c.scratch[key] = value
+}
+
+func (c *mockConn) RemoteAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9000}
+}
+
+func TestLengthFieldBasedFrameCodec(t *testing.T) {
+	for _, order := range []binary.ByteOrder{binary.BigEndian, binary.LittleEndian} {
+		for _, fieldLength := range []int{1, 2, 3, 4} {
+			codec := NewLengthFieldBasedFrameCodec(
+				EncoderConfig{ByteOrder: order, LengthFieldLength: fieldLength},
+				DecoderConfig{ByteOrder: order, LengthFieldLength: fieldLength},
+			)
+			c := &mockConn{}
+			payload := []byte("hello gnet")
+			out, err := codec.Encode(c, payload)
+			require.NoError(t, err)
+			require.Len(t, out, fieldLength+len(payload))
+
+			c.feed(out[:len(out)-1])
+			frame, _ := codec.Decode(c)
+			assert.Nil(t, frame, "incomplete frame should not be decoded")
+
+			c.feed(out[len(out)-1:])
+			frame, err = codec.Decode(c)
+			require.NoError(t, err)
+			assert.Equal(t, payload, frame)
+			assert.Zero(t, c.InboundBuffered())
+		}
+	}
+}
+
+func TestLengthFieldBasedFrameCodecCRC(t *testing.T) {
+	payload := []byte("checksummed payload")
+	for _, scope := range []CRCScope{CRCPayloadOnly, CRCIncludeHeader} {
+		codec := NewLengthFieldBasedFrameCodec(
+			EncoderConfig{ByteOrder: binary.BigEndian, LengthFieldLength: 2, CRCScope: scope},
+			DecoderConfig{ByteOrder: binary.BigEndian, LengthFieldLength: 2, CRCScope: scope},
+		)
+		c := &mockConn{}
+		out, err := codec.Encode(c, payload)
+		require.NoError(t, err)
+		require.Len(t, out, 2+len(payload)+crcLength)
+		assert.EqualValues(t, len(payload), binary.BigEndian.Uint16(out), "length field must not count the checksum")
+
+		covered := payload
+		if scope == CRCIncludeHeader {
+			covered = out[:2+len(payload)]
+		}
+		assert.Equal(t, crc32.ChecksumIEEE(covered), binary.BigEndian.Uint32(out[2+len(payload):]))
+
+		c.feed(out)
+		c.feed(out)
+		for i := 0; i < 2; i++ {
+			frame, err := codec.Decode(c)
+			require.NoError(t, err)
+			assert.Equal(t, payload, frame)
+		}
+		assert.Zero(t, c.InboundBuffered())
+	}
+
+	// A decoder expecting a header-covering checksum must reject a checksum computed over the payload only.
+	enc := NewLengthFieldBasedFrameCodec(
+		EncoderConfig{ByteOrder: binary.BigEndian, LengthFieldLength: 2, CRCScope: CRCPayloadOnly},
+		DecoderConfig{},
+	)
+	dec := NewLengthFieldBasedFrameCodec(
+		EncoderConfig{},
+		DecoderConfig{ByteOrder: binary.BigEndian, LengthFieldLength: 2, CRCScope: CRCIncludeHeader},
+	)
+	c := &mockConn{}
+	out, err := enc.Encode(c, payload)
+	require.NoError(t, err)
+	c.feed(out)
+	frame, err := dec.Decode(c)
+	assert.ErrorIs(t, err, gerr.ErrInvalidChecksum)
+	assert.Nil(t, frame)
+	assert.Zero(t, c.InboundBuffered(), "the corrupted frame should be discarded")
+}
+
+func TestLengthFieldBasedFrameCodecValidateUTF8(t *testing.T) {
+	codec := NewLengthFieldBasedFrameCodec(
+		EncoderConfig{ByteOrder: binary.BigEndian, LengthFieldLength: 2},
+		DecoderConfig{ByteOrder: binary.BigEndian, LengthFieldLength: 2, ValidateUTF8: true},
+	)
+	c := &mockConn{}
+	for _, payload := range [][]byte{[]byte("héllo, 世界"), {'b', 'a', 'd', 0xC3, 0x28}, []byte("next")} {
+		out, err := codec.Encode(c, payload)
+		require.NoError(t, err)
+		c.feed(out)
+	}
+	frame, err := codec.Decode(c)
+	require.NoError(t, err)
+	assert.Equal(t, "héllo, 世界", string(frame))
+	_, err = codec.Decode(c)
+	assert.ErrorIs(t, err, gerr.ErrInvalidUTF8)
+	frame, err = codec.Decode(c)
+	require.NoError(t, err)
+	assert.Equal(t, "next", string(frame), "the invalid frame should be discarded")
+
+	bad := []byte{0x00, 0x02, 0xC3, 0x28}
+	allocs := testing.AllocsPerRun(100, func() {
+		c.feed(bad)
+		_, _ = codec.Decode(c)
+	})
+	assert.Zero(t, allocs, "the validation should not allocate")
+}
+
+func TestLengthFieldBasedFrameCodecOnSequenceGap(t *testing.T) {
+	type gap struct{ expected, got uint32 }
+	var gaps []gap
+	// a 1-byte sequence number precedes the 2-byte length.
+	codec := NewLengthFieldBasedFrameCodec(EncoderConfig{}, DecoderConfig{
+		ByteOrder:         binary.BigEndian,
+		LengthFieldOffset: 1,
+		LengthFieldLength: 2,
+		SequenceField:     HeaderField{Offset: 0, Length: 1},
+		OnSequenceGap: func(c Conn, expected, got uint32) {
+			gaps = append(gaps, gap{expected, got})
+		},
+	})
+	c1, c2 := &mockConn{}, &mockConn{}
+	// 0xFF wraps around to 0x00, 0x03 skips 0x01 and 0x02, 0x02 goes backwards.
+	for _, seq := range []byte{0xFE, 0xFF, 0x00, 0x03, 0x02, 0x04} {
+		c1.feed([]byte{seq, 0x00, 0x01, 'x'})
+	}
+	// the sequence numbers are tracked per connection.
+	c2.feed([]byte{0x10, 0x00, 0x01, 'y'})
+	c2.feed([]byte{0x11, 0x00, 0x01, 'y'})
+	for _, c := range []*mockConn{c1, c2} {
+		for c.InboundBuffered() > 0 {
+			frame, err := codec.Decode(c)
+			require.NoError(t, err)
+			assert.Len(t, frame, 1)
+		}
+	}
+	assert.Equal(t, []gap{{0x01, 0x03}}, gaps)
+}
+
+func TestLengthFieldBasedFrameCodecDecodeWithPriority(t *testing.T) {
+	// a 1-byte priority follows the 2-byte length and is stripped along with it.
+	codec := NewLengthFieldBasedFrameCodec(EncoderConfig{}, DecoderConfig{
+		ByteOrder:         binary.BigEndian,
+		LengthFieldLength: 2,
+		InterHeaderSkip:   1,
+		PriorityField:     HeaderField{Offset: 2, Length: 1},
+	})
+	c := &mockConn{}
+	c.feed([]byte{0x00, 0x02, 0x07, 'h', 'i'})
+	frame, priority, err := codec.DecodeWithPriority(c)
+	require.NoError(t, err)
+	assert.Equal(t, "hi", string(frame))
+	assert.EqualValues(t, 7, priority)
+
+	// the frames of a connection without an event-loop are dispatched right away.
+	var dispatched []string
+	pd := NewPriorityDispatcher(func(c Conn, frame []byte) Action {
+		dispatched = append(dispatched, string(frame))
+		return Close
+	}, 0)
+	assert.Equal(t, Close, pd.Dispatch(c, frame, priority))
+	assert.Equal(t, []string{"hi"}, dispatched)
+}
+
+func TestLengthFieldBasedFrameCodecLengthIncludesAll(t *testing.T) {
+	// [4-byte total][payload][4-byte crc] where total = 4 + len(payload) + 4.
+	codec := NewLengthFieldBasedFrameCodec(EncoderConfig{}, DecoderConfig{
+		ByteOrder:                       binary.BigEndian,
+		LengthFieldLength:               4,
+		CRCScope:                        CRCPayloadOnly,
+		LengthIncludesLengthFieldLength: true,
+		LengthIncludesTrailer:           true,
+	})
+	frame := func(total uint32, payload string) []byte {
+		msg := binary.BigEndian.AppendUint32(nil, total)
+		msg = append(msg, payload...)
+		return binary.BigEndian.AppendUint32(msg, crc32.ChecksumIEEE([]byte(payload)))
+	}
+	c := &mockConn{}
+	c.feed(frame(13, "hello"))
+	c.feed(frame(8, ""))
+	got, err := codec.Decode(c)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(got))
+	got, err = codec.Decode(c)
+	require.NoError(t, err)
+	assert.Empty(t, got)
+	assert.Zero(t, c.InboundBuffered())
+
+	// the total can't be shorter than the length field and the checksum.
+	c.feed(frame(7, ""))
+	_, err = codec.Decode(c)
+	assert.ErrorIs(t, err, gerr.ErrMalformedFrame)
+
+	// the trailing fields are counted as well.
+	codec = NewLengthFieldBasedFrameCodec(EncoderConfig{}, DecoderConfig{
+		ByteOrder:                       binary.BigEndian,
+		LengthFieldLength:               2,
+		TrailerLength:                   func([]byte) (int, error) { return 2, nil },
+		LengthIncludesLengthFieldLength: true,
+		LengthIncludesTrailer:           true,
+	})
+	c = &mockConn{}
+	c.feed(append([]byte{0x00, 0x07}, "abc\xff\xfe"...))
+	got, err = codec.Decode(c)
+	require.NoError(t, err)
+	assert.Equal(t, "abc\xff\xfe", string(got))
+}
+
+func TestHeaderWrappedLengthFieldCodec(t *testing.T) {
+	codec := NewHeaderWrappedLengthFieldCodec()
+	c := &mockConn{}
+	before := append([]byte{0xCA, 0x00, 0x0C, 0xFE}, "HELLO, WORLD"...)
+	require.Len(t, before, 16)
+	after := append([]byte{0xFE}, "HELLO, WORLD"...)
+
+	out, err := codec.Encode(c, before)
+	require.NoError(t, err)
+	assert.Equal(t, before, out)
+
+	c.feed(before[:3])
+	frame, _ := codec.Decode(c)
+	assert.Nil(t, frame)
+	c.feed(before[3:])
+	c.feed(before)
+	for i := 0; i < 2; i++ {
+		frame, err = codec.Decode(c)
+		require.NoError(t, err)
+		assert.Equal(t, after, frame)
+	}
+	assert.Zero(t, c.InboundBuffered())
+}
+
+func TestLengthFieldBasedFrameCodecStrip(t *testing.T) {
+	msg := []byte{0x00, 0x05, 'h', 'e', 'l', 'l', 'o'}
+	cases := []struct {
+		strip int
+		frame []byte
+	}{
+		{0, msg[2:]},
+		{StripNone, msg},
+		{1, msg[1:]},
+		{len(msg), []byte{}},
+	}
+	for _, tc := range cases {
+		codec := NewLengthFieldBasedFrameCodec(EncoderConfig{},
+			DecoderConfig{ByteOrder: binary.BigEndian, LengthFieldLength: 2, InitialBytesToStrip: tc.strip})
+		c := &mockConn{}
+		c.feed(msg)
+		frame, err := codec.Decode(c)
+		require.NoError(t, err)
+		assert.Equal(t, tc.frame, frame, "strip %d", tc.strip)
+	}
+
+	codec := NewLengthFieldBasedFrameCodec(EncoderConfig{},
+		DecoderConfig{ByteOrder: binary.BigEndian, LengthFieldLength: 2, InitialBytesToStrip: len(msg) + 1})
+	c := &mockConn{}
+	c.feed(msg)
+	_, err := codec.Decode(c)
+	assert.ErrorIs(t, err, gerr.ErrTooManyBytesToStrip)
+}
+
+func TestLengthFieldBasedFrameCodecLengthFieldOffset(t *testing.T) {
+	for _, offset := range []int{0, 2, 5} {
+		codec := NewLengthFieldBasedFrameCodec(EncoderConfig{}, DecoderConfig{
+			ByteOrder:         binary.BigEndian,
+			LengthFieldOffset: offset,
+			LengthFieldLength: 4,
+		})
+		c := &mockConn{}
+		// [prefix][4-byte length][payload]
+		msg := append(bytes.Repeat([]byte{0xAB}, offset), 0x00, 0x00, 0x00, 0x05)
+		msg = append(msg, "hello"...)
+
+		// a peek shorter than the offset plus the length field.
+		c.feed(msg[:offset+3])
+		frame, _ := codec.Decode(c)
+		assert.Nil(t, frame, "offset %d", offset)
+		assert.Equal(t, offset+3, c.InboundBuffered(), "offset %d", offset)
+
+		c.feed(msg[offset+3:])
+		c.feed(msg[:1])
+		frame, err := codec.Decode(c)
+		require.NoError(t, err, "offset %d", offset)
+		assert.Equal(t, "hello", string(frame), "offset %d: the prefix and the length field are stripped", offset)
+		assert.Equal(t, 1, c.InboundBuffered(), "offset %d: the whole message is consumed", offset)
+	}
+}
+
+func TestLengthFieldBasedFrameCodecPositiveAdjustment(t *testing.T) {
+	// a 2-byte length counting only the payload, which is followed by a 3-byte trailer.
+	codec := NewLengthFieldBasedFrameCodec(EncoderConfig{}, DecoderConfig{
+		ByteOrder:         binary.BigEndian,
+		LengthFieldLength: 2,
+		LengthAdjustment:  3,
+	})
+	c := &mockConn{}
+	c.feed(append([]byte{0x00, 0x05}, "hello\x01\x02\x03"...))
+	c.feed(append([]byte{0x00, 0x02}, "hi\x04\x05\x06"...))
+	frame, err := codec.Decode(c)
+	require.NoError(t, err)
+	assert.Equal(t, "hello\x01\x02\x03", string(frame), "the trailer is kept at the end of the frame")
+	frame, err = codec.Decode(c)
+	require.NoError(t, err)
+	assert.Equal(t, "hi\x04\x05\x06", string(frame), "the trailer doesn't corrupt the next frame")
+	assert.Zero(t, c.InboundBuffered())
+}
+
+func TestLengthFieldBasedFrameCodecNegativeAdjustment(t *testing.T) {
+	// a 2-byte magic followed by a 2-byte length which is the offset of the end of the frame from its start.
+	codec := NewLengthFieldBasedFrameCodec(EncoderConfig{}, DecoderConfig{
+		ByteOrder:         binary.BigEndian,
+		LengthFieldOffset: 2,
+		LengthFieldLength: 2,
+		LengthAdjustment:  -4,
+	})
+	msg := append([]byte{0xAB, 0xCD, 0x00, 0x09}, "hello"...)
+	c := &mockConn{}
+	c.feed(msg)
+	c.feed([]byte{0xAB, 0xCD, 0x00, 0x04})
+	frame, err := codec.Decode(c)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(frame))
+	frame, err = codec.Decode(c)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{}, frame, "a frame of the header only should be decoded as empty")
+	assert.Zero(t, c.InboundBuffered())
+
+	// the length can't end the frame before its header.
+	c.feed([]byte{0xAB, 0xCD, 0x00, 0x03})
+	_, err = codec.Decode(c)
+	assert.ErrorIs(t, err, gerr.ErrMalformedFrame)
+	assert.ErrorIs(t, err, gerr.ErrInvalidFrameLength)
+
+	for _, adjustment := range []int{math.MaxInt, math.MinInt} {
+		codec = NewLengthFieldBasedFrameCodec(EncoderConfig{},
+			DecoderConfig{ByteOrder: binary.BigEndian, LengthFieldLength: 2, LengthAdjustment: adjustment})
+		c = &mockConn{}
+		c.feed([]byte{0x00, 0x05})
+		_, err = codec.Decode(c)
+		assert.ErrorIs(t, err, gerr.ErrMalformedFrame, "adjustment %d", adjustment)
+	}
+}
+
+func TestFloat32LengthParser(t *testing.T) {
+	codec := NewLengthFieldBasedFrameCodec(EncoderConfig{}, DecoderConfig{
+		ByteOrder:         binary.BigEndian,
+		LengthFieldLength: 4,
+		LengthParser:      Float32LengthParser(binary.BigEndian),
+	})
+	frame := func(length float32, payload string) []byte {
+		return append(binary.BigEndian.AppendUint32(nil, math.Float32bits(length)), payload...)
+	}
+	c := &mockConn{}
+	c.feed(frame(5, "hello"))
+	// rounded to the nearest byte count.
+	c.feed(frame(2.6, "abc"))
+	for _, want := range []string{"hello", "abc"} {
+		got, err := codec.Decode(c)
+		require.NoError(t, err)
+		assert.Equal(t, want, string(got))
+	}
+	assert.Zero(t, c.InboundBuffered())
+
+	for _, length := range []float32{float32(math.NaN()), -1} {
+		c = &mockConn{}
+		c.feed(frame(length, "x"))
+		_, err := codec.Decode(c)
+		assert.ErrorIs(t, err, gerr.ErrMalformedFrame, "length %v", length)
+	}
+	for _, length := range []float32{float32(math.Inf(1)), 1 << 30} {
+		c = &mockConn{}
+		c.feed(frame(length, "x"))
+		_, err := codec.Decode(c)
+		assert.ErrorIs(t, err, gerr.ErrFrameTooLarge, "length %v", length)
+	}
+
+	_, err := Float32LengthParser(binary.BigEndian)([]byte{0, 0})
+	assert.ErrorIs(t, err, gerr.ErrUnsupportedLength)
+}
+
+func TestEvenParityLengthParser(t *testing.T) {
+	codec := NewLengthFieldBasedFrameCodec(EncoderConfig{}, DecoderConfig{
+		ByteOrder:         binary.BigEndian,
+		LengthFieldLength: 2,
+		LengthParser:      EvenParityLengthParser(binary.BigEndian),
+	})
+	c := &mockConn{}
+	// 3 has two bits set, so the parity bit is clear, while 7 has three bits set, so the parity bit is set.
+	c.feed([]byte{0x00, 0x03, 'a', 'b', 'c'})
+	c.feed([]byte{0x80, 0x07, 'd', 'e', 'f', 'g', 'h', 'i', 'j'})
+	for _, want := range []string{"abc", "defghij"} {
+		frame, err := codec.Decode(c)
+		require.NoError(t, err)
+		assert.Equal(t, want, string(frame))
+	}
+
+	c.feed([]byte{0x80, 0x03, 'a', 'b', 'c'})
+	_, err := codec.Decode(c)
+	assert.ErrorIs(t, err, gerr.ErrBadLengthParity)
+
+	length, err := EvenParityLengthParser(binary.LittleEndian)([]byte{0x01, 0x00, 0x00, 0x80})
+	require.NoError(t, err)
+	assert.Equal(t, 1, length)
+}
+
+func TestLengthFieldBasedFrameCodecEncodeBuffers(t *testing.T) {
+	payload := bytes.Repeat([]byte("gather"), 1024)
+	for _, scope := range []CRCScope{CRCNone, CRCPayloadOnly, CRCIncludeHeader} {
+		codec := NewLengthFieldBasedFrameCodec(
+			EncoderConfig{ByteOrder: binary.LittleEndian, LengthFieldLength: 4, CRCScope: scope},
+			DecoderConfig{ByteOrder: binary.LittleEndian, LengthFieldLength: 4, CRCScope: scope},
+		)
+		c := &mockConn{}
+		out, err := codec.Encode(c, payload)
+		require.NoError(t, err)
+		bs, err := codec.EncodeBuffers(c, payload)
+		require.NoError(t, err)
+		assert.Same(t, &payload[0], &bs[1][0], "payload should not be copied")
+		assert.Equal(t, out, bytes.Join(bs, nil))
+
+		require.NoError(t, WriteFrame(c, codec, payload))
+		c.isDatagram = true
+		require.NoError(t, WriteFrame(c, codec, payload))
+		assert.Equal(t, 2, c.writes)
+		assert.Equal(t, append(append([]byte{}, out...), out...), c.outbound)
+	}
+
+	codec := NewLengthFieldBasedFrameCodec(EncoderConfig{ByteOrder: binary.BigEndian, LengthFieldLength: 1}, DecoderConfig{})
+	_, err := codec.EncodeBuffers(&mockConn{}, payload)
+	assert.ErrorIs(t, err, gerr.ErrEncodeLengthOverflow)
+}
+
+func TestLengthFieldBasedFrameCodecInterHeaderSkip(t *testing.T) {
+	errBadHeader := errors.New("bad header checksum")
+	headerChecksum := func(lengthField []byte) uint16 {
+		return uint16(crc32.ChecksumIEEE(lengthField))
+	}
+	codec := NewLengthFieldBasedFrameCodec(EncoderConfig{}, DecoderConfig{
+		ByteOrder:         binary.BigEndian,
+		LengthFieldLength: 2,
+		InterHeaderSkip:   2,
+		VerifyInterHeader: func(lengthField, skipped []byte) error {
+			if binary.BigEndian.Uint16(skipped) != headerChecksum(lengthField) {
+				return errBadHeader
+			}
+			return nil
+		},
+	})
+	frame := func(payload string) []byte {
+		b := make([]byte, 4, 4+len(payload))
+		binary.BigEndian.PutUint16(b, uint16(len(payload)))
+		binary.BigEndian.PutUint16(b[2:], headerChecksum(b[:2]))
+		return append(b, payload...)
+	}
+
+	c := &mockConn{}
+	c.feed(frame("hello"))
+	c.feed(frame(""))
+	c.feed(frame("world"))
+	for _, payload := range []string{"hello", "", "world"} {
+		data, err := codec.Decode(c)
+		require.NoError(t, err)
+		assert.Equal(t, payload, string(data))
+	}
+
+	// a corrupted header is rejected before the payload arrives.
+	bad := frame("payload")
+	bad[3]++
+	c.feed(bad[:4])
+	_, err := codec.Decode(c)
+	assert.ErrorIs(t, err, errBadHeader)
+}
+
+func TestLengthFieldBasedFrameCodecLengthFieldExtension(t *testing.T) {
+	dc := DecoderConfig{
+		ByteOrder:         binary.BigEndian,
+		LengthFieldLength: 1,
+		LengthFieldExtension: func(coarse []byte) (int, error) {
+			if coarse[0] == 0xFF {
+				return 4, nil
+			}
+			return 0, nil
+		},
+		LengthParser: func(lengthField []byte) (int, error) {
+			if lengthField[0] == 0xFF {
+				return int(binary.BigEndian.Uint32(lengthField[1:])), nil
+			}
+			return int(lengthField[0]), nil
+		},
+		InterHeaderSkip:     1,
+		InitialBytesToStrip: StripNone,
+	}
+	codec := NewLengthFieldBasedFrameCodec(EncoderConfig{}, dc)
+	large := bytes.Repeat([]byte{'l'}, 300)
+	var stream []byte
+	stream = append(stream, 3, '#', 'a', 'b', 'c')
+	stream = append(binary.BigEndian.AppendUint32(append(stream, 0xFF), uint32(len(large))), '#')
+	stream = append(stream, large...)
+
+	// feed byte by byte to make sure the fine length is waited for once the coarse one asks for it.
+	c := &mockConn{}
+	var got [][]byte
+	for _, b := range stream {
+		c.feed([]byte{b})
+		frame, err := codec.Decode(c)
+		if err == io.ErrShortBuffer {
+			continue
+		}
+		require.NoError(t, err)
+		if frame != nil {
+			got = append(got, frame)
+		}
+	}
+	require.Len(t, got, 2)
+	assert.Equal(t, []byte{3, '#', 'a', 'b', 'c'}, got[0])
+	assert.Equal(t, stream[5:], got[1], "the header keeps the whole length field and the skipped byte")
+	assert.Zero(t, c.InboundBuffered())
+
+	// the whole length field is counted if the length includes it.
+	dc.LengthIncludesLengthFieldLength = true
+	codec = NewLengthFieldBasedFrameCodec(EncoderConfig{}, dc)
+	c = &mockConn{}
+	c.feed(append(binary.BigEndian.AppendUint32([]byte{0xFF}, 7), '#', 'x', 'y'))
+	frame, err := codec.Decode(c)
+	require.NoError(t, err)
+	assert.Equal(t, "xy", string(frame[6:]))
+
+	dc.LengthParser = nil
+	c.feed(append(binary.BigEndian.AppendUint32([]byte{0xFF}, 2), '#', 'x', 'y'))
+	_, err = NewLengthFieldBasedFrameCodec(EncoderConfig{}, dc).Decode(c)
+	assert.ErrorIs(t, err, gerr.ErrUnsupportedLength, "the extended length field must be parsed by LengthParser")
+}
+
+func TestLengthFieldBasedFrameCodecEmptyFrame(t *testing.T) {
+	codec := NewLengthFieldBasedFrameCodec(EncoderConfig{}, DecoderConfig{
+		ByteOrder:         binary.BigEndian,
+		LengthFieldLength: 2,
+		CRCScope:          CRCPayloadOnly,
+	})
+	emptyCRC := binary.BigEndian.AppendUint32(nil, crc32.ChecksumIEEE(nil))
+	hiCRC := binary.BigEndian.AppendUint32(nil, crc32.ChecksumIEEE([]byte("hi")))
+	c := &mockConn{}
+	// header-only frames interleaved with a data frame.
+	c.feed(append([]byte{0, 0}, emptyCRC...))
+	c.feed(append([]byte{0, 2, 'h', 'i'}, hiCRC...))
+	c.feed(append([]byte{0, 0}, emptyCRC...))
+	frames, err := DecodeBatch(c, codec, 0)
+	require.NoError(t, err)
+	assert.Equal(t, [][]byte{{}, []byte("hi"), {}}, frames)
+	for _, frame := range frames {
+		assert.NotNil(t, frame, "an empty frame must not be taken for an incomplete one")
+	}
+	assert.Zero(t, c.InboundBuffered())
+
+	// a header-only frame with StripNone keeps its header.
+	codec = NewLengthFieldBasedFrameCodec(EncoderConfig{}, DecoderConfig{
+		ByteOrder:           binary.BigEndian,
+		LengthFieldLength:   2,
+		InitialBytesToStrip: StripNone,
+	})
+	c.feed([]byte{0, 0})
+	frame, err := codec.Decode(c)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0, 0}, frame)
+
+	// a length field of no bytes would make messages of no bytes.
+	_, err = NewLengthFieldBasedFrameCodec(EncoderConfig{}, DecoderConfig{
+		LengthParser: func([]byte) (int, error) { return 0, nil },
+	}).Decode(&mockConn{inbound: []byte("x")})
+	assert.ErrorIs(t, err, gerr.ErrUnsupportedLength)
+}
+
+func TestLengthFieldBasedFrameCodecLengthFieldUnit(t *testing.T) {
+	codec := NewLengthFieldBasedFrameCodec(EncoderConfig{}, DecoderConfig{
+		ByteOrder:         binary.BigEndian,
+		LengthFieldLength: 1,
+		LengthFieldUnit:   8,
+		LengthAdjustment:  -1,
+	})
+	c := &mockConn{}
+	// 2 units of 8 bytes counting the length field.
+	c.feed(append([]byte{2}, "fifteen bytes.."...))
+	frame, err := codec.Decode(c)
+	require.NoError(t, err)
+	assert.Equal(t, "fifteen bytes..", string(frame))
+	assert.Zero(t, c.InboundBuffered())
+}
+
+func TestLengthFieldBasedFrameCodecByteOrderMark(t *testing.T) {
+	codec := NewLengthFieldBasedFrameCodec(EncoderConfig{}, DecoderConfig{
+		LengthFieldOffset:   2,
+		LengthFieldLength:   2,
+		InitialBytesToStrip: StripNone,
+		PriorityField:       HeaderField{Offset: 4, Length: 2},
+		ByteOrderMark:       true,
+		ByteOrder:           binary.BigEndian,
+	})
+	c := &mockConn{}
+	// [BOM][2-byte length][2-byte priority][payload], the length counts the priority and the payload.
+	c.feed([]byte{0xFE, 0xFF, 0x00, 0x04, 0x00, 0x07, 'b', 'e'})
+	c.feed([]byte{0xFF, 0xFE, 0x04, 0x00, 0x07, 0x00, 'l', 'e'})
+	c.feed([]byte{0xFF})
+
+	frame, priority, err := codec.DecodeWithPriority(c)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x00, 0x04, 0x00, 0x07, 'b', 'e'}, frame, "the byte order mark is stripped")
+	assert.EqualValues(t, 7, priority)
+	frame, priority, err = codec.DecodeWithPriority(c)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x04, 0x00, 0x07, 0x00, 'l', 'e'}, frame)
+	assert.EqualValues(t, 7, priority)
+
+	frame, _ = codec.Decode(c)
+	assert.Nil(t, frame, "the byte order mark is incomplete")
+	c.feed([]byte{0xFF})
+	_, err = codec.Decode(c)
+	assert.ErrorIs(t, err, gerr.ErrMalformedFrame)
+
+	// the whole header is stripped by default.
+	codec = NewLengthFieldBasedFrameCodec(EncoderConfig{}, DecoderConfig{
+		LengthFieldOffset: 2,
+		LengthFieldLength: 4,
+		ByteOrderMark:     true,
+	})
+	c = &mockConn{}
+	c.feed([]byte{0xFF, 0xFE, 0x02, 0x00, 0x00, 0x00, 'o', 'k'})
+	frame, err = codec.Decode(c)
+	require.NoError(t, err)
+	assert.Equal(t, "ok", string(frame))
+}
+
+func TestLengthFieldBasedFrameCodecLengthDelta(t *testing.T) {
+	codec := NewLengthFieldBasedFrameCodec(
+		EncoderConfig{ByteOrder: binary.BigEndian, LengthFieldLength: 1, LengthDelta: true},
+		DecoderConfig{ByteOrder: binary.BigEndian, LengthFieldLength: 1, LengthDelta: true},
+	)
+	encoder, decoder := &mockConn{}, &mockConn{}
+	payloads := []string{"hello", "hi", "", "greetings", string(bytes.Repeat([]byte("x"), 130))}
+	var wire []byte
+	for _, payload := range payloads {
+		out, err := codec.Encode(encoder, []byte(payload))
+		require.NoError(t, err)
+		wire = append(wire, out...)
+	}
+	// the first length is absolute, the others are the deltas: -3, -2, +9, +121.
+	assert.Equal(t, []byte{5, 0xFD, 0xFE, 9, 121}, []byte{wire[0], wire[6], wire[9], wire[10], wire[20]})
+
+	// the frames arrive byte by byte, so that an incomplete frame doesn't move the previous length on.
+	var frames []string
+	for i := range wire {
+		decoder.feed(wire[i : i+1])
+		frame, err := codec.Decode(decoder)
+		if err == io.ErrShortBuffer || frame == nil {
+			continue
+		}
+		require.NoError(t, err)
+		frames = append(frames, string(frame))
+	}
+	assert.Equal(t, payloads, frames)
+
+	// the frames written by WriteFrame over a conn without writev are encoded only once.
+	encoder, decoder = &mockConn{isDatagram: true}, &mockConn{}
+	for _, payload := range payloads {
+		require.NoError(t, WriteFrame(encoder, codec, []byte(payload)))
+	}
+	assert.Equal(t, wire, encoder.outbound)
+	decoder.feed(encoder.outbound)
+	frames = frames[:0]
+	for range payloads {
+		frame, err := codec.Decode(decoder)
+		require.NoError(t, err)
+		frames = append(frames, string(frame))
+	}
+	assert.Equal(t, payloads, frames)
+
+	// a delta beyond the signed range of the length field.
+	_, err := codec.Encode(encoder, nil)
+	assert.ErrorIs(t, err, gerr.ErrEncodeLengthOverflow)
+
+	// a delta making the length negative.
+	decoder = &mockConn{}
+	decoder.feed([]byte{2, 'h', 'i', 0xFC})
+	_, err = codec.Decode(decoder)
+	require.NoError(t, err)
+	_, err = codec.Decode(decoder)
+	assert.ErrorIs(t, err, gerr.ErrMalformedFrame)
+}
+
+func TestLengthFieldBasedFrameCodecPeekFrameLengthBookkeeping(t *testing.T) {
+	// peeking a frame before decoding it must not apply the delta twice.
+	codec := NewLengthFieldBasedFrameCodec(
+		EncoderConfig{ByteOrder: binary.BigEndian, LengthFieldLength: 1, LengthDelta: true},
+		DecoderConfig{ByteOrder: binary.BigEndian, LengthFieldLength: 1, LengthDelta: true, InitialBytesToStrip: 1},
+	)
+	encoder, decoder := &mockConn{}, &mockConn{}
+	for _, payload := range []string{"hello", "hi", "greetings"} {
+		out, err := codec.Encode(encoder, []byte(payload))
+		require.NoError(t, err)
+		decoder.feed(out)
+	}
+	for _, want := range []string{"hello", "hi", "greetings"} {
+		n, err := codec.PeekFrameLength(decoder)
+		require.NoError(t, err)
+		assert.Equal(t, 1+len(want), n)
+		frame, err := codec.Decode(decoder)
+		require.NoError(t, err)
+		assert.Equal(t, want, string(frame))
+	}
+
+	// nor report a false sequence gap.
+	var gaps [][2]uint32
+	codec = NewLengthFieldBasedFrameCodec(EncoderConfig{}, DecoderConfig{
+		ByteOrder:         binary.BigEndian,
+		LengthFieldOffset: 1,
+		LengthFieldLength: 1,
+		SequenceField:     HeaderField{Offset: 0, Length: 1},
+		OnSequenceGap: func(_ Conn, expected, got uint32) {
+			gaps = append(gaps, [2]uint32{expected, got})
+		},
+	})
+	decoder = &mockConn{}
+	decoder.feed([]byte{0, 1, 'a', 1, 1, 'b', 2, 1, 'c'})
+	for i := 0; i < 3; i++ {
+		n, err := codec.PeekFrameLength(decoder)
+		require.NoError(t, err)
+		assert.Equal(t, 3, n)
+		_, err = codec.PeekFrameLength(decoder)
+		require.NoError(t, err)
+		_, err = codec.Decode(decoder)
+		require.NoError(t, err)
+	}
+	assert.Empty(t, gaps)
+}
+
+func TestLengthFieldBasedFrameCodecUint64Length(t *testing.T) {
+	for _, byteOrder := range []binary.ByteOrder{binary.BigEndian, binary.LittleEndian} {
+		codec := NewLengthFieldBasedFrameCodec(
+			EncoderConfig{ByteOrder: byteOrder, LengthFieldLength: 8},
+			DecoderConfig{ByteOrder: byteOrder, LengthFieldLength: 8},
+		)
+		c := &mockConn{}
+		for _, payload := range []string{"eight bytes of length", ""} {
+			out, err := codec.Encode(c, []byte(payload))
+			require.NoError(t, err)
+			require.Len(t, out, 8+len(payload))
+			assert.EqualValues(t, len(payload), byteOrder.Uint64(out), "%v", byteOrder)
+			c.feed(out)
+			frame, err := codec.Decode(c)
+			require.NoError(t, err)
+			assert.Equal(t, payload, string(frame), "%v", byteOrder)
+		}
+		assert.Zero(t, c.InboundBuffered())
+
+		// the length isn't clipped to 32 bits.
+		length := make([]byte, 8)
+		byteOrder.PutUint64(length, 1<<32+1)
+		c.feed(append(length, 'x'))
+		_, err := codec.Decode(c)
+		assert.ErrorIs(t, err, gerr.ErrFrameTooLarge, "%v", byteOrder)
+
+		c = &mockConn{}
+		byteOrder.PutUint64(length, math.MaxUint64)
+		c.feed(length)
+		_, err = codec.Decode(c)
+		assert.ErrorIs(t, err, gerr.ErrInvalidFrameLength, "%v", byteOrder)
+	}
+}
+
+func TestDecodeWithArrival(t *testing.T) {
+	codec := NewLengthFieldBasedFrameCodec(
+		EncoderConfig{ByteOrder: binary.BigEndian, LengthFieldLength: 1},
+		DecoderConfig{ByteOrder: binary.BigEndian, LengthFieldLength: 1},
+	)
+	c := &mockConn{}
+	start := time.Now()
+	read := func(at time.Duration, payloads ...string) {
+		c.arrivedAt = start.Add(at)
+		for _, payload := range payloads {
+			out, err := codec.Encode(c, []byte(payload))
+			require.NoError(t, err)
+			c.feed(out)
+		}
+	}
+	decode := func(want string, at time.Duration) {
+		frame, arrival, err := DecodeWithArrival(c, codec)
+		require.NoError(t, err)
+		assert.Equal(t, want, string(frame))
+		assert.Equal(t, start.Add(at), arrival, "arrival of %q", want)
+	}
+
+	read(1, "a", "b")
+	c.inbound = c.inbound[:len(c.inbound)-1]
+	decode("a", 1)
+	frame, _, err := DecodeWithArrival(c, codec)
+	assert.Nil(t, frame)
+	if err != nil {
+		assert.ErrorIs(t, err, io.ErrShortBuffer)
+	}
+	// the last byte of "b" arrives by the next read.
+	c.arrivedAt = start.Add(2)
+	c.feed([]byte("b"))
+	decode("b", 2)
+
+	// "d" is held over until the next read, it keeps its own arrival.
+	read(3, "c", "d")
+	decode("c", 3)
+	read(4, "e")
+	decode("d", 3)
+	decode("e", 4)
+	assert.Zero(t, c.InboundBuffered())
+}
+
+func TestLengthFieldBasedFrameCodecEncodeLengthIncludesLengthFieldLength(t *testing.T) {
+	for _, fieldLength := range []int{1, 2, 3, 4, 8} {
+		codec := NewLengthFieldBasedFrameCodec(
+			EncoderConfig{ByteOrder: binary.BigEndian, LengthFieldLength: fieldLength, LengthIncludesLengthFieldLength: true},
+			DecoderConfig{ByteOrder: binary.BigEndian, LengthFieldLength: fieldLength, LengthIncludesLengthFieldLength: true},
+		)
+		c := &mockConn{}
+		for _, payload := range []string{"counted", ""} {
+			out, err := codec.Encode(c, []byte(payload))
+			require.NoError(t, err)
+			require.Len(t, out, fieldLength+len(payload), "%d-byte field", fieldLength)
+			var length uint64
+			if fieldLength == 8 {
+				length = binary.BigEndian.Uint64(out)
+			} else {
+				length, err = readUint(binary.BigEndian, out, fieldLength)
+				require.NoError(t, err)
+			}
+			assert.EqualValues(t, fieldLength+len(payload), length, "%d-byte field", fieldLength)
+			c.feed(out)
+			frame, err := codec.Decode(c)
+			require.NoError(t, err)
+			assert.Equal(t, payload, string(frame), "%d-byte field", fieldLength)
+		}
+	}
+
+	codec := NewLengthFieldBasedFrameCodec(
+		EncoderConfig{ByteOrder: binary.BigEndian, LengthFieldLength: 1, LengthIncludesLengthFieldLength: true},
+		DecoderConfig{},
+	)
+	_, err := codec.Encode(nil, make([]byte, 254))
+	require.NoError(t, err)
+	_, err = codec.Encode(nil, make([]byte, 255))
+	assert.ErrorIs(t, err, gerr.ErrEncodeLengthOverflow, "256 doesn't fit into a byte")
+	_, err = codec.EncodeBuffers(nil, make([]byte, 255))
+	assert.ErrorIs(t, err, gerr.ErrEncodeLengthOverflow)
+}
+
+func TestLengthFieldBasedFrameCodecASCIILength(t *testing.T) {
+	for _, padding := range []byte{'0', ' '} {
+		codec := NewLengthFieldBasedFrameCodec(EncoderConfig{
+			LengthFieldLength: 4,
+			LengthFormatter:   ASCIILengthFormatter(padding),
+		}, DecoderConfig{
+			LengthFieldLength: 4,
+			LengthParser:      ASCIILengthParser(),
+		})
+		c := &mockConn{}
+		out, err := codec.Encode(c, bytes.Repeat([]byte("x"), 42))
+		require.NoError(t, err)
+		assert.Equal(t, string([]byte{padding, padding, '4', '2'}), string(out[:4]))
+		c.feed(out)
+		frame, err := codec.Decode(c)
+		require.NoError(t, err)
+		assert.Len(t, frame, 42)
+
+		_, err = codec.Encode(c, make([]byte, 10000))
+		assert.ErrorIs(t, err, gerr.ErrEncodeLengthOverflow, "10000 doesn't fit into 4 digits")
+	}
+
+	parse := ASCIILengthParser()
+	for field, want := range map[string]int{"0042": 42, "  42": 42, "42  ": 42, " 42 ": 42, "0000": 0, "   0": 0} {
+		length, err := parse([]byte(field))
+		require.NoError(t, err, "%q", field)
+		assert.Equal(t, want, length, "%q", field)
+	}
+	for _, field := range []string{"    ", "4 2 ", "-042", "0x2A", "42\r\n", "+42 "} {
+		_, err := parse([]byte(field))
+		assert.ErrorIs(t, err, gerr.ErrMalformedFrame, "%q", field)
+	}
+	_, err := parse([]byte("99999999999999999999"))
+	assert.ErrorIs(t, err, gerr.ErrFrameTooLarge, "overflows an int")
+
+	// the lengths are bounded by MaxFrameLength rather than by the parser.
+	for maxFrameLength, tooLarge := range map[int]bool{0: true, 100 << 20: false} {
+		codec := NewLengthFieldBasedFrameCodec(EncoderConfig{}, DecoderConfig{
+			LengthFieldLength: 8,
+			LengthParser:      ASCIILengthParser(),
+			MaxFrameLength:    maxFrameLength,
+		})
+		c := &mockConn{}
+		c.feed([]byte("99999999x"))
+		frame, err := codec.Decode(c)
+		assert.Nil(t, frame)
+		assert.Equal(t, tooLarge, errors.Is(err, gerr.ErrFrameTooLarge), "MaxFrameLength %d", maxFrameLength)
+	}
+}
+
+func TestLengthFieldBasedFrameCodecBCDLength(t *testing.T) {
+	codec := NewLengthFieldBasedFrameCodec(EncoderConfig{
+		LengthFieldLength: 2,
+		LengthFormatter:   BCDLengthFormatter(),
+	}, DecoderConfig{
+		LengthFieldLength:   2,
+		LengthParser:        BCDLengthParser(),
+		InitialBytesToStrip: 2,
+	})
+	c := &mockConn{}
+	payload := bytes.Repeat([]byte("x"), 1234)
+	out, err := codec.Encode(c, payload)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x12, 0x34}, out[:2])
+	c.feed(out)
+	frame, err := codec.Decode(c)
+	require.NoError(t, err)
+	assert.Equal(t, payload, frame)
+
+	out, err = codec.Encode(c, []byte("abc"))
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x00, 0x03}, out[:2])
+	_, err = codec.Encode(c, make([]byte, 10000))
+	assert.ErrorIs(t, err, gerr.ErrEncodeLengthOverflow, "10000 doesn't fit into 4 digits")
+
+	parse := BCDLengthParser()
+	for _, field := range [][]byte{{0x1A, 0x00}, {0xA0, 0x00}, {0x00, 0x0F}} {
+		_, err = parse(field)
+		assert.ErrorIs(t, err, gerr.ErrMalformedFrame, "%#x", field)
+	}
+	length, err := parse([]byte{0x99, 0x99, 0x99, 0x99})
+	require.NoError(t, err)
+	assert.Equal(t, 99999999, length)
+	_, err = parse(bytes.Repeat([]byte{0x99}, 10))
+	assert.ErrorIs(t, err, gerr.ErrFrameTooLarge, "overflows an int")
+}
+
+func TestLengthFieldBasedFrameCodecOnHeader(t *testing.T) {
+	var headers []string
+	// [2-byte destination][2-byte length][payload]
+	codec := NewLengthFieldBasedFrameCodec(EncoderConfig{}, DecoderConfig{
+		ByteOrder:           binary.BigEndian,
+		LengthFieldOffset:   2,
+		LengthFieldLength:   2,
+		InitialBytesToStrip: 4,
+		OnHeader: func(c Conn, header []byte) {
+			headers = append(headers, string(header))
+		},
+	})
+	c := &mockConn{}
+	c.feed([]byte{'d', '1', 0x00})
+	frame, _ := codec.Decode(c)
+	assert.Nil(t, frame)
+	assert.Empty(t, headers, "the header is incomplete")
+
+	// fired once as soon as the header arrives, before the payload completes.
+	c.feed([]byte{0x05, 'h', 'e'})
+	frame, _ = codec.Decode(c)
+	assert.Nil(t, frame)
+	assert.Equal(t, []string{"d1\x00\x05"}, headers)
+	c.feed([]byte{'l'})
+	frame, _ = codec.Decode(c)
+	assert.Nil(t, frame)
+	assert.Len(t, headers, 1)
+	c.feed([]byte{'l', 'o'})
+	frame, err := codec.Decode(c)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(frame))
+	assert.Len(t, headers, 1)
+
+	// fired along with a frame arriving at once.
+	c.feed([]byte{'d', '2', 0x00, 0x00})
+	frame, err = codec.Decode(c)
+	require.NoError(t, err)
+	assert.NotNil(t, frame)
+	assert.Equal(t, []string{"d1\x00\x05", "d2\x00\x00"}, headers)
+}
+
+func TestLengthFieldBasedFrameCodecInterFrameByte(t *testing.T) {
+	// a 1-byte magic 0xCA ahead of the length field, so that 0x00 never starts a frame.
+	codec := NewLengthFieldBasedFrameCodec(EncoderConfig{}, DecoderConfig{
+		ByteOrder:          binary.BigEndian,
+		LengthFieldOffset:  1,
+		LengthFieldLength:  2,
+		SkipInterFrameByte: true,
+	})
+	c := &mockConn{}
+	c.feed([]byte{0x00, 0x00, 0xCA, 0x00, 0x02, 'h', 'i', 0x00})
+	frame, err := codec.Decode(c)
+	require.NoError(t, err)
+	assert.Equal(t, "hi", string(frame))
+	frame, _ = codec.Decode(c)
+	assert.Nil(t, frame)
+	assert.Zero(t, c.InboundBuffered(), "the trailing keepalive is swallowed")
+
+	c.feed([]byte{0x00, 0xCA, 0x00})
+	frame, _ = codec.Decode(c)
+	assert.Nil(t, frame)
+	c.feed([]byte{0x01, 0x00})
+	frame, err = codec.Decode(c)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x00}, frame, "the keepalive byte may appear in the payload")
+
+	// the keepalives aren't swallowed unless SkipInterFrameByte is set.
+	codec = NewLengthFieldBasedFrameCodec(EncoderConfig{}, DecoderConfig{
+		ByteOrder:         binary.BigEndian,
+		LengthFieldLength: 1,
+		InterFrameByte:    0xFF,
+	})
+	c = &mockConn{}
+	c.feed([]byte{0xFF, 0x01, 'x'})
+	frame, _ = codec.Decode(c)
+	assert.Nil(t, frame)
+}
+
+func TestLengthFieldBasedFrameCodecNoCopy(t *testing.T) {
+	msg := []byte{0x00, 0x03, 'a', 'b', 'c', 0x00, 0x00}
+	codec := NewLengthFieldBasedFrameCodec(EncoderConfig{}, DecoderConfig{
+		ByteOrder:           binary.BigEndian,
+		LengthFieldLength:   2,
+		InitialBytesToStrip: 2,
+		NoCopy:              true,
+	})
+	c := &mockConn{}
+	c.feed(msg)
+	in := c.inbound
+	frame, err := codec.Decode(c)
+	require.NoError(t, err)
+	assert.Equal(t, "abc", string(frame))
+	assert.Same(t, &in[2], &frame[0], "the frame is borrowed")
+	frame, n, err := codec.DecodeN(c)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.NotNil(t, frame)
+	assert.Empty(t, frame)
+
+	codec = NewLengthFieldBasedFrameCodec(EncoderConfig{}, DecoderConfig{
+		ByteOrder:           binary.BigEndian,
+		LengthFieldLength:   2,
+		InitialBytesToStrip: 2,
+	})
+	c.feed(msg)
+	dst := make([]byte, 0, 8)
+	frame, err = codec.DecodeInto(c, dst)
+	require.NoError(t, err)
+	assert.Equal(t, "abc", string(frame))
+	assert.Same(t, &dst[:1][0], &frame[0], "the frame is decoded into dst")
+	frame, err = codec.DecodeInto(c, nil)
+	require.NoError(t, err)
+	assert.NotNil(t, frame)
+	assert.Empty(t, frame)
+	frame, err = codec.DecodeInto(c, dst)
+	assert.Nil(t, frame)
+	assert.ErrorIs(t, err, io.ErrShortBuffer)
+}
+
+func BenchmarkLengthFieldBasedFrameCodecDecode(b *testing.B) {
+	payload := bytes.Repeat([]byte{'x'}, 1024)
+	msg := append([]byte{0x00, 0x00, 0x04, 0x00}, payload...)
+	for _, bm := range []struct {
+		name   string
+		noCopy bool
+		into   bool
+	}{
+		{name: "Copy"},
+		{name: "NoCopy", noCopy: true},
+		{name: "Into", into: true},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			codec := NewLengthFieldBasedFrameCodec(EncoderConfig{}, DecoderConfig{
+				ByteOrder:           binary.BigEndian,
+				LengthFieldLength:   4,
+				InitialBytesToStrip: 4,
+				NoCopy:              bm.noCopy,
+			})
+			c := &mockConn{}
+			var dst []byte
+			b.ReportAllocs()
+			b.SetBytes(int64(len(msg)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				c.inbound = msg
+				var (
+					frame []byte
+					err   error
+				)
+				if bm.into {
+					frame, err = codec.DecodeInto(c, dst)
+					dst = frame
+				} else {
+					frame, err = codec.Decode(c)
+				}
+				if err != nil || len(frame) != len(payload) {
+					b.Fatalf("failed to decode the frame: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestLengthFieldBasedFrameCodecDecodePooled(t *testing.T) {
+	codec := NewLengthFieldBasedFrameCodec(
+		EncoderConfig{ByteOrder: binary.BigEndian, LengthFieldLength: 2},
+		DecoderConfig{ByteOrder: binary.BigEndian, LengthFieldLength: 2},
+	)
+	c := &mockConn{}
+	for _, payload := range []string{"pooled", ""} {
+		out, err := codec.Encode(c, []byte(payload))
+		require.NoError(t, err)
+		c.feed(out)
+	}
+	c.feed([]byte{0x00})
+
+	frame, done, err := codec.DecodePooled(c)
+	require.NoError(t, err)
+	assert.Equal(t, "pooled", string(frame))
+	done()
+
+	frame, done, err = codec.DecodePooled(c)
+	require.NoError(t, err)
+	assert.NotNil(t, frame)
+	assert.Empty(t, frame)
+	done()
+
+	frame, done, _ = codec.DecodePooled(c)
+	assert.Nil(t, frame)
+	assert.Nil(t, done)
+}
+
+func TestLengthFieldBasedFrameCodecDecodeN(t *testing.T) {
+	codec := NewLengthFieldBasedFrameCodec(
+		EncoderConfig{ByteOrder: binary.BigEndian, LengthFieldLength: 2, CRCScope: CRCPayloadOnly},
+		DecoderConfig{ByteOrder: binary.BigEndian, LengthFieldLength: 2, CRCScope: CRCPayloadOnly},
+	)
+	c := &mockConn{}
+	out, err := codec.Encode(c, []byte("counted"))
+	require.NoError(t, err)
+	corrupted := append([]byte{}, out...)
+	corrupted[2]++
+	c.feed(out)
+	c.feed(corrupted)
+	c.feed(out[:3])
+
+	frame, consumed, err := codec.DecodeN(c)
+	require.NoError(t, err)
+	assert.Equal(t, "counted", string(frame))
+	assert.Equal(t, len(out), consumed, "header and checksum should be counted")
+
+	frame, consumed, err = codec.DecodeN(c)
+	assert.ErrorIs(t, err, gerr.ErrInvalidChecksum)
+	assert.Nil(t, frame)
+	assert.Equal(t, len(out), consumed, "the discarded frame should be counted")
+
+	frame, consumed, _ = codec.DecodeN(c)
+	assert.Nil(t, frame)
+	assert.Zero(t, consumed)
+	assert.Equal(t, 3, c.InboundBuffered())
+}
+
+func TestLengthFieldBasedFrameCodecAdjustmentField(t *testing.T) {
+	// a 2-byte big-endian length of the body followed by a 4-byte little-endian length of the attachment.
+	codec := NewLengthFieldBasedFrameCodec(EncoderConfig{}, DecoderConfig{
+		ByteOrder:         binary.BigEndian,
+		LengthFieldLength: 2,
+		InterHeaderSkip:   4,
+		AdjustmentField:   HeaderField{Offset: 2, Length: 4, ByteOrder: binary.LittleEndian},
+	})
+	msg := []byte{0x00, 0x05, 0x03, 0x00, 0x00, 0x00}
+	msg = append(msg, "helloabc"...)
+
+	c := &mockConn{}
+	c.feed(msg[:5])
+	frame, _ := codec.Decode(c)
+	assert.Nil(t, frame, "incomplete adjustment field should not be decoded")
+	c.feed(msg[5 : len(msg)-1])
+	frame, _ = codec.Decode(c)
+	assert.Nil(t, frame, "incomplete frame should not be decoded")
+	c.feed(msg[len(msg)-1:])
+	frame, err := codec.Decode(c)
+	require.NoError(t, err)
+	assert.Equal(t, "helloabc", string(frame))
+	assert.Zero(t, c.InboundBuffered())
+
+	codec = NewLengthFieldBasedFrameCodec(EncoderConfig{}, DecoderConfig{
+		ByteOrder:         binary.BigEndian,
+		LengthFieldLength: 2,
+		AdjustmentField:   HeaderField{Offset: 0, Length: 5},
+	})
+	c.feed(msg)
+	_, err = codec.Decode(c)
+	assert.ErrorIs(t, err, gerr.ErrUnsupportedLength)
+
+	// the adjustment field must not wrap a length near math.MaxInt64 around into one that
+	// LengthAdjustment then brings back to zero.
+	codec = NewLengthFieldBasedFrameCodec(EncoderConfig{}, DecoderConfig{
+		ByteOrder:         binary.BigEndian,
+		LengthFieldLength: 8,
+		InterHeaderSkip:   4,
+		AdjustmentField:   HeaderField{Offset: 8, Length: 4},
+		LengthAdjustment:  math.MaxInt64,
+	})
+	c = &mockConn{}
+	c.feed(binary.BigEndian.AppendUint32(binary.BigEndian.AppendUint64(nil, math.MaxInt64), 2))
+	_, err = codec.Decode(c)
+	assert.ErrorIs(t, err, gerr.ErrMalformedFrame)
+}
+
+func TestLengthFieldBasedFrameCodecTrailerLength(t *testing.T) {
+	const signed = 0x80
+	// [flags][2-byte length][payload][8-byte signature if the signed flag is set][crc]
+	codec := NewLengthFieldBasedFrameCodec(EncoderConfig{}, DecoderConfig{
+		ByteOrder:         binary.BigEndian,
+		LengthFieldOffset: 1,
+		LengthFieldLength: 2,
+		CRCScope:          CRCPayloadOnly,
+		TrailerLength: func(header []byte) (int, error) {
+			if header[0]&signed != 0 {
+				return 8, nil
+			}
+			return 0, nil
+		},
+	})
+	msg := func(flags byte, payload, signature string) []byte {
+		b := append([]byte{flags, 0, byte(len(payload))}, payload...)
+		b = append(b, signature...)
+		return binary.BigEndian.AppendUint32(b, crc32.ChecksumIEEE(b[3:]))
+	}
+
+	c := &mockConn{}
+	c.feed(msg(0, "plain", ""))
+	signedMsg := msg(signed, "signed", "SIGNATUR")
+	c.feed(signedMsg[:len(signedMsg)-1])
+
+	frame, err := codec.Decode(c)
+	require.NoError(t, err)
+	assert.Equal(t, "plain", string(frame))
+	frame, _ = codec.Decode(c)
+	assert.Nil(t, frame, "the frame without the whole trailer should not be decoded")
+
+	c.feed(signedMsg[len(signedMsg)-1:])
+	frame, err = codec.Decode(c)
+	require.NoError(t, err)
+	assert.Equal(t, "signedSIGNATUR", string(frame))
+	assert.Zero(t, c.InboundBuffered())
+}
+
+func TestDecodeBatch(t *testing.T) {
+	codec := NewLengthFieldBasedFrameCodec(
+		EncoderConfig{ByteOrder: binary.BigEndian, LengthFieldLength: 2},
+		DecoderConfig{ByteOrder: binary.BigEndian, LengthFieldLength: 2},
+	)
+	c := &mockConn{wakeCh: make(chan struct{}, 1)}
+	for _, payload := range []string{"a", "b", "c"} {
+		out, err := codec.Encode(c, []byte(payload))
+		require.NoError(t, err)
+		c.feed(out)
+	}
+	c.feed([]byte{0x00})
+
+	frames, err := DecodeBatch(c, codec, 2)
+	require.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("a"), []byte("b")}, frames)
+	assert.Len(t, c.wakeCh, 1, "the connection should be woken for the remaining frames")
+	<-c.wakeCh
+	frames, err = DecodeBatch(c, codec, 2)
+	require.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("c")}, frames)
+	assert.Empty(t, c.wakeCh, "an incomplete frame should wait for more bytes")
+	assert.Equal(t, 1, c.InboundBuffered())
+
+	c.feed([]byte{0x01, 'd'})
+	frames, err = DecodeBatch(c, codec, 0)
+	require.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("d")}, frames)
+}
+
+func TestDecodeContext(t *testing.T) {
+	codec := NewLengthFieldBasedFrameCodec(
+		EncoderConfig{ByteOrder: binary.BigEndian, LengthFieldLength: 2},
+		DecoderConfig{ByteOrder: binary.BigEndian, LengthFieldLength: 2},
+	)
+	c := &mockConn{wakeCh: make(chan struct{}, 1)}
+	out, err := codec.Encode(c, []byte("slow frame"))
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stop := WakeOnDone(ctx, c)
+	defer stop()
+	c.feed(out)
+	c.feed(out[:4])
+	frame, err := DecodeContext(ctx, c, codec)
+	require.NoError(t, err)
+	assert.Equal(t, "slow frame", string(frame))
+	frame, _ = DecodeContext(ctx, c, codec)
+	assert.Nil(t, frame)
+
+	cancel()
+	select {
+	case <-c.wakeCh:
+	case <-time.After(time.Second):
+		t.Fatal("the connection should be woken up once the context is done")
+	}
+	_, err = DecodeContext(ctx, c, codec)
+	assert.ErrorIs(t, err, context.Canceled)
+
+	// a complete frame is decoded regardless of the context.
+	c.inbound = nil
+	c.feed(out)
+	frame, err = DecodeContext(ctx, c, codec)
+	require.NoError(t, err)
+	assert.Equal(t, "slow frame", string(frame))
+	frame, err = DecodeContext(ctx, c, codec)
+	assert.NotErrorIs(t, err, context.Canceled, "nothing is abandoned without any buffered byte")
+	assert.Nil(t, frame)
+}
+
+func TestLengthFieldBasedFrameCodecOnFrameTooLarge(t *testing.T) {
+	var declared []int
+	codec := NewLengthFieldBasedFrameCodec(EncoderConfig{}, DecoderConfig{
+		ByteOrder:         binary.BigEndian,
+		LengthFieldLength: 4,
+		OnFrameTooLarge: func(c Conn, declaredLen int) {
+			require.NotNil(t, c)
+			declared = append(declared, declaredLen)
+		},
+	})
+	c := &mockConn{}
+	c.feed([]byte{0x00, 0xA0, 0x00, 0x00, 'x'})
+	frame, err := codec.Decode(c)
+	assert.ErrorIs(t, err, gerr.ErrFrameTooLarge)
+	assert.Contains(t, err.Error(), strconv.Itoa(4+0xA00000), "the error carries the declared length")
+	assert.Nil(t, frame)
+	assert.Equal(t, []int{4 + 0xA00000}, declared)
+
+	// a frame under the limit doesn't fire the callback.
+	c.inbound = nil
+	c.feed([]byte{0x00, 0x00, 0x00, 0x01, 'x'})
+	frame, err = codec.Decode(c)
+	require.NoError(t, err)
+	assert.Equal(t, "x", string(frame))
+	assert.Len(t, declared, 1)
+
+	codec = NewLengthFieldBasedFrameCodec(EncoderConfig{}, DecoderConfig{
+		ByteOrder:         binary.BigEndian,
+		LengthFieldLength: 1,
+		MaxFrameLength:    4,
+	})
+	c = &mockConn{}
+	c.feed([]byte{0x03, 'a', 'b', 'c', 0x04})
+	frame, err = codec.Decode(c)
+	require.NoError(t, err)
+	assert.Equal(t, "abc", string(frame), "a frame of MaxFrameLength is decoded")
+	_, err = codec.Decode(c)
+	assert.ErrorIs(t, err, gerr.ErrFrameTooLarge)
+	var tooLarge *gerr.FrameTooLargeError
+	require.ErrorAs(t, err, &tooLarge)
+	assert.Equal(t, gerr.FrameTooLargeError{Length: 5, Max: 4}, *tooLarge)
+}
+
+func TestLengthFieldBasedFrameCodecDecodeRaw(t *testing.T) {
+	codec := NewLengthFieldBasedFrameCodec(
+		EncoderConfig{ByteOrder: binary.BigEndian, LengthFieldLength: 2, CRCScope: CRCIncludeHeader},
+		DecoderConfig{ByteOrder: binary.BigEndian, LengthFieldLength: 2, CRCScope: CRCIncludeHeader},
+	)
+	c := &mockConn{}
+	out, err := codec.Encode(c, []byte("audited"))
+	require.NoError(t, err)
+	c.feed(out)
+	c.feed(out[:2])
+
+	frame, raw, err := codec.DecodeRaw(c)
+	require.NoError(t, err)
+	assert.Equal(t, "audited", string(frame))
+	assert.Equal(t, out, raw)
+
+	frame, raw, _ = codec.DecodeRaw(c)
+	assert.Nil(t, frame)
+	assert.Nil(t, raw)
+	assert.Equal(t, 2, c.InboundBuffered())
+}
@@ -0,0 +1,151 @@
+// Copyright (c) 2019 Andy Pan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gnet
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/walkon/wsgnet/pkg/errors"
+)
+
+// versionKey is the key of the version of the frames of a connection, which is chosen by its first frame,
+// stored by Conn.SetCodecScratch.
+type versionKey struct {
+	cc *VersionedLengthFrameCodec
+}
+
+// VersionedLengthFrameCodec frames the protocols whose frames are [1-byte version][length][payload], where the
+// width of the length field depends on the version, 2 bytes for v1 and 4 bytes for v2 for instance, which is
+// coded with:
+//
+//	NewVersionedLengthFrameCodec(binary.BigEndian, map[uint8]int{1: 2, 2: 4})
+//
+// The first frame of a connection is decoded as the two-stage length field of DecoderConfig.LengthFieldExtension,
+// whose coarse part is the version byte picking the width of the fine part, the length. The version is then cached
+// per connection by Conn.SetCodecScratch, so that the following frames are decoded by the length field of the
+// cached width right away, and the frames of any other version are rejected with errors.ErrMalformedFrame.
+//
+// Decode returns the payload of the next frame, use Version for the version of the connection. Encode encodes the
+// frames in the version of the connection, so the replies follow the version chosen by the peer, and EncodeFrame
+// encodes the frames of an explicit version, the first frame of a client for instance. VersionedLengthFrameCodec
+// can be shared between connections.
+type VersionedLengthFrameCodec struct {
+	byteOrder binary.ByteOrder
+	widths    map[uint8]int
+	detect    *LengthFieldBasedFrameCodec
+	fixed     map[uint8]*LengthFieldBasedFrameCodec
+}
+
+// NewVersionedLengthFrameCodec instantiates and returns a VersionedLengthFrameCodec with the lengths in byteOrder,
+// widths maps each version to the width of its length field, 1, 2, 3 or 4 bytes.
+func NewVersionedLengthFrameCodec(byteOrder binary.ByteOrder, widths map[uint8]int) *VersionedLengthFrameCodec {
+	cc := &VersionedLengthFrameCodec{
+		byteOrder: byteOrder,
+		widths:    make(map[uint8]int, len(widths)),
+		fixed:     make(map[uint8]*LengthFieldBasedFrameCodec, len(widths)),
+	}
+	for version, width := range widths {
+		cc.widths[version] = width
+		cc.fixed[version] = NewLengthFieldBasedFrameCodec(EncoderConfig{
+			ByteOrder:         byteOrder,
+			LengthFieldLength: width,
+		}, DecoderConfig{
+			ByteOrder:         byteOrder,
+			LengthFieldOffset: 1,
+			LengthFieldLength: width,
+		})
+	}
+	cc.detect = NewLengthFieldBasedFrameCodec(EncoderConfig{}, DecoderConfig{
+		ByteOrder:            byteOrder,
+		LengthFieldLength:    1,
+		LengthFieldExtension: cc.extendLength,
+		LengthParser:         cc.parseLength,
+	})
+	return cc
+}
+
+// extendLength extends the version byte by the length field of the width of the version.
+func (cc *VersionedLengthFrameCodec) extendLength(coarse []byte) (int, error) {
+	width, ok := cc.widths[coarse[0]]
+	if !ok {
+		return 0, fmt.Errorf("%w: unknown version %d", errors.ErrMalformedFrame, coarse[0])
+	}
+	return width, nil
+}
+
+// parseLength parses the length following the version byte.
+func (cc *VersionedLengthFrameCodec) parseLength(lengthField []byte) (int, error) {
+	length, err := readUint(cc.byteOrder, lengthField[1:], len(lengthField)-1)
+	if err != nil {
+		return 0, err
+	}
+	return int(length), nil
+}
+
+// Version returns the version of the frames of c, ok is false until the first frame of c is decoded.
+func (cc *VersionedLengthFrameCodec) Version(c Conn) (version uint8, ok bool) {
+	version, ok = c.CodecScratch(versionKey{cc}).(uint8)
+	return
+}
+
+// Encode encodes buf into a frame of the version of c.
+func (cc *VersionedLengthFrameCodec) Encode(c Conn, buf []byte) ([]byte, error) {
+	version, ok := cc.Version(c)
+	if !ok {
+		return nil, fmt.Errorf("%w: no version chosen by the peer yet", errors.ErrUnsupportedOp)
+	}
+	return cc.EncodeFrame(version, buf)
+}
+
+// EncodeFrame encodes payload into a frame of version.
+func (cc *VersionedLengthFrameCodec) EncodeFrame(version uint8, payload []byte) ([]byte, error) {
+	lfb, ok := cc.fixed[version]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown version %d", errors.ErrUnsupportedOp, version)
+	}
+	width := cc.widths[version]
+	out := make([]byte, 1+width+len(payload))
+	out[0] = version
+	if err := lfb.putFrameLength(out[1:], len(payload)); err != nil {
+		return nil, err
+	}
+	copy(out[1+width:], payload)
+	return out, nil
+}
+
+// Decode decodes the payload of the next complete frame, the first frame of c chooses the version of c.
+func (cc *VersionedLengthFrameCodec) Decode(c Conn) ([]byte, error) {
+	lfb := cc.detect
+	version, cached := cc.Version(c)
+	if cached {
+		if in, _ := c.Peek(1); len(in) == 1 && in[0] != version {
+			return nil, fmt.Errorf("%w: version %d of a connection of version %d", errors.ErrMalformedFrame, in[0], version)
+		}
+		lfb = cc.fixed[version]
+	}
+	in, msgLength, err := lfb.peekFrame(c)
+	if in == nil {
+		return nil, err
+	}
+	if !cached {
+		head, _ := c.Peek(1)
+		c.SetCodecScratch(versionKey{cc}, head[0])
+	}
+	frame := make([]byte, len(in))
+	copy(frame, in)
+	_, _ = c.Discard(msgLength)
+	return frame, nil
+}
@@ -0,0 +1,77 @@
+// Copyright (c) 2019 Andy Pan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gnet
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	gerr "github.com/walkon/wsgnet/pkg/errors"
+)
+
+func TestVersionedLengthFrameCodec(t *testing.T) {
+	codec := NewVersionedLengthFrameCodec(binary.BigEndian, map[uint8]int{1: 2, 2: 4})
+	for version, header := range map[uint8]string{1: "\x01\x00\x02", 2: "\x02\x00\x00\x00\x02"} {
+		c := &mockConn{}
+		_, ok := codec.Version(c)
+		assert.False(t, ok)
+		_, err := codec.Encode(c, []byte("hi"))
+		assert.ErrorIs(t, err, gerr.ErrUnsupportedOp, "no version yet")
+
+		out, err := codec.EncodeFrame(version, []byte("hi"))
+		require.NoError(t, err)
+		assert.Equal(t, header+"hi", string(out))
+
+		// the first frame chooses the version, accumulating across the reads.
+		c.feed(out[:2])
+		frame, _ := codec.Decode(c)
+		assert.Nil(t, frame)
+		c.feed(out[2:])
+		frame, err = codec.Decode(c)
+		require.NoError(t, err)
+		assert.Equal(t, "hi", string(frame))
+		got, ok := codec.Version(c)
+		assert.True(t, ok)
+		assert.Equal(t, version, got)
+
+		// the following frames are in the cached version.
+		out, err = codec.Encode(c, nil)
+		require.NoError(t, err)
+		assert.Equal(t, header[:len(header)-1]+"\x00", string(out))
+		c.feed(out)
+		frame, err = codec.Decode(c)
+		require.NoError(t, err)
+		assert.NotNil(t, frame)
+		assert.Empty(t, frame)
+
+		other, err := codec.EncodeFrame(3-version, []byte("hi"))
+		require.NoError(t, err)
+		c.feed(other)
+		_, err = codec.Decode(c)
+		assert.ErrorIs(t, err, gerr.ErrMalformedFrame, "the version can't change")
+	}
+
+	c := &mockConn{}
+	c.feed([]byte{0x03, 0x00, 0x00})
+	_, err := codec.Decode(c)
+	assert.ErrorIs(t, err, gerr.ErrMalformedFrame, "unknown version")
+	_, err = codec.EncodeFrame(3, nil)
+	assert.ErrorIs(t, err, gerr.ErrUnsupportedOp)
+	_, err = codec.EncodeFrame(1, make([]byte, 65536))
+	assert.ErrorIs(t, err, gerr.ErrEncodeLengthOverflow)
+}
@@ -0,0 +1,134 @@
+// Copyright (c) 2019 Andy Pan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gnet
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/walkon/wsgnet/pkg/errors"
+)
+
+// x11LengthUnit is the number of bytes per unit of the request length of X11.
+const x11LengthUnit = 4
+
+// X11Request is a request of the X11 protocol.
+type X11Request struct {
+	// MajorOpcode is the major opcode of the request.
+	MajorOpcode uint8
+	// Data is the second byte of the request, which is the minor opcode of the extension requests.
+	Data uint8
+	// Request is the whole request including the header.
+	Request []byte
+}
+
+// X11RequestCodec frames the requests of the X11 protocol, each of which starts with the header
+// [1-byte major opcode][1-byte data][2-byte request length], where the request length counts the whole request
+// in 4-byte units. It's the length field preset of LengthFieldOffset=2, LengthFieldLength=2, LengthFieldUnit=4,
+// LengthAdjustment=-2 and LengthIncludesLengthFieldLength, and nothing is stripped.
+//
+// With the BIG-REQUESTS extension enabled, a request length of zero is followed by a 4-byte extended request
+// length counting the whole request in 4-byte units as well, which is a two-stage length field decoded by
+// LengthFieldExtension and LengthParser.
+//
+// The requests are fixed in the byte order the client has chosen by the first byte of its connection setup,
+// 'B' for big-endian and 'l' for little-endian, which is supposed to be consumed before decoding the requests.
+// Decode returns the whole request, use DecodeRequest for its opcodes. X11RequestCodec is stateless, so it
+// can be shared between the connections of the same byte order.
+type X11RequestCodec struct {
+	byteOrder   binary.ByteOrder
+	bigRequests bool
+	lfb         *LengthFieldBasedFrameCodec
+}
+
+// NewX11RequestCodec instantiates and returns an X11RequestCodec decoding the requests in byteOrder,
+// bigRequests indicates whether the BIG-REQUESTS extension has been enabled.
+func NewX11RequestCodec(byteOrder binary.ByteOrder, bigRequests bool) *X11RequestCodec {
+	cc := &X11RequestCodec{byteOrder: byteOrder, bigRequests: bigRequests}
+	dc := DecoderConfig{
+		ByteOrder:                       byteOrder,
+		LengthFieldOffset:               2,
+		LengthFieldLength:               2,
+		LengthFieldUnit:                 x11LengthUnit,
+		LengthIncludesLengthFieldLength: true,
+		LengthAdjustment:                -2,
+		InitialBytesToStrip:             StripNone,
+		LengthParser:                    cc.parseLength,
+	}
+	if bigRequests {
+		dc.LengthFieldExtension = cc.extendLength
+	}
+	cc.lfb = NewLengthFieldBasedFrameCodec(EncoderConfig{}, dc)
+	return cc
+}
+
+// extendLength extends the length field by the extended request length of BIG-REQUESTS
+// if the request length is zero.
+func (cc *X11RequestCodec) extendLength(coarse []byte) (int, error) {
+	if cc.byteOrder.Uint16(coarse) == 0 {
+		return 4, nil
+	}
+	return 0, nil
+}
+
+// parseLength parses the request length or the extended request length in 4-byte units.
+func (cc *X11RequestCodec) parseLength(lengthField []byte) (int, error) {
+	length := uint64(cc.byteOrder.Uint16(lengthField))
+	if len(lengthField) > 2 {
+		length = uint64(cc.byteOrder.Uint32(lengthField[2:]))
+	}
+	if length == 0 {
+		return 0, fmt.Errorf("%w: X11 request length of zero", errors.ErrMalformedFrame)
+	}
+	return int(length), nil
+}
+
+// Encode validates that buf is a whole request assembled by the caller, padded to 4 bytes, and passes it through.
+func (cc *X11RequestCodec) Encode(_ Conn, buf []byte) ([]byte, error) {
+	if len(buf) < x11LengthUnit || len(buf)%x11LengthUnit != 0 {
+		return nil, fmt.Errorf("%w: X11 request of %d bytes", errors.ErrMalformedFrame, len(buf))
+	}
+	length := uint64(cc.byteOrder.Uint16(buf[2:]))
+	if length == 0 && cc.bigRequests && len(buf) >= 2*x11LengthUnit {
+		length = uint64(cc.byteOrder.Uint32(buf[4:]))
+	}
+	if length*x11LengthUnit != uint64(len(buf)) {
+		return nil, fmt.Errorf("%w: X11 request length %d of a %d-byte request",
+			errors.ErrMalformedFrame, length, len(buf))
+	}
+	return buf, nil
+}
+
+// Decode decodes the next complete request including its header.
+func (cc *X11RequestCodec) Decode(c Conn) ([]byte, error) {
+	req, err := cc.DecodeRequest(c)
+	if req == nil {
+		return nil, err
+	}
+	return req.Request, nil
+}
+
+// DecodeRequest decodes the next complete request along with its opcodes, it returns nil request
+// and nil error when more bytes are required to complete the request.
+func (cc *X11RequestCodec) DecodeRequest(c Conn) (*X11Request, error) {
+	in, msgLength, err := cc.lfb.peekFrame(c)
+	if in == nil {
+		return nil, err
+	}
+	req := &X11Request{MajorOpcode: in[0], Data: in[1], Request: make([]byte, msgLength)}
+	copy(req.Request, in)
+	_, _ = c.Discard(msgLength)
+	return req, nil
+}
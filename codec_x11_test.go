@@ -0,0 +1,76 @@
+// Copyright (c) 2019 Andy Pan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gnet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	gerr "github.com/walkon/wsgnet/pkg/errors"
+)
+
+func TestX11RequestCodec(t *testing.T) {
+	codec := NewX11RequestCodec(binary.LittleEndian, true)
+	// GetInputFocus, a header-only request of 1 unit.
+	getInputFocus := []byte{43, 0, 1, 0}
+	// InternAtom of the name "WM_NAME" padded to 4 bytes, 4 units in all.
+	internAtom := append([]byte{16, 0, 4, 0, 7, 0, 0, 0}, "WM_NAME\x00"...)
+	// PutImage of 1MB data by the extended request length of BIG-REQUESTS.
+	putImage := append([]byte{72, 2, 0, 0}, binary.LittleEndian.AppendUint32(nil, 2+1<<18)...)
+	putImage = append(putImage, bytes.Repeat([]byte{0xAB}, 1<<20)...)
+	for _, req := range [][]byte{getInputFocus, internAtom, putImage} {
+		out, err := codec.Encode(nil, req)
+		require.NoError(t, err)
+		assert.Equal(t, req, out)
+	}
+
+	c := &mockConn{}
+	c.feed(internAtom[:6])
+	req, _ := codec.DecodeRequest(c)
+	assert.Nil(t, req)
+	c.feed(internAtom[6:])
+	c.feed(getInputFocus)
+	c.feed(putImage[:6])
+	req, err := codec.DecodeRequest(c)
+	require.NoError(t, err)
+	assert.Equal(t, &X11Request{MajorOpcode: 16, Request: internAtom}, req)
+	frame, err := codec.Decode(c)
+	require.NoError(t, err)
+	assert.Equal(t, getInputFocus, frame)
+	frame, _ = codec.Decode(c)
+	assert.Nil(t, frame, "the extended request length is incomplete")
+	c.feed(putImage[6:])
+	req, err = codec.DecodeRequest(c)
+	require.NoError(t, err)
+	assert.Equal(t, uint8(72), req.MajorOpcode)
+	assert.Equal(t, uint8(2), req.Data)
+	assert.True(t, bytes.Equal(putImage, req.Request))
+	assert.Zero(t, c.InboundBuffered())
+
+	// a request length of zero is invalid without BIG-REQUESTS, so is the one shorter than the header.
+	_, err = NewX11RequestCodec(binary.LittleEndian, false).Decode(&mockConn{inbound: putImage[:12]})
+	assert.ErrorIs(t, err, gerr.ErrMalformedFrame)
+	_, err = codec.Decode(&mockConn{inbound: []byte{72, 2, 0, 0, 1, 0, 0, 0}})
+	assert.ErrorIs(t, err, gerr.ErrMalformedFrame)
+
+	_, err = codec.Encode(nil, internAtom[:14])
+	assert.ErrorIs(t, err, gerr.ErrMalformedFrame, "a request must be padded to 4 bytes")
+	_, err = codec.Encode(nil, append([]byte{16, 0, 3, 0}, internAtom[4:]...))
+	assert.ErrorIs(t, err, gerr.ErrMalformedFrame)
+}
@@ -0,0 +1,190 @@
+// Copyright (c) 2019 Andy Pan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gnet
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/walkon/wsgnet/pkg/errors"
+)
+
+const (
+	// zstdMagic starts every zstd frame, in little-endian.
+	zstdMagic = 0xFD2FB528
+	// zstdSkippableMagic starts a skippable frame with its lowest 4 bits being user-defined, in little-endian.
+	zstdSkippableMagic = 0x184D2A50
+	// zstdMaxBlockLength is the limit of the size of a block.
+	zstdMaxBlockLength = 131072
+
+	// zstdBlockRLE is the type of the block made up of a single byte repeated.
+	zstdBlockRLE = 1
+)
+
+// ZstdCodec speaks the zstd frame format, see RFC 8878, where the stream is made up of the zstd frames
+// [4-byte magic 0xFD2FB528][frame header][blocks][optional 4-byte checksum] and the skippable frames
+// [4-byte magic 0x184D2A5?][4-byte size][user data], all in little-endian.
+//
+// Decode scans the frame header and the 3-byte block headers to find the end of the next zstd frame, skipping the
+// skippable frames, and returns the frame decompressed by compressor, while Encode returns buf compressed by
+// compressor, which is required to produce a single zstd frame, the frames going beyond 10MB either compressed
+// or decompressed are rejected by errors.ErrFrameTooLarge.
+//
+// ZstdCodec isn't ready to use on its own: the standard library has no zstd, so there is no default compressor
+// and the caller must supply one implemented upon a third-party library, github.com/klauspost/compress/zstd for
+// instance. Encode and Decode fail with errors.ErrUnsupportedOp without it.
+//
+// ZstdCodec keeps no state of its own, so it can be shared between connections if compressor can.
+type ZstdCodec struct {
+	compressor Compressor
+}
+
+// NewZstdCodec instantiates and returns a ZstdCodec that decompresses and compresses the frames with compressor,
+// which is required.
+func NewZstdCodec(compressor Compressor) *ZstdCodec {
+	return &ZstdCodec{compressor: compressor}
+}
+
+// Encode compresses buf into a zstd frame.
+func (cc *ZstdCodec) Encode(_ Conn, buf []byte) ([]byte, error) {
+	if cc.compressor == nil {
+		return nil, fmt.Errorf("%w: no zstd compressor", errors.ErrUnsupportedOp)
+	}
+	frame, err := cc.compressor.Compress(buf)
+	if err != nil {
+		return nil, err
+	}
+	if len(frame) < 4 || binary.LittleEndian.Uint32(frame) != zstdMagic {
+		return nil, fmt.Errorf("%w: compressed into no zstd frame", errors.ErrMalformedFrame)
+	}
+	return frame, nil
+}
+
+// Decode decodes the decompressed data of the next zstd frame, the skippable frames ahead of it are consumed,
+// it returns nil and nil error when more bytes are required.
+func (cc *ZstdCodec) Decode(c Conn) ([]byte, error) {
+	if cc.compressor == nil {
+		return nil, fmt.Errorf("%w: no zstd compressor", errors.ErrUnsupportedOp)
+	}
+	for {
+		in, _ := c.Peek(c.InboundBuffered())
+		if len(in) < 4 {
+			return nil, nil
+		}
+		if magic := binary.LittleEndian.Uint32(in); magic&^0xF == zstdSkippableMagic {
+			if len(in) < 8 {
+				return nil, nil
+			}
+			size := binary.LittleEndian.Uint32(in[4:])
+			if size > defaultMaxFrameLength {
+				return nil, fmt.Errorf("%w: zstd skippable frame of %d bytes", errors.ErrFrameTooLarge, size)
+			}
+			if len(in) < 8+int(size) {
+				return nil, nil
+			}
+			_, _ = c.Discard(8 + int(size))
+			continue
+		} else if magic != zstdMagic {
+			return nil, fmt.Errorf("%w: zstd magic %#x", errors.ErrMalformedFrame, magic)
+		}
+
+		frameLength, contentSize, err := scanZstdFrame(in)
+		if frameLength == 0 || err != nil {
+			return nil, err
+		}
+		if contentSize > defaultMaxFrameLength {
+			return nil, fmt.Errorf("%w: zstd frame content of %d bytes", errors.ErrFrameTooLarge, contentSize)
+		}
+		// in is borrowed from the inbound buffer, so the decompression must be done before discarding it.
+		out, err := cc.compressor.Decompress(in[:frameLength])
+		_, _ = c.Discard(frameLength)
+		if err != nil {
+			return nil, err
+		}
+		if len(out) > defaultMaxFrameLength {
+			return nil, fmt.Errorf("%w: zstd frame decompressed into %d bytes", errors.ErrFrameTooLarge, len(out))
+		}
+		return out, nil
+	}
+}
+
+// scanZstdFrame finds the end of the zstd frame starting in, it returns the length of the frame along with its
+// content size if the frame header carries it, or zero length if in doesn't hold the whole frame.
+func scanZstdFrame(in []byte) (frameLength int, contentSize uint64, err error) {
+	if len(in) < 5 {
+		return 0, 0, nil
+	}
+	descriptor := in[4]
+	if descriptor&0x08 != 0 {
+		return 0, 0, fmt.Errorf("%w: zstd reserved bit set", errors.ErrMalformedFrame)
+	}
+	singleSegment := descriptor&0x20 != 0
+	fcsLength := [4]int{0, 2, 4, 8}[descriptor>>6]
+	if fcsLength == 0 && singleSegment {
+		fcsLength = 1
+	}
+	off := 5
+	if !singleSegment {
+		// the window descriptor.
+		off++
+	}
+	off += [4]int{0, 1, 2, 4}[descriptor&0x03]
+	if len(in) < off+fcsLength {
+		return 0, 0, nil
+	}
+	switch fcsLength {
+	case 1:
+		contentSize = uint64(in[off])
+	case 2:
+		contentSize = uint64(binary.LittleEndian.Uint16(in[off:])) + 256
+	case 4:
+		contentSize = uint64(binary.LittleEndian.Uint32(in[off:]))
+	case 8:
+		contentSize = binary.LittleEndian.Uint64(in[off:])
+	}
+	off += fcsLength
+
+	for last := false; !last; {
+		if len(in) < off+3 {
+			return 0, 0, nil
+		}
+		header := uint32(in[off]) | uint32(in[off+1])<<8 | uint32(in[off+2])<<16
+		last = header&0x01 != 0
+		size := int(header >> 3)
+		blockType := header >> 1 & 0x03
+		if blockType == 3 {
+			return 0, 0, fmt.Errorf("%w: zstd reserved block type", errors.ErrMalformedFrame)
+		}
+		if size > zstdMaxBlockLength {
+			return 0, 0, fmt.Errorf("%w: zstd block of %d bytes", errors.ErrMalformedFrame, size)
+		}
+		if blockType == zstdBlockRLE {
+			// an RLE block is a single byte repeated size times.
+			size = 1
+		}
+		off += 3 + size
+		if off > defaultMaxFrameLength {
+			return 0, 0, fmt.Errorf("%w: zstd frame beyond %d bytes", errors.ErrFrameTooLarge, defaultMaxFrameLength)
+		}
+	}
+	if descriptor&0x04 != 0 {
+		// the content checksum.
+		off += 4
+	}
+	if len(in) < off {
+		return 0, 0, nil
+	}
+	return off, contentSize, nil
+}
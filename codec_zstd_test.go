@@ -0,0 +1,127 @@
+// Copyright (c) 2019 Andy Pan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gnet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	gerr "github.com/walkon/wsgnet/pkg/errors"
+)
+
+// rawZstdCompressor stores the data in the raw blocks of a single-segment zstd frame with a 4-byte content size
+// and a content checksum, which is decompressed by replaying the raw and the RLE blocks.
+type rawZstdCompressor struct{}
+
+func (rawZstdCompressor) Compress(src []byte) ([]byte, error) {
+	frame := binary.LittleEndian.AppendUint32(nil, zstdMagic)
+	frame = append(frame, 0xA4)
+	frame = binary.LittleEndian.AppendUint32(frame, uint32(len(src)))
+	for {
+		block := src
+		if len(block) > zstdMaxBlockLength {
+			block = block[:zstdMaxBlockLength]
+		}
+		src = src[len(block):]
+		header := uint32(len(block)) << 3
+		if len(src) == 0 {
+			header |= 1
+		}
+		frame = append(frame, byte(header), byte(header>>8), byte(header>>16))
+		frame = append(frame, block...)
+		if len(src) == 0 {
+			return append(frame, 0, 0, 0, 0), nil
+		}
+	}
+}
+
+func (rawZstdCompressor) Decompress(src []byte) ([]byte, error) {
+	// the frame header is either the one of Compress or the one without the content size and the checksum.
+	off, end := 9, len(src)-4
+	if src[4] != 0xA4 {
+		off, end = 7, len(src)
+	}
+	var out []byte
+	for off < end {
+		header := uint32(src[off]) | uint32(src[off+1])<<8 | uint32(src[off+2])<<16
+		size := int(header >> 3)
+		off += 3
+		if header>>1&0x03 == zstdBlockRLE {
+			out = append(out, bytes.Repeat(src[off:off+1], size)...)
+			off++
+		} else {
+			out = append(out, src[off:off+size]...)
+			off += size
+		}
+	}
+	return out, nil
+}
+
+func TestZstdCodec(t *testing.T) {
+	codec := NewZstdCodec(rawZstdCompressor{})
+	c := &mockConn{}
+	payloads := [][]byte{[]byte("zstd"), bytes.Repeat([]byte{'z'}, zstdMaxBlockLength+100)}
+	var stream []byte
+	for _, payload := range payloads {
+		out, err := codec.Encode(c, payload)
+		require.NoError(t, err)
+		// a skippable frame ahead of every zstd frame is skipped.
+		stream = append(stream, 0x5A, 0x2A, 0x4D, 0x18, 3, 0, 0, 0, 's', 'k', 'p')
+		stream = append(stream, out...)
+	}
+	// a multi-segment frame without the content size, holding an RLE block with a window descriptor and a dict id.
+	stream = append(stream, 0x28, 0xB5, 0x2F, 0xFD, 0x01, 0x00, 0x07, 5<<3|zstdBlockRLE<<1|1, 0, 0, 'r')
+	payloads = append(payloads, []byte("rrrrr"))
+
+	// feed chunk by chunk to make sure partial frames are never consumed.
+	var got [][]byte
+	for len(stream) > 0 {
+		n := 7
+		if n > len(stream) {
+			n = len(stream)
+		}
+		c.feed(stream[:n])
+		stream = stream[n:]
+		for {
+			frame, err := codec.Decode(c)
+			require.NoError(t, err)
+			if frame == nil {
+				break
+			}
+			got = append(got, frame)
+		}
+	}
+	require.Len(t, got, len(payloads))
+	for i := range payloads {
+		assert.True(t, bytes.Equal(payloads[i], got[i]), "frame %d mismatches", i)
+	}
+	assert.Zero(t, c.InboundBuffered())
+
+	c.feed([]byte{0x28, 0xB5, 0x2F, 0xFD, 0x20, 0x00, 0x07, 0x00, 0x00})
+	_, err := codec.Decode(c)
+	assert.ErrorIs(t, err, gerr.ErrMalformedFrame, "a reserved block type must be rejected")
+	_, err = NewZstdCodec(rawZstdCompressor{}).Decode(&mockConn{inbound: []byte("PAR1")})
+	assert.ErrorIs(t, err, gerr.ErrMalformedFrame)
+	_, err = NewZstdCodec(GzipCompressor{}).Encode(nil, []byte("gzip"))
+	assert.ErrorIs(t, err, gerr.ErrMalformedFrame, "a compressor must produce a zstd frame")
+	_, err = NewZstdCodec(nil).Encode(nil, []byte("zstd"))
+	assert.ErrorIs(t, err, gerr.ErrUnsupportedOp, "there is no default compressor")
+	_, err = NewZstdCodec(nil).Decode(&mockConn{})
+	assert.ErrorIs(t, err, gerr.ErrUnsupportedOp, "there is no default compressor")
+}
@@ -22,12 +22,14 @@ import (
 	"io"
 	"net"
 	"os"
+	"sync"
 	"time"
 
 	"golang.org/x/sys/unix"
 
 	gio "github.com/walkon/wsgnet/internal/io"
 	"github.com/walkon/wsgnet/internal/netpoll"
+	"github.com/walkon/wsgnet/internal/ratelimit"
 	"github.com/walkon/wsgnet/internal/socket"
 	"github.com/walkon/wsgnet/internal/toolkit"
 	"github.com/walkon/wsgnet/pkg/buffer/elastic"
@@ -36,19 +38,97 @@ import (
 )
 
 type conn struct {
-	ctx            interface{}             // user-defined context
-	peer           unix.Sockaddr           // remote socket address
-	localAddr      net.Addr                // local addr
-	remoteAddr     net.Addr                // remote addr
-	loop           *eventloop              // connected event-loop
-	outboundBuffer *elastic.Buffer         // buffer for data that is eligible to be sent to the peer
-	pollAttachment *netpoll.PollAttachment // connection attachment for poller
-	inboundBuffer  elastic.RingBuffer      // buffer for leftover data from the peer
-	buffer         []byte                  // buffer for the latest bytes
-	fd             int                     // file descriptor
-	isDatagram     bool                    // UDP protocol
-	opened         bool                    // connection opened event fired
-	isWebSock      bool                    // WebSocket protocol
+	ctx             interface{}                 // user-defined context
+	scratch         map[interface{}]interface{} // scratch state of codecs
+	protocolInfo    interface{}                 // parameters negotiated with the peer
+	labels          map[string]string           // user-defined labels
+	codec           ICodec                      // codec overriding Options.Codec
+	tee             io.Writer                   // receives a copy of the outbound data
+	peer            unix.Sockaddr               // remote socket address
+	localAddr       net.Addr                    // local addr
+	remoteAddr      net.Addr                    // remote addr
+	loop            *eventloop                  // connected event-loop
+	outboundBuffer  *elastic.Buffer             // buffer for data that is eligible to be sent to the peer
+	pollAttachment  *netpoll.PollAttachment     // connection attachment for poller
+	inboundBuffer   elastic.RingBuffer          // buffer for leftover data from the peer
+	buffer          []byte                      // buffer for the latest bytes
+	fd              int                         // file descriptor
+	isDatagram      bool                        // UDP protocol
+	opened          bool                        // connection opened event fired
+	isWebSock       bool                        // WebSocket protocol
+	closeWrite      bool                        // writing side is closed or about to be closed once outbound buffer is drained
+	gate            *outboundGate               // blocks the asynchronous writers under OutboundBlock
+	pacer           *ratelimit.Pacer            // paces the outbound data when WritePacingRate is set
+	pacing          bool                        // a paced write has been scheduled
+	retrying        bool                        // a retried write has been scheduled under WriteRetries
+	writeRetries    int                         // writes retried in a row after the transient errors
+	closeTimer      *time.Timer                 // closes the connection once the close handshake times out
+	coalescing      bool                        // writes are coalesced until the end of OnTraffic
+	arrivedAt       time.Time                   // when the latest bytes were read under ArrivalTimestamps
+	inboundCounted  int                         // inbound bytes counted in the stats of the event-loop
+	outboundCounted int                         // outbound bytes counted in the stats of the event-loop
+}
+
+// outboundGate keeps track of the pending outbound data of a connection for the asynchronous writers,
+// it blocks them while the pending data is beyond the cap.
+type outboundGate struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	capacity int
+	buffered int  // bytes in the outbound buffer as of the last update by the event-loop
+	queued   int  // bytes of the asynchronous writes that haven't been executed by the event-loop
+	closed   bool // connection has been closed
+}
+
+func newOutboundGate(capacity int) *outboundGate {
+	g := &outboundGate{capacity: capacity}
+	g.cond = sync.NewCond(&g.mu)
+	return g
+}
+
+// acquire blocks until there is room for n more bytes, then reserves it.
+// It returns false if the connection has been closed.
+func (g *outboundGate) acquire(n int) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for !g.closed {
+		if pending := g.buffered + g.queued; pending == 0 || pending+n <= g.capacity {
+			g.queued += n
+			return true
+		}
+		g.cond.Wait()
+	}
+	return false
+}
+
+// release gives back the room reserved by acquire after the write has been executed
+// and updates the number of buffered bytes.
+func (g *outboundGate) release(n, buffered int) {
+	g.mu.Lock()
+	g.queued -= n
+	g.update(buffered)
+	g.mu.Unlock()
+}
+
+// update must be called with g.mu held.
+func (g *outboundGate) update(buffered int) {
+	g.buffered = buffered
+	if g.buffered+g.queued < g.capacity {
+		g.cond.Broadcast()
+	}
+}
+
+func (g *outboundGate) drained(buffered int) {
+	g.mu.Lock()
+	g.update(buffered)
+	g.mu.Unlock()
+}
+
+func (g *outboundGate) close() {
+	g.mu.Lock()
+	g.closed = true
+	g.cond.Broadcast()
+	g.mu.Unlock()
 }
 
 func newTCPConn(fd int, el *eventloop, sa unix.Sockaddr, localAddr, remoteAddr net.Addr) (c *conn) {
@@ -61,6 +141,12 @@ func newTCPConn(fd int, el *eventloop, sa unix.Sockaddr, localAddr, remoteAddr n
 		isWebSock:  false,
 	}
 	c.outboundBuffer, _ = elastic.New(el.engine.opts.WriteBufferCap)
+	if opts := el.engine.opts; opts.OutboundBufferCap > 0 && opts.OutboundBufferPolicy == OutboundBlock {
+		c.gate = newOutboundGate(opts.OutboundBufferCap)
+	}
+	if opts := el.engine.opts; opts.WritePacingRate > 0 {
+		c.pacer = ratelimit.NewPacer(opts.WritePacingInitialRate, opts.WritePacingRate, opts.WritePacingRamp, time.Now())
+	}
 	c.pollAttachment = netpoll.GetPollAttachment()
 	c.pollAttachment.FD, c.pollAttachment.Callback = fd, c.handleEvents
 	return
@@ -70,7 +156,23 @@ func (c *conn) releaseTCP() {
 	c.opened = false
 	c.peer = nil
 	c.ctx = nil
+	c.scratch = nil
+	c.protocolInfo = nil
+	c.labels = nil
+	c.codec = nil
+	c.tee = nil
 	c.buffer = nil
+	if c.gate != nil {
+		c.gate.close()
+	}
+	c.pacing = false
+	c.retrying = false
+	c.writeRetries = 0
+	c.coalescing = false
+	if c.closeTimer != nil {
+		c.closeTimer.Stop()
+		c.closeTimer = nil
+	}
 	if addr, ok := c.localAddr.(*net.TCPAddr); ok && c.localAddr != c.loop.ln.addr {
 		bsPool.Put(addr.IP)
 		if len(addr.Zone) > 0 {
@@ -108,6 +210,11 @@ func newUDPConn(fd int, el *eventloop, localAddr net.Addr, sa unix.Sockaddr, con
 
 func (c *conn) releaseUDP() {
 	c.ctx = nil
+	c.scratch = nil
+	c.protocolInfo = nil
+	c.labels = nil
+	c.codec = nil
+	c.tee = nil
 	if addr, ok := c.localAddr.(*net.UDPAddr); ok && c.localAddr != c.loop.ln.addr {
 		bsPool.Put(addr.IP)
 		if len(addr.Zone) > 0 {
@@ -128,6 +235,12 @@ func (c *conn) releaseUDP() {
 }
 
 func (c *conn) open(buf []byte) error {
+	c.teeWrite(buf)
+	if c.pacer != nil {
+		_, _ = c.outboundBuffer.Write(buf)
+		return c.loop.write(c)
+	}
+
 	n, err := unix.Write(c.fd, buf)
 	if err != nil && err == unix.EAGAIN {
 		_, _ = c.outboundBuffer.Write(buf)
@@ -142,7 +255,27 @@ func (c *conn) open(buf []byte) error {
 }
 
 func (c *conn) write(data []byte) (n int, err error) {
+	if c.closeWrite {
+		return -1, gerrors.ErrWriteClosed
+	}
+
 	n = len(data)
+	if err = c.checkOutbound(n); err != nil {
+		return -1, err
+	}
+	c.teeWrite(data)
+	if c.coalescing {
+		_, _ = c.outboundBuffer.Write(data)
+		return
+	}
+	// The paced data is always sent through the outbound buffer by the event-loop.
+	if c.pacer != nil {
+		_, _ = c.outboundBuffer.Write(data)
+		if err = c.loop.write(c); err != nil {
+			return -1, err
+		}
+		return
+	}
 	// If there is pending data in outbound buffer, the current data ought to be appended to the outbound buffer
 	// for maintaining the sequence of network packets.
 	if !c.outboundBuffer.IsEmpty() {
@@ -151,15 +284,24 @@ func (c *conn) write(data []byte) (n int, err error) {
 	}
 
 	var sent int
-	if sent, err = unix.Write(c.fd, data); err != nil {
+	for sent, err = unix.Write(c.fd, data); err == unix.EINTR; {
+		sent, err = unix.Write(c.fd, data)
+	}
+	if err != nil {
 		// A temporary error occurs, append the data to outbound buffer, writing it back to the peer in the next round.
 		if err == unix.EAGAIN {
 			_, _ = c.outboundBuffer.Write(data)
 			err = c.loop.poller.ModReadWrite(c.pollAttachment)
 			return
 		}
+		if c.canRetryWrite(err) {
+			_, _ = c.outboundBuffer.Write(data)
+			err = c.loop.retryWrite(c)
+			return
+		}
 		return -1, c.loop.closeConn(c, os.NewSyscallError("write", err))
 	}
+	c.writeRetries = 0
 	// Failed to send all data back to the peer, buffer the leftover data for the next round.
 	if sent < n {
 		_, _ = c.outboundBuffer.Write(data[sent:])
@@ -169,9 +311,28 @@ func (c *conn) write(data []byte) (n int, err error) {
 }
 
 func (c *conn) writev(bs [][]byte) (n int, err error) {
+	if c.closeWrite {
+		return -1, gerrors.ErrWriteClosed
+	}
+
 	for _, b := range bs {
 		n += len(b)
 	}
+	if err = c.checkOutbound(n); err != nil {
+		return -1, err
+	}
+	c.teeWrite(bs...)
+	if c.coalescing {
+		_, _ = c.outboundBuffer.Writev(bs)
+		return
+	}
+	if c.pacer != nil {
+		_, _ = c.outboundBuffer.Writev(bs)
+		if err = c.loop.write(c); err != nil {
+			return -1, err
+		}
+		return
+	}
 
 	// If there is pending data in outbound buffer, the current data ought to be appended to the outbound buffer
 	// for maintaining the sequence of network packets.
@@ -181,15 +342,24 @@ func (c *conn) writev(bs [][]byte) (n int, err error) {
 	}
 
 	var sent int
-	if sent, err = gio.Writev(c.fd, bs); err != nil {
+	for sent, err = gio.Writev(c.fd, bs); err == unix.EINTR; {
+		sent, err = gio.Writev(c.fd, bs)
+	}
+	if err != nil {
 		// A temporary error occurs, append the data to outbound buffer, writing it back to the peer in the next round.
 		if err == unix.EAGAIN {
 			_, _ = c.outboundBuffer.Writev(bs)
 			err = c.loop.poller.ModReadWrite(c.pollAttachment)
 			return
 		}
+		if c.canRetryWrite(err) {
+			_, _ = c.outboundBuffer.Writev(bs)
+			err = c.loop.retryWrite(c)
+			return
+		}
 		return -1, c.loop.closeConn(c, os.NewSyscallError("write", err))
 	}
+	c.writeRetries = 0
 	// Failed to send all data back to the peer, buffer the leftover data for the next round.
 	if sent < n {
 		var pos int
@@ -208,6 +378,33 @@ func (c *conn) writev(bs [][]byte) (n int, err error) {
 	return
 }
 
+// canRetryWrite reports whether the write failing with err is to be retried under WriteRetries.
+func (c *conn) canRetryWrite(err error) bool {
+	return (err == unix.ENOBUFS || err == unix.ENOMEM) && c.writeRetries < c.loop.engine.opts.WriteRetries
+}
+
+// checkOutbound applies the OutboundBufferPolicy when writing n more bytes would take
+// the outbound buffer beyond the OutboundBufferCap.
+func (c *conn) checkOutbound(n int) error {
+	opts := c.loop.engine.opts
+	if opts.OutboundBufferCap <= 0 {
+		return nil
+	}
+	if pending := c.outboundBuffer.Buffered(); pending == 0 || pending+n <= opts.OutboundBufferCap {
+		return nil
+	}
+	switch opts.OutboundBufferPolicy {
+	case OutboundDrop:
+		return gerrors.ErrOutboundBufferFull
+	case OutboundGrow:
+		if err := c.loop.closeConn(c, gerrors.ErrOutboundBufferFull); err != nil {
+			return err
+		}
+		return gerrors.ErrOutboundBufferFull
+	}
+	return nil
+}
+
 type asyncWriteHook struct {
 	callback AsyncCallback
 	data     []byte
@@ -220,6 +417,10 @@ func (c *conn) asyncWrite(itf interface{}) (err error) {
 
 	hook := itf.(*asyncWriteHook)
 	_, err = c.write(hook.data)
+	c.loop.countBuffered(c)
+	if c.gate != nil {
+		c.gate.release(len(hook.data), c.outboundBuffer.Buffered())
+	}
 	if hook.callback != nil {
 		_ = hook.callback(c)
 	}
@@ -237,20 +438,50 @@ func (c *conn) asyncWritev(itf interface{}) (err error) {
 	}
 
 	hook := itf.(*asyncWritevHook)
+	n := buffersLength(hook.data)
 	_, err = c.writev(hook.data)
+	c.loop.countBuffered(c)
+	if c.gate != nil {
+		c.gate.release(n, c.outboundBuffer.Buffered())
+	}
 	if hook.callback != nil {
 		_ = hook.callback(c)
 	}
 	return
 }
 
+func buffersLength(bs [][]byte) (n int) {
+	for _, b := range bs {
+		n += len(b)
+	}
+	return
+}
+
+func (c *conn) shutdownWrite() error {
+	return os.NewSyscallError("shutdown", unix.Shutdown(c.fd, unix.SHUT_WR))
+}
+
 func (c *conn) sendTo(buf []byte) error {
+	c.teeWrite(buf)
 	if c.peer == nil {
 		return unix.Send(c.fd, buf, 0)
 	}
 	return unix.Sendto(c.fd, buf, 0, c.peer)
 }
 
+// teeWrite copies the outbound data to the tee writer if any, the errors of which don't affect the connection.
+func (c *conn) teeWrite(bs ...[]byte) {
+	if c.tee == nil {
+		return
+	}
+	for _, b := range bs {
+		if _, err := c.tee.Write(b); err != nil {
+			c.loop.getLogger().Warnf("failed to write the outbound data of %s to the tee writer: %v", describeConn(c), err)
+			return
+		}
+	}
+}
+
 func (c *conn) resetBuffer() {
 	c.buffer = c.buffer[:0]
 	c.inboundBuffer.Reset()
@@ -369,7 +600,27 @@ func (c *conn) Writev(bs [][]byte) (int, error) {
 	return c.writev(bs)
 }
 
+func (c *conn) WriteString(s string) (int, error) {
+	codec := c.Codec()
+	if codec == nil && c.tee == nil {
+		// The bytes of s are neither modified nor retained by Write alone, since the outbound buffer keeps
+		// a copy of the pending data, which doesn't hold for the codecs and the tee writer.
+		return c.Write(toolkit.StringToBytes(s))
+	}
+	buf := []byte(s)
+	if codec == nil {
+		return c.Write(buf)
+	}
+	if err := WriteFrame(c, codec, buf); err != nil {
+		return -1, err
+	}
+	return len(s), nil
+}
+
 func (c *conn) ReadFrom(r io.Reader) (int64, error) {
+	if c.tee != nil {
+		r = io.TeeReader(r, c.tee)
+	}
 	return c.outboundBuffer.ReadFrom(r)
 }
 
@@ -386,6 +637,51 @@ func (c *conn) WriteTo(w io.Writer) (n int64, err error) {
 	return
 }
 
+func (c *conn) SpliceFrameTo(dst Conn) (int, error) {
+	fp, ok := c.Codec().(FramePeeker)
+	if !ok {
+		return 0, gerrors.ErrUnsupportedOp
+	}
+	n, err := fp.PeekFrameLength(c)
+	if n == 0 {
+		if err == io.ErrShortBuffer {
+			err = nil
+		}
+		return 0, err
+	}
+
+	// The frame is made up of the head and the tail of the inbound buffer, then the latest bytes.
+	bs := make([][]byte, 0, 3)
+	head, tail := c.inboundBuffer.Peek(n)
+	for _, b := range [][]byte{head, tail} {
+		if len(b) > 0 {
+			bs = append(bs, b)
+		}
+	}
+	if rest := n - len(head) - len(tail); rest > 0 {
+		bs = append(bs, c.buffer[:rest])
+	}
+	if d, ok := dst.(*conn); ok && d.loop != c.loop {
+		frame := make([]byte, 0, n)
+		for _, b := range bs {
+			frame = append(frame, b...)
+		}
+		err = dst.AsyncWrite(frame, nil)
+	} else {
+		_, err = dst.Writev(bs)
+		if ok && d.opened {
+			d.loop.countBuffered(d)
+		}
+	}
+	if err != nil {
+		return 0, err
+	}
+	if err = fp.DiscardFrame(c, n); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
 func (c *conn) Flush() error {
 	if c.outboundBuffer.IsEmpty() {
 		return nil
@@ -416,8 +712,51 @@ func (c *conn) SetWriteDeadline(_ time.Time) error {
 
 func (c *conn) Context() interface{}       { return c.ctx }
 func (c *conn) SetContext(ctx interface{}) { c.ctx = ctx }
-func (c *conn) LocalAddr() net.Addr        { return c.localAddr }
-func (c *conn) RemoteAddr() net.Addr       { return c.remoteAddr }
+
+func (c *conn) ArrivedAt() time.Time             { return c.arrivedAt }
+func (c *conn) ProtocolInfo() interface{}        { return c.protocolInfo }
+func (c *conn) SetProtocolInfo(info interface{}) { c.protocolInfo = info }
+
+func (c *conn) CodecScratch(key interface{}) interface{} { return c.scratch[key] }
+
+func (c *conn) SetCodecScratch(key, value interface{}) {
+	if c.scratch == nil {
+		c.scratch = make(map[interface{}]interface{})
+	}
+	c.scratch[key] = value
+}
+func (c *conn) LocalAddr() net.Addr  { return c.localAddr }
+func (c *conn) RemoteAddr() net.Addr { return c.remoteAddr }
+
+func (c *conn) SetLabel(key, value string) {
+	if value == "" {
+		delete(c.labels, key)
+		return
+	}
+	if c.labels == nil {
+		c.labels = make(map[string]string)
+	}
+	c.labels[key] = value
+}
+
+func (c *conn) GetLabels() map[string]string {
+	return c.labels
+}
+
+func (c *conn) Codec() ICodec {
+	if c.codec != nil {
+		return c.codec
+	}
+	return c.loop.engine.opts.Codec
+}
+
+func (c *conn) SetCodec(codec ICodec) {
+	c.codec = codec
+}
+
+func (c *conn) SetTeeWriter(w io.Writer) {
+	c.tee = w
+}
 
 // Implementation of Socket interface
 
@@ -433,6 +772,21 @@ func (c *conn) SetKeepAlivePeriod(d time.Duration) error {
 	return socket.SetKeepAlivePeriod(c.fd, int(d.Seconds()))
 }
 
+func (c *conn) CloseWrite() error {
+	if c.isDatagram {
+		return gerrors.ErrUnsupportedOp
+	}
+	if c.closeWrite {
+		return nil
+	}
+	c.closeWrite = true
+	// The writing side will be shut down by the event-loop after the pending data has been sent.
+	if !c.outboundBuffer.IsEmpty() {
+		return nil
+	}
+	return c.shutdownWrite()
+}
+
 // ==================================== Concurrency-safe API's ====================================
 
 func (c *conn) AsyncWrite(buf []byte, callback AsyncCallback) error {
@@ -444,6 +798,9 @@ func (c *conn) AsyncWrite(buf []byte, callback AsyncCallback) error {
 		}()
 		return c.sendTo(buf)
 	}
+	if c.gate != nil && !c.gate.acquire(len(buf)) {
+		return net.ErrClosed
+	}
 	return c.loop.poller.Trigger(c.asyncWrite, &asyncWriteHook{callback, buf})
 }
 
@@ -451,6 +808,9 @@ func (c *conn) AsyncWritev(bs [][]byte, callback AsyncCallback) error {
 	if c.isDatagram {
 		return gerrors.ErrUnsupportedOp
 	}
+	if c.gate != nil && !c.gate.acquire(buffersLength(bs)) {
+		return net.ErrClosed
+	}
 	return c.loop.poller.Trigger(c.asyncWritev, &asyncWritevHook{callback, bs})
 }
 
@@ -474,6 +834,31 @@ func (c *conn) CloseWithCallback(callback AsyncCallback) error {
 	}, nil)
 }
 
+func (c *conn) GracefulClose(closeFrame []byte, timeout time.Duration) error {
+	if c.isDatagram {
+		return gerrors.ErrUnsupportedOp
+	}
+	return c.loop.poller.Trigger(func(_ interface{}) error {
+		if !c.opened || c.closeTimer != nil {
+			return nil
+		}
+		if _, err := c.write(closeFrame); err != nil {
+			return c.loop.closeConn(c, err)
+		}
+		c.loop.countBuffered(c)
+		c.closeTimer = time.AfterFunc(timeout, func() {
+			_ = c.loop.poller.Trigger(func(_ interface{}) error {
+				// The timer of the connection that has been closed since is stopped, but it may have fired already.
+				if !c.opened {
+					return nil
+				}
+				return c.loop.closeConn(c, gerrors.ErrCloseHandshakeTimeout)
+			}, nil)
+		})
+		return nil
+	}, nil)
+}
+
 func (c *conn) Close() error {
 	return c.loop.poller.Trigger(func(_ interface{}) (err error) {
 		err = c.loop.closeConn(c, nil)
@@ -25,21 +25,23 @@ import (
 	"sync/atomic"
 
 	"github.com/walkon/wsgnet/internal/netpoll"
+	"github.com/walkon/wsgnet/internal/ratelimit"
 	"github.com/walkon/wsgnet/pkg/errors"
 )
 
 type engine struct {
-	ln           *listener          // the listener for accepting new connections
-	lb           loadBalancer       // event-loops for handling events
-	wg           sync.WaitGroup     // event-loop close WaitGroup
-	opts         *Options           // options with engine
-	once         sync.Once          // make sure only signalShutdown once
-	cond         *sync.Cond         // shutdown signaler
-	mainLoop     *eventloop         // main event-loop for accepting connections
-	inShutdown   int32              // whether the engine is in shutdown
-	tickerCtx    context.Context    // context for ticker
-	cancelTicker context.CancelFunc // function to stop the ticker
-	eventHandler EventHandler       // user eventHandler
+	ln           *listener              // the listener for accepting new connections
+	lb           loadBalancer           // event-loops for handling events
+	wg           sync.WaitGroup         // event-loop close WaitGroup
+	opts         *Options               // options with engine
+	once         sync.Once              // make sure only signalShutdown once
+	cond         *sync.Cond             // shutdown signaler
+	mainLoop     *eventloop             // main event-loop for accepting connections
+	inShutdown   int32                  // whether the engine is in shutdown
+	tickerCtx    context.Context        // context for ticker
+	cancelTicker context.CancelFunc     // function to stop the ticker
+	eventHandler EventHandler           // user eventHandler
+	acceptLimit  *ratelimit.TokenBucket // rate limiter for accepting new connections
 }
 
 func (eng *engine) isInShutdown() bool {
@@ -260,6 +262,10 @@ func serve(eventHandler EventHandler, listener *listener, options *Options, prot
 		eng.lb = new(sourceAddrHashLoadBalancer)
 	}
 
+	if options.AcceptRateLimit > 0 {
+		eng.acceptLimit = ratelimit.NewTokenBucket(options.AcceptRateLimit, options.AcceptBurst)
+	}
+
 	eng.cond = sync.NewCond(&sync.Mutex{})
 	if eng.opts.Ticker {
 		eng.tickerCtx, eng.cancelTicker = context.WithCancel(context.Background())
@@ -37,6 +37,7 @@ import (
 )
 
 type eventloop struct {
+	stats        loopStats       // load of the event-loop, kept first for the alignment of its 64-bit words
 	ln           *listener       // listener
 	idx          int             // loop index in the engine loops list
 	cache        bytes.Buffer    // temporary buffer for scattered bytes
@@ -105,11 +106,13 @@ func (el *eventloop) open(c *conn) error {
 		}
 	}
 
-	if !c.outboundBuffer.IsEmpty() {
+	// The paced data has been scheduled by c.open.
+	if c.pacer == nil && !c.outboundBuffer.IsEmpty() {
 		if err := el.poller.AddWrite(c.pollAttachment); err != nil {
 			return err
 		}
 	}
+	el.countBuffered(c)
 
 	return el.handleAction(c, action)
 }
@@ -126,7 +129,12 @@ func (el *eventloop) read(c *conn) error {
 		return el.closeConn(c, os.NewSyscallError("read", err))
 	}
 
+	defer el.countBuffered(c)
+	if el.engine.opts.ArrivalTimestamps {
+		c.arrivedAt = time.Now()
+	}
 	c.buffer = el.buffer[:n]
+	c.coalescing = el.engine.opts.WriteCoalescing
 	action := el.eventHandler.OnTraffic(c)
 	switch action {
 	case None:
@@ -137,43 +145,155 @@ func (el *eventloop) read(c *conn) error {
 	}
 	_, _ = c.inboundBuffer.Write(c.buffer)
 
+	return el.flush(c)
+}
+
+// flush ends the coalescing of the writes made during EventHandler.OnTraffic and writes them at once.
+func (el *eventloop) flush(c *conn) error {
+	if !c.coalescing {
+		return nil
+	}
+	c.coalescing = false
+	if err := el.write(c); err != nil || !c.opened {
+		return err
+	}
+	// The leftover is written when the connection becomes writable, unless it's paced.
+	if c.pacer == nil && !c.outboundBuffer.IsEmpty() {
+		return el.poller.ModReadWrite(c.pollAttachment)
+	}
 	return nil
 }
 
 const iovMax = 1024
 
 func (el *eventloop) write(c *conn) error {
-	iov := c.outboundBuffer.Peek(-1)
+	if c.outboundBuffer.IsEmpty() {
+		return nil
+	}
+	defer el.countBuffered(c)
+	// The outbound data is held until the scheduled retry after a transient error.
+	if c.retrying {
+		return nil
+	}
+	allowance := -1
+	if c.pacer != nil {
+		// The paced data is held until the scheduled write.
+		if c.pacing {
+			return nil
+		}
+		if allowance = c.pacer.Allowance(time.Now()); allowance == 0 {
+			return el.pace(c)
+		}
+	}
+	iov := c.outboundBuffer.Peek(allowance)
+	if allowance > 0 {
+		iov = truncateBuffers(iov, allowance)
+	}
 	var (
 		n   int
 		err error
 	)
-	if len(iov) > 1 {
-		if len(iov) > iovMax {
-			iov = iov[:iovMax]
-		}
-		n, err = io.Writev(c.fd, iov)
-	} else {
-		n, err = unix.Write(c.fd, iov[0])
+	if len(iov) > iovMax {
+		iov = iov[:iovMax]
+	}
+	for n, err = el.writeIOV(c.fd, iov); err == unix.EINTR; {
+		n, err = el.writeIOV(c.fd, iov)
 	}
 	_, _ = c.outboundBuffer.Discard(n)
+	if c.gate != nil {
+		c.gate.drained(c.outboundBuffer.Buffered())
+	}
+	if c.pacer != nil && n > 0 {
+		c.pacer.Consume(n)
+	}
 	switch err {
 	case nil:
+		c.writeRetries = 0
 	case unix.EAGAIN:
+		if c.pacer != nil {
+			return el.poller.ModReadWrite(c.pollAttachment)
+		}
 		return nil
 	default:
+		if c.canRetryWrite(err) {
+			return el.retryWrite(c)
+		}
 		return el.closeConn(c, os.NewSyscallError("write", err))
 	}
 
+	// The leftover of the paced data is sent when the pacer allows more.
+	if c.pacer != nil && !c.outboundBuffer.IsEmpty() {
+		return el.pace(c)
+	}
+
 	// All data have been drained, it's no need to monitor the writable events,
 	// remove the writable event from poller to help the future event-loops.
 	if c.outboundBuffer.IsEmpty() {
 		_ = el.poller.ModRead(c.pollAttachment)
+		if c.closeWrite {
+			if err = c.shutdownWrite(); err != nil {
+				return el.closeConn(c, err)
+			}
+		}
 	}
 
 	return nil
 }
 
+// pace stops monitoring the writable events of the paced connection c and schedules
+// the next write when its pacer allows the pending data to be sent.
+func (el *eventloop) pace(c *conn) error {
+	c.pacing = true
+	time.AfterFunc(c.pacer.Delay(c.outboundBuffer.Buffered()), func() {
+		_ = el.poller.Trigger(func(_ interface{}) error {
+			if el.connections[c.fd] != c || !c.pacing {
+				return nil
+			}
+			c.pacing = false
+			return el.write(c)
+		}, nil)
+	})
+	return el.poller.ModRead(c.pollAttachment)
+}
+
+// writeIOV writes iov to fd with a single syscall.
+func (el *eventloop) writeIOV(fd int, iov [][]byte) (int, error) {
+	if len(iov) > 1 {
+		return io.Writev(fd, iov)
+	}
+	return unix.Write(fd, iov[0])
+}
+
+// retryWrite stops monitoring the writable events of c after a transient write error and schedules
+// the retry of its outbound data, backing off exponentially from WriteRetryBackoff.
+func (el *eventloop) retryWrite(c *conn) error {
+	backoff := el.engine.opts.WriteRetryBackoff << c.writeRetries
+	c.writeRetries++
+	c.retrying = true
+	time.AfterFunc(backoff, func() {
+		_ = el.poller.Trigger(func(_ interface{}) error {
+			if el.connections[c.fd] != c || !c.retrying {
+				return nil
+			}
+			c.retrying = false
+			return el.write(c)
+		}, nil)
+	})
+	return el.poller.ModRead(c.pollAttachment)
+}
+
+// truncateBuffers returns the leading n bytes of bs.
+func truncateBuffers(bs [][]byte, n int) [][]byte {
+	for i, b := range bs {
+		if len(b) >= n {
+			bs[i] = b[:n]
+			return bs[:i+1]
+		}
+		n -= len(b)
+	}
+	return bs
+}
+
 func (el *eventloop) closeConn(c *conn, err error) (rerr error) {
 	if addr := c.localAddr; addr != nil && strings.HasPrefix(c.localAddr.Network(), "udp") {
 		rerr = el.poller.Delete(c.fd)
@@ -223,6 +343,7 @@ func (el *eventloop) closeConn(c *conn, err error) (rerr error) {
 
 	delete(el.connections, c.fd)
 	el.addConn(-1)
+	el.uncountBuffered(c)
 	if el.eventHandler.OnClose(c, err) == Shutdown {
 		rerr = gerrors.ErrEngineShutdown
 	}
@@ -236,7 +357,12 @@ func (el *eventloop) wake(c *conn) error {
 		return nil // ignore stale wakes.
 	}
 
+	c.coalescing = el.engine.opts.WriteCoalescing
 	action := el.eventHandler.OnTraffic(c)
+	if err := el.flush(c); err != nil {
+		return err
+	}
+	el.countBuffered(c)
 
 	return el.handleAction(c, action)
 }
@@ -305,6 +431,9 @@ func (el *eventloop) readUDP(fd int, _ netpoll.IOEvent) error {
 	} else {
 		c = el.udpSockets[fd]
 	}
+	if el.engine.opts.ArrivalTimestamps {
+		c.arrivedAt = time.Now()
+	}
 	c.buffer = el.buffer[:n]
 	action := el.eventHandler.OnTraffic(c)
 	if c.peer != nil {
@@ -17,6 +17,7 @@ package gnet
 
 import (
 	"context"
+	"fmt"
 	"io"
 	"net"
 	"strings"
@@ -60,6 +61,18 @@ func (s Engine) CountConnections() (count int) {
 	return
 }
 
+// LoopStats returns the snapshots of the load of all event-loops, indexed by the index of the event-loop,
+// which tell whether some event-loops are hot while the others idle, to adjust NumEventLoop or LB for instance.
+func (s Engine) LoopStats() []LoopStat {
+	now := time.Now()
+	stats := make([]LoopStat, 0, s.eng.lb.len())
+	s.eng.lb.iterate(func(i int, el *eventloop) bool {
+		stats = append(stats, el.stat(now))
+		return true
+	})
+	return stats
+}
+
 // Dup returns a copy of the underlying file descriptor of listener.
 // It is the caller's responsibility to close dupFD when finished.
 // Closing listener does not affect dupFD, and closing dupFD does not affect listener.
@@ -122,6 +135,11 @@ type Writer interface {
 	// Writev writes multiple byte slices to peer synchronously, you must call it in the current goroutine.
 	Writev(bs [][]byte) (n int, err error)
 
+	// WriteString frames s with the codec of the connection by WriteFrame and writes the frame to peer synchronously,
+	// s is written as is if there is no codec, it returns len(s) on success, you must call it in the current goroutine.
+	// The codec must not modify or retain the buffer passed to it, since it shares the memory of s.
+	WriteString(s string) (n int, err error)
+
 	// Flush writes any buffered data to the underlying connection, you must call it in the current goroutine.
 	Flush() (err error)
 
@@ -139,6 +157,14 @@ type Writer interface {
 	AsyncWritev(bs [][]byte, callback AsyncCallback) (err error)
 }
 
+// describeConn describes c in the logs by its remote address and protocol info if any.
+func describeConn(c Conn) string {
+	if info := c.ProtocolInfo(); info != nil {
+		return fmt.Sprintf("%v (%v)", c.RemoteAddr(), info)
+	}
+	return fmt.Sprint(c.RemoteAddr())
+}
+
 // AsyncCallback is a callback which will be invoked after the asynchronous functions has finished executing.
 //
 // Note that the parameter gnet.Conn is already released under UDP protocol, thus it's not allowed to be accessed.
@@ -190,7 +216,14 @@ type Socket interface {
 	// The default is true (no delay), meaning that data is sent as soon as possible after a Write.
 	SetNoDelay(noDelay bool) error
 	// CloseRead() error
-	// CloseWrite() error
+
+	// CloseWrite shuts down the writing side of the connection (shutdown(SHUT_WR)) while keeping
+	// the reading side open, so that the peer gets an EOF but is still allowed to send data back.
+	// Data buffered in the outbound buffer will be sent to the peer before the writing side is shut down,
+	// any further writes on the connection will fail with errors.ErrWriteClosed.
+	//
+	// Note that CloseWrite is not concurrency-safe, you must call it in the current event-loop goroutine.
+	CloseWrite() error
 
 	GetNoDelay() (int, error)
 }
@@ -209,6 +242,60 @@ type Conn interface {
 	// SetContext sets a user-defined context.
 	SetContext(ctx interface{})
 
+	// ProtocolInfo returns the parameters negotiated with the peer set by SetProtocolInfo, nil if none.
+	ProtocolInfo() (info interface{})
+
+	// SetProtocolInfo sets the parameters negotiated with the peer during the handshake, like the version,
+	// the features and the compression, which are shared by the codec and the handlers of the connection.
+	// Unlike the context, it's reserved for the negotiated parameters and included in the logs of
+	// the connection, so its String method is used if it implements fmt.Stringer.
+	SetProtocolInfo(info interface{})
+
+	// ArrivedAt returns the time the latest bytes read from the connection arrived at, which carries the monotonic
+	// clock reading to measure the latency by time.Since, it's only recorded under Options.ArrivalTimestamps and
+	// zero otherwise, see DecodeWithArrival.
+	ArrivedAt() (t time.Time)
+
+	// CodecScratch returns the scratch state stored under key by a codec, which lets a codec shared between
+	// connections keep some state per connection, it's nil if nothing has been stored.
+	CodecScratch(key interface{}) (value interface{})
+
+	// SetCodecScratch stores the scratch state of a codec under key, which is discarded when the connection
+	// is closed. The key should be of an unexported type of the codec to avoid collisions like context.WithValue.
+	SetCodecScratch(key, value interface{})
+
+	// SetLabel attaches a key/value label to the connection, e.g. a tenant id parsed from the handshake,
+	// which is available in all the following events of the connection for routing and metrics.
+	// An empty value removes the label.
+	SetLabel(key, value string)
+
+	// GetLabels returns the labels attached to the connection,
+	// the returned map is owned by the connection and must not be modified.
+	GetLabels() (labels map[string]string)
+
+	// Codec returns the codec of the connection, which is the one set by SetCodec, or Options.Codec if none.
+	Codec() (codec ICodec)
+
+	// SetCodec sets the codec of the connection overriding Options.Codec, which is usually called
+	// in EventHandler.OnOpen to pick the framing for the peer, by its address for instance.
+	SetCodec(codec ICodec)
+
+	// SpliceFrameTo moves the next complete frame buffered in the connection, as it is on the wire including its
+	// header, to dst by dst.Writev right from the inbound buffer without decoding it into a new buffer, for
+	// the proxies forwarding the frames, and returns the number of bytes moved, or zero if the frame is incomplete.
+	// The frame is found by the codec of the connection, see Codec, which must implement FramePeeker, otherwise
+	// errors.ErrUnsupportedOp is returned. Since Writev is not concurrency-safe, a dst of another event-loop is
+	// given a copy of the frame by dst.AsyncWrite instead.
+	//
+	// Note that it's not concurrency-safe, you must call it in the current event-loop goroutine.
+	SpliceFrameTo(dst Conn) (n int, err error)
+
+	// SetTeeWriter sets w to receive a copy of all the data written to the connection, in the order it's written,
+	// which captures the exact wire traffic of the framed bytes, for golden-file tests for instance, nil unsets it.
+	// The errors of w are logged and don't affect the connection, w is called in the event-loop goroutine,
+	// so it must not block.
+	SetTeeWriter(w io.Writer)
+
 	// LocalAddr is the connection's local socket address.
 	LocalAddr() (addr net.Addr)
 
@@ -229,6 +316,14 @@ type Conn interface {
 	// Wake triggers a OnTraffic event for the connection.
 	Wake(callback AsyncCallback) (err error)
 
+	// GracefulClose starts the close handshake of the application protocol on the event-loop, like the one of
+	// WebSocket: it writes closeFrame and then waits for the peer to finish the handshake, i.e. to send its close
+	// frame, upon which EventHandler.OnTraffic is supposed to return Close, or to close the connection itself. If
+	// neither happens in timeout, the connection is closed with errors.ErrCloseHandshakeTimeout passed to
+	// EventHandler.OnClose. The frames ahead of the peer's close frame are still delivered to OnTraffic in the
+	// meantime, and GracefulClose is a no-op once the handshake has been started.
+	GracefulClose(closeFrame []byte, timeout time.Duration) (err error)
+
 	// CloseWithCallback closes the current connection, usually you don't need to pass a non-nil callback
 	// because you should use OnClose() instead, the callback here is only for compatibility.
 	CloseWithCallback(callback AsyncCallback) (err error)
@@ -281,6 +376,14 @@ type (
 		OnTick() (delay time.Duration, action Action)
 	}
 
+	// AcceptThrottleHandler is implemented optionally by the EventHandler which wants to know about the new
+	// connections rejected due to Options.AcceptRateLimit.
+	AcceptThrottleHandler interface {
+		// OnAcceptThrottled fires when a new connection is rejected due to Options.AcceptRateLimit,
+		// the connection has already been closed and the parameter addr is its remote address.
+		OnAcceptThrottled(addr net.Addr)
+	}
+
 	// BuiltinEventEngine is a built-in implementation of EventHandler which sets up each method with a default implementation,
 	// you can compose it with your own implementation of EventHandler when you don't want to implement all methods
 	// in EventHandler.
@@ -309,6 +412,10 @@ func (es *BuiltinEventEngine) OnBoot(_ Engine) (action Action) {
 func (es *BuiltinEventEngine) OnShutdown(_ Engine) {
 }
 
+// OnAcceptThrottled implements AcceptThrottleHandler, it does nothing.
+func (es *BuiltinEventEngine) OnAcceptThrottled(_ net.Addr) {
+}
+
 // OnOpen fires when a new connection has been opened.
 // The parameter out is the return value which is going to be sent back to the peer.
 func (es *BuiltinEventEngine) OnOpen(_ Conn) (out []byte, action Action) {
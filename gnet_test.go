@@ -32,6 +32,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"go.uber.org/zap"
+	"golang.org/x/sys/unix"
 
 	gerr "github.com/walkon/wsgnet/pkg/errors"
 	"github.com/walkon/wsgnet/pkg/logging"
@@ -866,6 +867,916 @@ func testCloseConnection(t *testing.T, network, addr string) {
 	assert.NoError(t, err)
 }
 
+func TestCloseWrite(t *testing.T) {
+	testCloseWrite(t, "tcp", ":9998")
+}
+
+type testCloseWriteServer struct {
+	*BuiltinEventEngine
+	tester        *testing.T
+	network, addr string
+	action        bool
+	received      []byte
+}
+
+func (t *testCloseWriteServer) OnClose(c Conn, err error) (action Action) {
+	assert.Equal(t.tester, "Hello World! Goodbye!", string(t.received))
+	action = Shutdown
+	return
+}
+
+func (t *testCloseWriteServer) OnTraffic(c Conn) (action Action) {
+	buf, _ := c.Next(-1)
+	first := len(t.received) == 0
+	t.received = append(t.received, buf...)
+	if first {
+		_, _ = c.Write(buf)
+		require.NoError(t.tester, c.CloseWrite())
+		_, err := c.Write(buf)
+		assert.ErrorIs(t.tester, err, gerr.ErrWriteClosed)
+	}
+	return
+}
+
+func (t *testCloseWriteServer) OnTick() (delay time.Duration, action Action) {
+	delay = time.Millisecond * 100
+	if !t.action {
+		t.action = true
+		go func() {
+			conn, err := net.Dial(t.network, t.addr)
+			require.NoError(t.tester, err)
+			defer conn.Close()
+			_, err = conn.Write([]byte("Hello World!"))
+			require.NoError(t.tester, err)
+			// the engine echoes the data back before it half-closes the connection.
+			data, err := io.ReadAll(conn)
+			require.NoError(t.tester, err)
+			require.Equal(t.tester, "Hello World!", string(data))
+			// the reading side of the engine is still open.
+			_, err = conn.Write([]byte(" Goodbye!"))
+			require.NoError(t.tester, err)
+			time.Sleep(100 * time.Millisecond)
+		}()
+	}
+	return
+}
+
+func testCloseWrite(t *testing.T, network, addr string) {
+	events := &testCloseWriteServer{tester: t, network: network, addr: addr}
+	err := Run(events, network+"://"+addr, WithTicker(true), WithReusePort(true))
+	assert.NoError(t, err)
+}
+
+func TestAcceptRateLimit(t *testing.T) {
+	testAcceptRateLimit(t, "tcp", ":9999")
+}
+
+type testAcceptRateLimitServer struct {
+	*BuiltinEventEngine
+	tester        *testing.T
+	network, addr string
+	action        bool
+	opened        int32
+	throttled     int32
+}
+
+var _ AcceptThrottleHandler = (*testAcceptRateLimitServer)(nil)
+
+func (t *testAcceptRateLimitServer) OnAcceptThrottled(addr net.Addr) {
+	require.NotNil(t.tester, addr)
+	atomic.AddInt32(&t.throttled, 1)
+}
+
+func (t *testAcceptRateLimitServer) OnOpen(c Conn) (out []byte, action Action) {
+	atomic.AddInt32(&t.opened, 1)
+	return
+}
+
+func (t *testAcceptRateLimitServer) OnTick() (delay time.Duration, action Action) {
+	delay = time.Millisecond * 100
+	if !t.action {
+		t.action = true
+		go func() {
+			for i := 0; i < 5; i++ {
+				conn, err := net.Dial(t.network, t.addr)
+				require.NoError(t.tester, err)
+				defer conn.Close()
+			}
+		}()
+		return
+	}
+	if atomic.LoadInt32(&t.opened)+atomic.LoadInt32(&t.throttled) == 5 {
+		action = Shutdown
+	}
+	return
+}
+
+func testAcceptRateLimit(t *testing.T, network, addr string) {
+	events := &testAcceptRateLimitServer{tester: t, network: network, addr: addr}
+	err := Run(events, network+"://"+addr, WithTicker(true), WithReusePort(true), WithAcceptRateLimit(1, 2))
+	assert.NoError(t, err)
+	assert.EqualValues(t, 2, events.opened)
+	assert.EqualValues(t, 3, events.throttled)
+}
+
+func TestOutboundBufferPolicy(t *testing.T) {
+	t.Run("drop", func(t *testing.T) {
+		testOutboundBufferPolicy(t, "tcp", ":9980", OutboundDrop)
+	})
+	t.Run("grow", func(t *testing.T) {
+		testOutboundBufferPolicy(t, "tcp", ":9981", OutboundGrow)
+	})
+	t.Run("block", func(t *testing.T) {
+		testOutboundBufferPolicy(t, "tcp", ":9982", OutboundBlock)
+	})
+}
+
+const (
+	outboundBufferCap   = 256 * 1024
+	outboundChunkLength = 64 * 1024
+	outboundChunks      = 64
+)
+
+type testOutboundBufferServer struct {
+	*BuiltinEventEngine
+	tester        *testing.T
+	network, addr string
+	policy        OutboundBufferPolicy
+	action        bool
+	writeErr      error
+	closeErr      error
+	maxPending    int
+	received      int64
+	closed        chan struct{}
+}
+
+func (t *testOutboundBufferServer) OnClose(c Conn, err error) (action Action) {
+	t.closeErr = err
+	close(t.closed)
+	return
+}
+
+func (t *testOutboundBufferServer) OnTraffic(c Conn) (action Action) {
+	_, _ = c.Discard(-1)
+	chunk := bytes.Repeat([]byte{'x'}, outboundChunkLength)
+	if t.policy == OutboundBlock {
+		go func() {
+			for i := 0; i < outboundChunks; i++ {
+				err := c.AsyncWrite(chunk, func(c Conn) error {
+					if c != nil && c.OutboundBuffered() > t.maxPending {
+						t.maxPending = c.OutboundBuffered()
+					}
+					return nil
+				})
+				require.NoError(t.tester, err)
+			}
+		}()
+		return
+	}
+	// the peer doesn't read anything, so the outbound buffer fills up.
+	for i := 0; i < outboundChunks; i++ {
+		if _, t.writeErr = c.Write(chunk); t.writeErr != nil {
+			break
+		}
+	}
+	if t.policy == OutboundDrop {
+		assert.LessOrEqual(t.tester, c.OutboundBuffered(), outboundBufferCap)
+		action = Close
+	}
+	return
+}
+
+func (t *testOutboundBufferServer) OnTick() (delay time.Duration, action Action) {
+	delay = time.Millisecond * 100
+	select {
+	case <-t.closed:
+		// the connection may be closed by a write, which can't shut the engine down from OnClose.
+		return delay, Shutdown
+	default:
+	}
+	if !t.action {
+		t.action = true
+		go func() {
+			conn, err := net.Dial(t.network, t.addr)
+			require.NoError(t.tester, err)
+			defer conn.Close()
+			_, err = conn.Write([]byte("flood me"))
+			require.NoError(t.tester, err)
+			if t.policy != OutboundBlock {
+				// don't read anything, just hold the connection open until the engine closes it.
+				<-t.closed
+				return
+			}
+			// read slowly to keep the asynchronous writers waiting for room.
+			buf := make([]byte, outboundChunkLength/4)
+			for atomic.LoadInt64(&t.received) < outboundChunks*outboundChunkLength {
+				n, err := conn.Read(buf)
+				require.NoError(t.tester, err)
+				atomic.AddInt64(&t.received, int64(n))
+			}
+		}()
+	}
+	return
+}
+
+func testOutboundBufferPolicy(t *testing.T, network, addr string, policy OutboundBufferPolicy) {
+	events := &testOutboundBufferServer{tester: t, network: network, addr: addr, policy: policy, closed: make(chan struct{})}
+	err := Run(events, network+"://"+addr, WithTicker(true), WithReusePort(true),
+		WithSocketSendBuffer(outboundChunkLength), WithOutboundBufferPolicy(outboundBufferCap, policy))
+	assert.NoError(t, err)
+	switch policy {
+	case OutboundDrop:
+		assert.ErrorIs(t, events.writeErr, gerr.ErrOutboundBufferFull)
+		assert.NoError(t, events.closeErr)
+	case OutboundGrow:
+		assert.ErrorIs(t, events.writeErr, gerr.ErrOutboundBufferFull)
+		assert.ErrorIs(t, events.closeErr, gerr.ErrOutboundBufferFull)
+	case OutboundBlock:
+		assert.EqualValues(t, outboundChunks*outboundChunkLength, atomic.LoadInt64(&events.received))
+		assert.LessOrEqual(t, events.maxPending, outboundBufferCap)
+	}
+}
+
+func TestConnCodec(t *testing.T) {
+	testConnCodec(t, "tcp", ":9983")
+}
+
+type testConnCodecServer struct {
+	*BuiltinEventEngine
+	tester        *testing.T
+	network, addr string
+	action        bool
+	opened        int32
+	echoed        int32
+	codecs        []ICodec
+}
+
+func (t *testConnCodecServer) OnOpen(c Conn) (out []byte, action Action) {
+	assert.Same(t.tester, t.codecs[0], c.Codec(), "the default codec should be used until another is set")
+	// every other connection speaks the little-endian framing.
+	if atomic.AddInt32(&t.opened, 1)%2 == 0 {
+		c.SetCodec(t.codecs[1])
+		c.SetProtocolInfo("little-endian")
+	}
+	return
+}
+
+func (t *testConnCodecServer) OnTraffic(c Conn) (action Action) {
+	for {
+		frame, _ := c.Codec().Decode(c)
+		if frame == nil {
+			return
+		}
+		if c.Codec() == t.codecs[1] {
+			assert.Equal(t.tester, "little-endian", c.ProtocolInfo())
+		} else {
+			assert.Nil(t.tester, c.ProtocolInfo())
+		}
+		n, err := c.WriteString(string(frame))
+		require.NoError(t.tester, err)
+		assert.Equal(t.tester, len(frame), n)
+	}
+}
+
+func (t *testConnCodecServer) OnTick() (delay time.Duration, action Action) {
+	delay = time.Millisecond * 100
+	if !t.action {
+		t.action = true
+		for i := range t.codecs {
+			conn, err := net.Dial(t.network, t.addr)
+			require.NoError(t.tester, err)
+			go func(conn net.Conn, codec ICodec) {
+				defer conn.Close()
+				out, err := codec.Encode(nil, []byte("framed per connection"))
+				require.NoError(t.tester, err)
+				_, err = conn.Write(out)
+				require.NoError(t.tester, err)
+				echo := make([]byte, len(out))
+				_, err = io.ReadFull(conn, echo)
+				require.NoError(t.tester, err)
+				assert.Equal(t.tester, out, echo)
+				atomic.AddInt32(&t.echoed, 1)
+			}(conn, t.codecs[i])
+			// make sure the connections are opened in order.
+			time.Sleep(50 * time.Millisecond)
+		}
+		return
+	}
+	if atomic.LoadInt32(&t.echoed) == int32(len(t.codecs)) {
+		action = Shutdown
+	}
+	return
+}
+
+func testConnCodec(t *testing.T, network, addr string) {
+	codecs := []ICodec{
+		NewLengthFieldBasedFrameCodec(
+			EncoderConfig{ByteOrder: binary.BigEndian, LengthFieldLength: 2},
+			DecoderConfig{ByteOrder: binary.BigEndian, LengthFieldLength: 2},
+		),
+		NewLengthFieldBasedFrameCodec(
+			EncoderConfig{ByteOrder: binary.LittleEndian, LengthFieldLength: 4},
+			DecoderConfig{ByteOrder: binary.LittleEndian, LengthFieldLength: 4},
+		),
+	}
+	events := &testConnCodecServer{tester: t, network: network, addr: addr, codecs: codecs}
+	err := Run(events, network+"://"+addr, WithTicker(true), WithReusePort(true), WithCodec(codecs[0]))
+	assert.NoError(t, err)
+	assert.EqualValues(t, 2, events.echoed)
+}
+
+func TestWritePacing(t *testing.T) {
+	testWritePacing(t, "tcp", ":9984")
+}
+
+const writePacingChunkLength = 64 * 1024
+
+type testWritePacingServer struct {
+	*BuiltinEventEngine
+	tester        *testing.T
+	network, addr string
+	action        bool
+	data          []byte
+	elapsed       int64
+	done          int32
+}
+
+func (t *testWritePacingServer) OnOpen(c Conn) (out []byte, action Action) {
+	out = t.data[:writePacingChunkLength]
+	return
+}
+
+func (t *testWritePacingServer) OnTraffic(c Conn) (action Action) {
+	_, _ = c.Discard(-1)
+	_, err := c.Write(t.data[writePacingChunkLength:])
+	assert.NoError(t.tester, err)
+	return
+}
+
+func (t *testWritePacingServer) OnTick() (delay time.Duration, action Action) {
+	delay = time.Millisecond * 100
+	if !t.action {
+		t.action = true
+		conn, err := net.Dial(t.network, t.addr)
+		require.NoError(t.tester, err)
+		go func() {
+			defer conn.Close()
+			start := time.Now()
+			_, err := conn.Write([]byte("push"))
+			require.NoError(t.tester, err)
+			received := make([]byte, len(t.data))
+			_, err = io.ReadFull(conn, received)
+			require.NoError(t.tester, err)
+			assert.Equal(t.tester, t.data, received)
+			atomic.StoreInt64(&t.elapsed, int64(time.Since(start)))
+			atomic.StoreInt32(&t.done, 1)
+		}()
+		return
+	}
+	if atomic.LoadInt32(&t.done) == 1 {
+		action = Shutdown
+	}
+	return
+}
+
+func testWritePacing(t *testing.T, network, addr string) {
+	events := &testWritePacingServer{tester: t, network: network, addr: addr, data: make([]byte, 2*writePacingChunkLength)}
+	_, _ = rand.Read(events.data)
+	// ramps up from 64KB/s to 256KB/s in 500ms, which takes about 700ms to send 128KB.
+	err := Run(events, network+"://"+addr, WithTicker(true), WithReusePort(true),
+		WithWritePacing(64*1024, 256*1024, 500*time.Millisecond))
+	assert.NoError(t, err)
+	assert.EqualValues(t, 1, events.done)
+	assert.GreaterOrEqual(t, time.Duration(events.elapsed), 400*time.Millisecond)
+}
+
+func TestConnTeeWriter(t *testing.T) {
+	testConnTeeWriter(t, "tcp", ":9985")
+}
+
+type testConnTeeWriterServer struct {
+	*BuiltinEventEngine
+	tester        *testing.T
+	network, addr string
+	action        bool
+	tee           bytes.Buffer
+	received      []byte
+	done          int32
+}
+
+func (t *testConnTeeWriterServer) OnOpen(c Conn) (out []byte, action Action) {
+	c.SetTeeWriter(&t.tee)
+	c.SetCodec(NewLengthFieldBasedFrameCodec(
+		EncoderConfig{ByteOrder: binary.BigEndian, LengthFieldLength: 2},
+		DecoderConfig{ByteOrder: binary.BigEndian, LengthFieldLength: 2},
+	))
+	out = []byte("welcome")
+	return
+}
+
+func (t *testConnTeeWriterServer) OnTraffic(c Conn) (action Action) {
+	_, _ = c.Discard(-1)
+	_, err := c.WriteString("framed")
+	require.NoError(t.tester, err)
+	_, err = c.Writev([][]byte{[]byte("raw"), []byte("bytes")})
+	require.NoError(t.tester, err)
+	return
+}
+
+func (t *testConnTeeWriterServer) OnTick() (delay time.Duration, action Action) {
+	delay = time.Millisecond * 100
+	if !t.action {
+		t.action = true
+		conn, err := net.Dial(t.network, t.addr)
+		require.NoError(t.tester, err)
+		go func() {
+			defer conn.Close()
+			_, err := conn.Write([]byte("go"))
+			require.NoError(t.tester, err)
+			received := make([]byte, len("welcome")+2+len("framed")+len("rawbytes"))
+			_, err = io.ReadFull(conn, received)
+			require.NoError(t.tester, err)
+			t.received = received
+			atomic.StoreInt32(&t.done, 1)
+		}()
+		return
+	}
+	if atomic.LoadInt32(&t.done) == 1 {
+		action = Shutdown
+	}
+	return
+}
+
+func testConnTeeWriter(t *testing.T, network, addr string) {
+	events := &testConnTeeWriterServer{tester: t, network: network, addr: addr}
+	err := Run(events, network+"://"+addr, WithTicker(true), WithReusePort(true))
+	assert.NoError(t, err)
+	assert.Equal(t, "welcome\x00\x06framedrawbytes", string(events.received))
+	assert.Equal(t, events.received, events.tee.Bytes())
+}
+
+func TestPriorityDispatcher(t *testing.T) {
+	testPriorityDispatcher(t, "tcp", ":9986")
+}
+
+type testPriorityDispatcherServer struct {
+	*BuiltinEventEngine
+	tester        *testing.T
+	network, addr string
+	action        bool
+	codec         *LengthFieldBasedFrameCodec
+	dispatcher    *PriorityDispatcher
+	dispatched    []string
+	done          int32
+}
+
+func (t *testPriorityDispatcherServer) OnTraffic(c Conn) (action Action) {
+	for {
+		frame, priority, _ := t.codec.DecodeWithPriority(c)
+		if frame == nil {
+			return
+		}
+		if action = t.dispatcher.Dispatch(c, frame, priority); action != None {
+			return
+		}
+	}
+}
+
+func (t *testPriorityDispatcherServer) OnTick() (delay time.Duration, action Action) {
+	delay = time.Millisecond * 100
+	if !t.action {
+		t.action = true
+		conn, err := net.Dial(t.network, t.addr)
+		require.NoError(t.tester, err)
+		go func() {
+			defer conn.Close()
+			var out []byte
+			for _, frame := range []string{"\x01low", "\x09high", "\x05mid", "\x09urgent"} {
+				out = append(out, 0x00, byte(len(frame)))
+				out = append(out, frame...)
+			}
+			_, err := conn.Write(out)
+			require.NoError(t.tester, err)
+			_, err = io.ReadFull(conn, make([]byte, 4))
+			require.NoError(t.tester, err)
+			atomic.StoreInt32(&t.done, 1)
+		}()
+		return
+	}
+	if atomic.LoadInt32(&t.done) == 1 {
+		action = Shutdown
+	}
+	return
+}
+
+func testPriorityDispatcher(t *testing.T, network, addr string) {
+	events := &testPriorityDispatcherServer{tester: t, network: network, addr: addr}
+	events.codec = NewLengthFieldBasedFrameCodec(EncoderConfig{}, DecoderConfig{
+		ByteOrder:         binary.BigEndian,
+		LengthFieldLength: 2,
+		PriorityField:     HeaderField{Offset: 2, Length: 1},
+	})
+	// dispatches one frame after each batch of network events.
+	events.dispatcher = NewPriorityDispatcher(func(c Conn, frame []byte) Action {
+		events.dispatched = append(events.dispatched, string(frame[1:]))
+		if len(events.dispatched) == 4 {
+			_, _ = c.Write([]byte("done"))
+		}
+		return None
+	}, 1)
+	err := Run(events, network+"://"+addr, WithTicker(true), WithReusePort(true))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"high", "urgent", "mid", "low"}, events.dispatched)
+}
+
+func TestLoopStats(t *testing.T) {
+	testLoopStats(t, "tcp", ":7207")
+}
+
+type testLoopStatsServer struct {
+	*BuiltinEventEngine
+	tester        *testing.T
+	eng           Engine
+	network, addr string
+	action        bool
+	codec         ICodec
+	stats         []LoopStat
+	conns         []net.Conn
+	done          int32
+}
+
+func (t *testLoopStatsServer) OnBoot(eng Engine) (action Action) {
+	t.eng = eng
+	return
+}
+
+func (t *testLoopStatsServer) OnTraffic(c Conn) (action Action) {
+	frames, err := DecodeBatch(c, t.codec, 0)
+	require.NoError(t.tester, err)
+	// the connections are served by different event-loops, so the count is kept per connection.
+	decoded, _ := c.Context().(int)
+	if decoded += len(frames); decoded == 3 {
+		_, _ = c.Write([]byte("ok"))
+	}
+	c.SetContext(decoded)
+	return
+}
+
+func (t *testLoopStatsServer) OnTick() (delay time.Duration, action Action) {
+	delay = time.Millisecond * 100
+	if !t.action {
+		t.action = true
+		var acked int32
+		for _, partial := range []int{0, 3} {
+			conn, err := net.Dial(t.network, t.addr)
+			require.NoError(t.tester, err)
+			t.conns = append(t.conns, conn)
+			go func(partial int) {
+				var out []byte
+				for _, frame := range []string{"a", "bb", "ccc"} {
+					out = append(out, 0x00, byte(len(frame)))
+					out = append(out, frame...)
+				}
+				// leave the bytes of an incomplete frame buffered.
+				out = append(out, []byte{0x00, 0x10, 'x'}[:partial]...)
+				_, err := conn.Write(out)
+				require.NoError(t.tester, err)
+				_, err = io.ReadFull(conn, make([]byte, 2))
+				require.NoError(t.tester, err)
+				if atomic.AddInt32(&acked, 1) == 2 {
+					atomic.StoreInt32(&t.done, 1)
+				}
+			}(partial)
+		}
+		return
+	}
+	if atomic.LoadInt32(&t.done) == 1 {
+		t.stats = t.eng.LoopStats()
+		action = Shutdown
+	}
+	return
+}
+
+func testLoopStats(t *testing.T, network, addr string) {
+	events := &testLoopStatsServer{tester: t, network: network, addr: addr}
+	events.codec = NewLengthFieldBasedFrameCodec(EncoderConfig{}, DecoderConfig{
+		ByteOrder:         binary.BigEndian,
+		LengthFieldLength: 2,
+	})
+	err := Run(events, network+"://"+addr, WithNumEventLoop(2), WithLoadBalancing(RoundRobin),
+		WithTicker(true), WithReusePort(true))
+	assert.NoError(t, err)
+	for _, conn := range events.conns {
+		_ = conn.Close()
+	}
+
+	// the connections are spread across the event-loops by the kernel under ReusePort, so only the totals are checked.
+	require.Len(t, events.stats, 2)
+	var frames uint64
+	var connections, inbound int
+	for i, stat := range events.stats {
+		assert.Equal(t, i, stat.Index)
+		assert.Zero(t, stat.OutboundBuffered)
+		connections += stat.Connections
+		frames += stat.Frames
+		inbound += stat.InboundBuffered
+	}
+	assert.Equal(t, 2, connections)
+	assert.EqualValues(t, 6, frames)
+	assert.Equal(t, 3, inbound)
+}
+
+func TestGracefulClose(t *testing.T) {
+	testGracefulClose(t, "tcp", ":7208")
+}
+
+type testGracefulCloseServer struct {
+	*BuiltinEventEngine
+	tester        *testing.T
+	network, addr string
+	action        bool
+	closeErrs     chan error
+	done          int32
+}
+
+func (t *testGracefulCloseServer) OnTraffic(c Conn) (action Action) {
+	buf, _ := c.Next(-1)
+	switch string(buf) {
+	case "bye":
+		require.NoError(t.tester, c.GracefulClose([]byte("CLOSE"), 200*time.Millisecond))
+		// started only once.
+		require.NoError(t.tester, c.GracefulClose([]byte("CLOSE"), time.Hour))
+	case "CLOSE":
+		action = Close
+	}
+	return
+}
+
+func (t *testGracefulCloseServer) OnClose(_ Conn, err error) (action Action) {
+	t.closeErrs <- err
+	return
+}
+
+func (t *testGracefulCloseServer) OnTick() (delay time.Duration, action Action) {
+	delay = time.Millisecond * 100
+	if !t.action {
+		t.action = true
+		var finished int32
+		for _, ack := range []bool{true, false} {
+			conn, err := net.Dial(t.network, t.addr)
+			require.NoError(t.tester, err)
+			go func(ack bool) {
+				defer conn.Close()
+				_, err := conn.Write([]byte("bye"))
+				require.NoError(t.tester, err)
+				buf := make([]byte, 5)
+				_, err = io.ReadFull(conn, buf)
+				require.NoError(t.tester, err)
+				assert.Equal(t.tester, "CLOSE", string(buf))
+				if ack {
+					_, err = conn.Write(buf)
+					require.NoError(t.tester, err)
+				}
+				// the server closes the connection either way.
+				_, err = conn.Read(buf)
+				assert.ErrorIs(t.tester, err, io.EOF)
+				if atomic.AddInt32(&finished, 1) == 2 {
+					atomic.StoreInt32(&t.done, 1)
+				}
+			}(ack)
+			// the first client acknowledges before the second one dials.
+			time.Sleep(50 * time.Millisecond)
+		}
+		return
+	}
+	if atomic.LoadInt32(&t.done) == 1 {
+		action = Shutdown
+	}
+	return
+}
+
+func testGracefulClose(t *testing.T, network, addr string) {
+	events := &testGracefulCloseServer{tester: t, network: network, addr: addr, closeErrs: make(chan error, 2)}
+	err := Run(events, network+"://"+addr, WithTicker(true), WithReusePort(true))
+	assert.NoError(t, err)
+	require.Len(t, events.closeErrs, 2)
+	assert.NoError(t, <-events.closeErrs, "the acknowledged handshake closes the connection normally")
+	assert.ErrorIs(t, <-events.closeErrs, gerr.ErrCloseHandshakeTimeout)
+}
+
+func TestWriteCoalescing(t *testing.T) {
+	testWriteCoalescing(t, "tcp", ":7209")
+}
+
+type testWriteCoalescingServer struct {
+	*BuiltinEventEngine
+	tester        *testing.T
+	network, addr string
+	action        bool
+	codec         ICodec
+	buffered      []int
+	done          int32
+}
+
+func (t *testWriteCoalescingServer) OnTraffic(c Conn) (action Action) {
+	frame, _ := t.codec.Decode(c)
+	if frame == nil {
+		return
+	}
+	// several frames in response to one request.
+	for _, resp := range []string{"one", "two", "three"} {
+		require.NoError(t.tester, WriteFrame(c, t.codec, []byte(resp)))
+		t.buffered = append(t.buffered, c.OutboundBuffered())
+	}
+	if string(frame) == "flush" {
+		require.NoError(t.tester, c.Flush())
+		t.buffered = append(t.buffered, c.OutboundBuffered())
+	}
+	return
+}
+
+func (t *testWriteCoalescingServer) OnTick() (delay time.Duration, action Action) {
+	delay = time.Millisecond * 100
+	if !t.action {
+		t.action = true
+		conn, err := net.Dial(t.network, t.addr)
+		require.NoError(t.tester, err)
+		go func() {
+			defer conn.Close()
+			for _, req := range []string{"coalesce", "flush"} {
+				out, err := t.codec.Encode(nil, []byte(req))
+				require.NoError(t.tester, err)
+				_, err = conn.Write(out)
+				require.NoError(t.tester, err)
+				buf := make([]byte, 3*2+len("onetwothree"))
+				_, err = io.ReadFull(conn, buf)
+				require.NoError(t.tester, err)
+				assert.Equal(t.tester, "\x00\x03one\x00\x03two\x00\x05three", string(buf))
+			}
+			atomic.StoreInt32(&t.done, 1)
+		}()
+		return
+	}
+	if atomic.LoadInt32(&t.done) == 1 {
+		action = Shutdown
+	}
+	return
+}
+
+func testWriteCoalescing(t *testing.T, network, addr string) {
+	events := &testWriteCoalescingServer{tester: t, network: network, addr: addr}
+	events.codec = NewLengthFieldBasedFrameCodec(
+		EncoderConfig{ByteOrder: binary.BigEndian, LengthFieldLength: 2},
+		DecoderConfig{ByteOrder: binary.BigEndian, LengthFieldLength: 2},
+	)
+	err := Run(events, network+"://"+addr, WithWriteCoalescing(true), WithTicker(true), WithReusePort(true))
+	assert.NoError(t, err)
+	// the frames are held until OnTraffic returns, unless flushed explicitly.
+	assert.Equal(t, []int{5, 10, 17, 5, 10, 17, 0}, events.buffered)
+}
+
+func TestSpliceFrameTo(t *testing.T) {
+	testSpliceFrameTo(t, "tcp", ":7210")
+}
+
+type testSpliceFrameToServer struct {
+	*BuiltinEventEngine
+	tester        *testing.T
+	network, addr string
+	action        bool
+	codec         ICodec
+	spliced       int
+	done          int32
+}
+
+func (t *testSpliceFrameToServer) OnTraffic(c Conn) (action Action) {
+	// the frames are echoed back as they are on the wire.
+	for {
+		n, err := c.SpliceFrameTo(c)
+		require.NoError(t.tester, err)
+		if n == 0 {
+			return
+		}
+		t.spliced++
+	}
+}
+
+func (t *testSpliceFrameToServer) OnTick() (delay time.Duration, action Action) {
+	delay = time.Millisecond * 100
+	if !t.action {
+		t.action = true
+		conn, err := net.Dial(t.network, t.addr)
+		require.NoError(t.tester, err)
+		go func() {
+			defer conn.Close()
+			var wire []byte
+			for _, payload := range []string{"first", "second", "third"} {
+				out, err := t.codec.Encode(nil, []byte(payload))
+				require.NoError(t.tester, err)
+				wire = append(wire, out...)
+			}
+			// the frames arrive in pieces, so that some of them straddle the inbound buffer and the latest bytes.
+			for i := 0; i < len(wire); i += 4 {
+				end := i + 4
+				if end > len(wire) {
+					end = len(wire)
+				}
+				_, err := conn.Write(wire[i:end])
+				require.NoError(t.tester, err)
+				time.Sleep(time.Millisecond * 10)
+			}
+			buf := make([]byte, len(wire))
+			_, err := io.ReadFull(conn, buf)
+			require.NoError(t.tester, err)
+			assert.Equal(t.tester, wire, buf)
+			atomic.StoreInt32(&t.done, 1)
+		}()
+		return
+	}
+	if atomic.LoadInt32(&t.done) == 1 {
+		action = Shutdown
+	}
+	return
+}
+
+func testSpliceFrameTo(t *testing.T, network, addr string) {
+	events := &testSpliceFrameToServer{tester: t, network: network, addr: addr}
+	events.codec = NewLengthFieldBasedFrameCodec(
+		EncoderConfig{ByteOrder: binary.BigEndian, LengthFieldLength: 2},
+		DecoderConfig{ByteOrder: binary.BigEndian, LengthFieldLength: 2},
+	)
+	err := Run(events, network+"://"+addr, WithCodec(events.codec), WithTicker(true), WithReusePort(true))
+	assert.NoError(t, err)
+	assert.Equal(t, 3, events.spliced)
+}
+
+func TestWriteRetry(t *testing.T) {
+	testWriteRetry(t, "tcp", ":7211")
+}
+
+type testWriteRetryServer struct {
+	*BuiltinEventEngine
+	tester        *testing.T
+	network, addr string
+	action        bool
+	held          []int
+	done          int32
+}
+
+func (t *testWriteRetryServer) OnTraffic(c Conn) (action Action) {
+	buf, _ := c.Next(-1)
+	cc := c.(*conn)
+	// simulate a transient write error, after which the writes are held until the retry.
+	_, _ = cc.outboundBuffer.Write(buf[:1])
+	require.True(t.tester, cc.canRetryWrite(unix.ENOBUFS))
+	require.NoError(t.tester, cc.loop.retryWrite(cc))
+	_, err := c.Write(buf[1:])
+	require.NoError(t.tester, err)
+	t.held = append(t.held, c.OutboundBuffered())
+	assert.False(t.tester, cc.canRetryWrite(unix.ENOBUFS), "the retries are exhausted")
+	assert.False(t.tester, cc.canRetryWrite(unix.EPIPE), "only the transient errors are retried")
+	return
+}
+
+func (t *testWriteRetryServer) OnTick() (delay time.Duration, action Action) {
+	delay = time.Millisecond * 100
+	if !t.action {
+		t.action = true
+		conn, err := net.Dial(t.network, t.addr)
+		require.NoError(t.tester, err)
+		go func() {
+			defer conn.Close()
+			_, err := conn.Write([]byte("retry"))
+			require.NoError(t.tester, err)
+			buf := make([]byte, len("retry"))
+			_, err = io.ReadFull(conn, buf)
+			require.NoError(t.tester, err)
+			assert.Equal(t.tester, "retry", string(buf))
+			atomic.StoreInt32(&t.done, 1)
+		}()
+		return
+	}
+	if atomic.LoadInt32(&t.done) == 1 {
+		action = Shutdown
+	}
+	return
+}
+
+func testWriteRetry(t *testing.T, network, addr string) {
+	events := &testWriteRetryServer{tester: t, network: network, addr: addr}
+	err := Run(events, network+"://"+addr,
+		WithWriteRetry(1, 10*time.Millisecond), WithTicker(true), WithReusePort(true))
+	assert.NoError(t, err)
+	assert.Equal(t, []int{5}, events.held)
+}
+
+func TestDescribeConn(t *testing.T) {
+	c := &mockConn{}
+	assert.Equal(t, "127.0.0.1:9000", describeConn(c))
+	c.info = struct{ Version int }{2}
+	assert.Equal(t, "127.0.0.1:9000 ({2})", describeConn(c))
+}
+
 func TestServerOptionsCheck(t *testing.T) {
 	err := Run(&BuiltinEventEngine{}, "tcp://:3500", WithNumEventLoop(10001), WithLockOSThread(true))
 	assert.EqualError(t, err, gerr.ErrTooManyEventLoopThreads.Error(), "error returned with LockOSThread option")
@@ -0,0 +1,193 @@
+// Copyright (c) 2019 Andy Pan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gnet
+
+import (
+	"encoding/binary"
+	"io"
+
+	"github.com/walkon/wsgnet/pkg/errors"
+	"github.com/walkon/wsgnet/pkg/logging"
+)
+
+// FrameHandler handles a decoded frame, the returned Action is applied to the connection.
+//
+// Note that the frame is owned by the handler only within the call, the same rule with Conn.Peek() applies
+// when the codec doesn't copy the decoded frames.
+type FrameHandler func(c Conn, frame []byte) (action Action)
+
+// HandlerMux decodes frames from a connection with a codec and dispatches each frame to the FrameHandler
+// registered for the type field carried in the frame, it is meant to be called from EventHandler.OnTraffic.
+type HandlerMux struct {
+	codec      ICodec
+	byteOrder  binary.ByteOrder
+	typeOffset int
+	typeLength int
+	handlers   map[uint32]FrameHandler
+
+	// NotFound handles the frames whose type has no handler registered,
+	// the connection is closed when it is nil.
+	NotFound FrameHandler
+
+	// OnUnrecognized is an optional function called with the buffered bytes peeked from c when the codec fails to
+	// frame them, e.g. a misdirected client speaking another protocol, to respond with an error or a redirect
+	// rather than closing the connection, which is what happens when it is nil. The returned Action is applied
+	// and the bytes are left buffered, so it's up to OnUnrecognized to discard the bytes it has handled, and
+	// peeked is only valid within the call, the same rule with Conn.Peek() applies.
+	OnUnrecognized func(c Conn, peeked []byte) (action Action)
+
+	// RecycleFrames indicates whether to decode frames into pooled buffers when the codec implements
+	// PooledDecoder, each frame is recycled right after its handler returns, so handlers must not
+	// retain the frame or any sub-slice of it beyond the call.
+	RecycleFrames bool
+
+	// ShouldShed is an optional function consulted before dispatching each decoded frame, returning true
+	// rejects the frame without dispatching it, writes ShedResponse if any and closes the connection,
+	// which is meant to shed load when the server is overloaded.
+	ShouldShed func(c Conn) bool
+
+	// ShedResponse is the frame encoded by the codec and written to the connection shed by ShouldShed,
+	// a "server busy" message for instance, nothing is written when it's nil.
+	ShedResponse []byte
+
+	// MaxFramesPerRead is the maximum number of frames dispatched by a call of Serve, zero means no limit.
+	// The remaining frames stay buffered and the connection is woken by Conn.Wake to be served again,
+	// so that a connection with a huge buffer doesn't hog the event-loop, see also DecodeBatch.
+	MaxFramesPerRead int
+}
+
+// NewHandlerMux instantiates and returns a HandlerMux that decodes frames with codec and reads the frame type
+// from the typeLength (1 to 4) bytes at typeOffset of each decoded frame with byteOrder.
+// A nil codec makes the HandlerMux decode frames with the codec of each connection, see Conn.Codec, and the
+// connections without a codec, set by neither Conn.SetCodec nor Options.Codec, are closed by Serve.
+func NewHandlerMux(codec ICodec, typeOffset, typeLength int, byteOrder binary.ByteOrder) *HandlerMux {
+	return &HandlerMux{
+		codec:      codec,
+		byteOrder:  byteOrder,
+		typeOffset: typeOffset,
+		typeLength: typeLength,
+		handlers:   make(map[uint32]FrameHandler),
+	}
+}
+
+// Handle registers the handler for the given frame type, replacing the former one if any.
+func (mux *HandlerMux) Handle(typ uint32, handler FrameHandler) {
+	mux.handlers[typ] = handler
+}
+
+// Serve decodes all complete frames buffered in c, up to MaxFramesPerRead, and dispatches them to the registered
+// handlers, it stops at the first frame whose handler returns an Action other than None and returns that Action.
+func (mux *HandlerMux) Serve(c Conn) (action Action) {
+	codec := mux.codec
+	if codec == nil {
+		if codec = c.Codec(); codec == nil {
+			logging.Errorf("no codec to decode frames from %v, neither the HandlerMux nor the connection has one",
+				describeConn(c))
+			return Close
+		}
+	}
+	pd, pooled := codec.(PooledDecoder)
+	pooled = pooled && mux.RecycleFrames
+	for served := 0; ; served++ {
+		if mux.MaxFramesPerRead > 0 && served == mux.MaxFramesPerRead {
+			if c.InboundBuffered() > 0 {
+				if err := c.Wake(nil); err != nil {
+					logging.Errorf("failed to wake %v for the remaining frames: %v", describeConn(c), err)
+					return Close
+				}
+			}
+			return None
+		}
+		var (
+			frame []byte
+			done  func()
+			err   error
+		)
+		if pooled {
+			frame, done, err = pd.DecodePooled(c)
+		} else {
+			frame, err = codec.Decode(c)
+		}
+		if err == io.ErrShortBuffer || (err == nil && frame == nil) {
+			return None
+		}
+		if err != nil {
+			if mux.OnUnrecognized != nil {
+				peeked, _ := c.Peek(c.InboundBuffered())
+				return mux.OnUnrecognized(c, peeked)
+			}
+			logging.Errorf("failed to decode frame from %v: %v", describeConn(c), err)
+			return Close
+		}
+		countFrames(c, 1)
+		if mux.ShouldShed != nil && mux.ShouldShed(c) {
+			if done != nil {
+				done()
+			}
+			return mux.shed(c, codec)
+		}
+		action = mux.dispatch(c, frame)
+		if done != nil {
+			done()
+		}
+		if action != None {
+			return
+		}
+	}
+}
+
+func (mux *HandlerMux) shed(c Conn, codec ICodec) Action {
+	if mux.ShedResponse != nil {
+		if err := WriteFrame(c, codec, mux.ShedResponse); err != nil {
+			logging.Errorf("failed to write shed response to %v: %v", describeConn(c), err)
+		}
+	}
+	return Close
+}
+
+func (mux *HandlerMux) dispatch(c Conn, frame []byte) Action {
+	typ, err := mux.frameType(frame)
+	if err != nil {
+		logging.Errorf("failed to read frame type from %v: %v", describeConn(c), err)
+		return Close
+	}
+	if handler, ok := mux.handlers[typ]; ok {
+		return handler(c, frame)
+	}
+	if mux.NotFound != nil {
+		return mux.NotFound(c, frame)
+	}
+	logging.Errorf("no handler registered for frame type %d from %v", typ, describeConn(c))
+	return Close
+}
+
+func (mux *HandlerMux) frameType(frame []byte) (uint32, error) {
+	end := mux.typeOffset + mux.typeLength
+	if end > len(frame) {
+		return 0, errors.ErrShortFrame
+	}
+	b := frame[mux.typeOffset:end]
+	switch mux.typeLength {
+	case 1:
+		return uint32(b[0]), nil
+	case 2:
+		return uint32(mux.byteOrder.Uint16(b)), nil
+	case 3:
+		return uint32(readUint24(mux.byteOrder, b)), nil
+	case 4:
+		return mux.byteOrder.Uint32(b), nil
+	}
+	return 0, errors.ErrUnsupportedLength
+}
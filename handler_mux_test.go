@@ -0,0 +1,221 @@
+// Copyright (c) 2019 Andy Pan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gnet
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	gerr "github.com/walkon/wsgnet/pkg/errors"
+)
+
+func TestHandlerMux(t *testing.T) {
+	codec := NewLengthFieldBasedFrameCodec(
+		EncoderConfig{ByteOrder: binary.BigEndian, LengthFieldLength: 2},
+		DecoderConfig{ByteOrder: binary.BigEndian, LengthFieldLength: 2},
+	)
+	mux := NewHandlerMux(codec, 0, 1, binary.BigEndian)
+
+	var pings, pongs [][]byte
+	mux.Handle(1, func(c Conn, frame []byte) Action {
+		pings = append(pings, frame)
+		return None
+	})
+	mux.Handle(2, func(c Conn, frame []byte) Action {
+		pongs = append(pongs, frame)
+		return None
+	})
+
+	c := &mockConn{}
+	for _, payload := range [][]byte{{1, 'a'}, {2, 'b'}, {1, 'c'}} {
+		out, err := codec.Encode(c, payload)
+		require.NoError(t, err)
+		c.feed(out)
+	}
+	c.feed([]byte{0x00}) // incomplete frame stays buffered
+	assert.Equal(t, None, mux.Serve(c))
+	assert.Equal(t, [][]byte{{1, 'a'}, {1, 'c'}}, pings)
+	assert.Equal(t, [][]byte{{2, 'b'}}, pongs)
+	assert.Equal(t, 1, c.InboundBuffered())
+
+	// unknown frame types close the connection unless NotFound is set.
+	c = &mockConn{}
+	out, _ := codec.Encode(c, []byte{3})
+	c.feed(out)
+	assert.Equal(t, Close, mux.Serve(c))
+
+	var unknown []byte
+	mux.NotFound = func(c Conn, frame []byte) Action {
+		unknown = frame
+		return Shutdown
+	}
+	c.feed(out)
+	assert.Equal(t, Shutdown, mux.Serve(c))
+	assert.Equal(t, []byte{3}, unknown)
+}
+
+func TestHandlerMuxRecycleFrames(t *testing.T) {
+	codec := NewLengthFieldBasedFrameCodec(
+		EncoderConfig{ByteOrder: binary.BigEndian, LengthFieldLength: 2},
+		DecoderConfig{ByteOrder: binary.BigEndian, LengthFieldLength: 2},
+	)
+	mux := NewHandlerMux(codec, 0, 1, binary.BigEndian)
+	mux.RecycleFrames = true
+
+	var got []string
+	mux.Handle(1, func(c Conn, frame []byte) Action {
+		got = append(got, string(frame[1:]))
+		return None
+	})
+
+	c := &mockConn{}
+	for _, payload := range []string{"\x01first", "\x01second", "\x01third"} {
+		out, err := codec.Encode(c, []byte(payload))
+		require.NoError(t, err)
+		c.feed(out)
+	}
+	assert.Equal(t, None, mux.Serve(c))
+	assert.Equal(t, []string{"first", "second", "third"}, got)
+}
+
+func TestHandlerMuxMaxFramesPerRead(t *testing.T) {
+	codec := NewLengthFieldBasedFrameCodec(
+		EncoderConfig{ByteOrder: binary.BigEndian, LengthFieldLength: 2},
+		DecoderConfig{ByteOrder: binary.BigEndian, LengthFieldLength: 2},
+	)
+	mux := NewHandlerMux(codec, 0, 1, binary.BigEndian)
+	mux.MaxFramesPerRead = 2
+	var handled int
+	mux.Handle(1, func(c Conn, frame []byte) Action {
+		handled++
+		return None
+	})
+
+	c := &mockConn{wakeCh: make(chan struct{}, 1)}
+	out, err := codec.Encode(c, []byte{1})
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		c.feed(out)
+	}
+	assert.Equal(t, None, mux.Serve(c))
+	assert.Equal(t, 2, handled)
+	assert.Equal(t, len(out), c.InboundBuffered())
+	select {
+	case <-c.wakeCh:
+	default:
+		t.Fatal("the connection should be woken for the remaining frame")
+	}
+	assert.Equal(t, None, mux.Serve(c))
+	assert.Equal(t, 3, handled)
+	assert.Empty(t, c.wakeCh)
+}
+
+func TestHandlerMuxShouldShed(t *testing.T) {
+	codec := NewLengthFieldBasedFrameCodec(
+		EncoderConfig{ByteOrder: binary.BigEndian, LengthFieldLength: 2},
+		DecoderConfig{ByteOrder: binary.BigEndian, LengthFieldLength: 2},
+	)
+	mux := NewHandlerMux(codec, 0, 1, binary.BigEndian)
+	var handled int
+	mux.Handle(1, func(c Conn, frame []byte) Action {
+		handled++
+		return None
+	})
+	overloaded := false
+	mux.ShouldShed = func(c Conn) bool { return overloaded }
+	mux.ShedResponse = []byte("\x09busy")
+
+	c := &mockConn{}
+	out, err := codec.Encode(c, []byte{1})
+	require.NoError(t, err)
+	c.feed(out)
+	assert.Equal(t, None, mux.Serve(c))
+	assert.Equal(t, 1, handled)
+	assert.Empty(t, c.outbound)
+
+	overloaded = true
+	c.feed(out)
+	assert.Equal(t, Close, mux.Serve(c))
+	assert.Equal(t, 1, handled, "the frame should not be dispatched under overload")
+	busy, err := codec.Encode(c, mux.ShedResponse)
+	require.NoError(t, err)
+	assert.Equal(t, busy, c.outbound)
+}
+
+func TestHandlerMuxOnUnrecognized(t *testing.T) {
+	// the frames start with the magic 0xCA.
+	codec := NewLengthFieldBasedFrameCodec(
+		EncoderConfig{ByteOrder: binary.BigEndian, LengthFieldLength: 2},
+		DecoderConfig{
+			ByteOrder:         binary.BigEndian,
+			LengthFieldOffset: 1,
+			LengthFieldLength: 2,
+			VerifyInterHeader: func(lengthField, _ []byte) error {
+				if lengthField[0] != 0xCA {
+					return gerr.ErrMalformedFrame
+				}
+				return nil
+			},
+		},
+	)
+	mux := NewHandlerMux(codec, 0, 1, binary.BigEndian)
+	var handled int
+	mux.Handle(1, func(c Conn, frame []byte) Action {
+		handled++
+		return None
+	})
+
+	c := &mockConn{}
+	c.feed([]byte("GET / HTTP/1.1\r\n"))
+	assert.Equal(t, Close, mux.Serve(c), "the connection is closed without OnUnrecognized")
+
+	mux.OnUnrecognized = func(c Conn, peeked []byte) Action {
+		assert.Equal(t, "GET / HTTP/1.1\r\n", string(peeked))
+		_, _ = c.Discard(len(peeked))
+		_, _ = c.Write([]byte("HTTP/1.1 400 Bad Request\r\n\r\n"))
+		return None
+	}
+	assert.Equal(t, None, mux.Serve(c))
+	assert.Equal(t, "HTTP/1.1 400 Bad Request\r\n\r\n", string(c.outbound))
+	assert.Zero(t, c.InboundBuffered())
+
+	c.feed([]byte{0xCA, 0x00, 0x01, 0x01})
+	assert.Equal(t, None, mux.Serve(c))
+	assert.Equal(t, 1, handled)
+}
+
+func TestHandlerMuxNoCodec(t *testing.T) {
+	mux := NewHandlerMux(nil, 0, 1, binary.BigEndian)
+	var handled int
+	mux.Handle(1, func(c Conn, frame []byte) Action {
+		handled++
+		return None
+	})
+
+	c := &mockConn{}
+	c.feed([]byte{0x00, 0x01, 0x01})
+	assert.Equal(t, Close, mux.Serve(c), "a connection without a codec is closed")
+	assert.Zero(t, handled)
+
+	c.codec = NewLengthFieldBasedFrameCodec(
+		EncoderConfig{ByteOrder: binary.BigEndian, LengthFieldLength: 2},
+		DecoderConfig{ByteOrder: binary.BigEndian, LengthFieldLength: 2},
+	)
+	assert.Equal(t, None, mux.Serve(c))
+	assert.Equal(t, 1, handled, "the codec of the connection is used")
+}
@@ -0,0 +1,90 @@
+// Copyright (c) 2021 Andy Pan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ratelimit
+
+import "time"
+
+// pacerBurst is the period of bytes at the current rate a Pacer allows to be sent at once.
+const pacerBurst = 100 * time.Millisecond
+
+// Pacer paces a byte stream at a rate that ramps up linearly from initialRate to rate during ramp since
+// the Pacer starts, and stays at rate thereafter. Unlike TokenBucket, Pacer is not concurrency-safe.
+type Pacer struct {
+	initialRate float64
+	rate        float64
+	ramp        time.Duration
+	start       time.Time
+	last        time.Time
+	tokens      float64
+}
+
+// NewPacer instantiates a Pacer starting at now, initialRate defaults to rate when it is not positive.
+func NewPacer(initialRate, rate int, ramp time.Duration, now time.Time) *Pacer {
+	if initialRate <= 0 || initialRate > rate {
+		initialRate = rate
+	}
+	p := &Pacer{initialRate: float64(initialRate), rate: float64(rate), ramp: ramp, start: now, last: now}
+	p.tokens = p.burst(now)
+	return p
+}
+
+// rateAt returns the rate in bytes per second at now.
+func (p *Pacer) rateAt(now time.Time) float64 {
+	elapsed := now.Sub(p.start)
+	if p.ramp <= 0 || elapsed >= p.ramp {
+		return p.rate
+	}
+	return p.initialRate + (p.rate-p.initialRate)*float64(elapsed)/float64(p.ramp)
+}
+
+func (p *Pacer) burst(now time.Time) float64 {
+	if b := p.rateAt(now) * pacerBurst.Seconds(); b > 1 {
+		return b
+	}
+	return 1
+}
+
+// Allowance returns the number of bytes that can be sent at now.
+func (p *Pacer) Allowance(now time.Time) int {
+	if elapsed := now.Sub(p.last); elapsed > 0 {
+		p.tokens += elapsed.Seconds() * p.rateAt(now)
+		if burst := p.burst(now); p.tokens > burst {
+			p.tokens = burst
+		}
+		p.last = now
+	}
+	if p.tokens < 1 {
+		return 0
+	}
+	return int(p.tokens)
+}
+
+// Consume takes n bytes from the allowance, they must have been granted by Allowance.
+func (p *Pacer) Consume(n int) {
+	p.tokens -= float64(n)
+}
+
+// Delay returns the duration from the last call of Allowance until n bytes, or as many bytes as
+// the Pacer allows at once if it's less than n, can be sent.
+func (p *Pacer) Delay(n int) time.Duration {
+	need := float64(n)
+	if burst := p.burst(p.last); need > burst {
+		need = burst
+	}
+	if need -= p.tokens; need <= 0 {
+		return 0
+	}
+	return time.Duration(need / p.rateAt(p.last) * float64(time.Second))
+}
@@ -0,0 +1,57 @@
+// Copyright (c) 2021 Andy Pan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPacer(t *testing.T) {
+	now := time.Now()
+	// ramps up from 1000 to 11000 bytes per second in 10 seconds.
+	p := NewPacer(1000, 11000, 10*time.Second, now)
+	if n := p.Allowance(now); n != 100 {
+		t.Fatalf("expect a burst of 100ms at the initial rate, got %d", n)
+	}
+	p.Consume(100)
+	if n := p.Allowance(now); n != 0 {
+		t.Fatalf("expect the allowance to be drained, got %d", n)
+	}
+	if d := p.Delay(50); d != 50*time.Millisecond {
+		t.Fatalf("expect 50 bytes to be allowed after 50ms, got %v", d)
+	}
+	if d := p.Delay(1000); d != 100*time.Millisecond {
+		t.Fatalf("expect the delay to be capped by the burst, got %v", d)
+	}
+
+	// the rate is 6000 bytes per second halfway through the ramp, which caps the allowance at 600 bytes.
+	now = now.Add(5 * time.Second)
+	if n := p.Allowance(now); n != 600 {
+		t.Fatalf("expect a burst of 100ms at the ramping rate, got %d", n)
+	}
+	p.Consume(600)
+	// the rate has ramped up to 6050 bytes per second after another 50ms.
+	if n := p.Allowance(now.Add(50 * time.Millisecond)); n != 302 {
+		t.Fatalf("expect 302 bytes to be allowed after 50ms, got %d", n)
+	}
+
+	// the rate stays at the target after the ramp.
+	p.Consume(302)
+	now = now.Add(time.Minute)
+	if n := p.Allowance(now); n != 1100 {
+		t.Fatalf("expect a burst of 100ms at the target rate, got %d", n)
+	}
+}
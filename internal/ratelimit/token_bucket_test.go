@@ -0,0 +1,36 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	tb := NewTokenBucket(10, 3)
+	now := tb.last
+	for i := 0; i < 3; i++ {
+		if !tb.allowAt(now) {
+			t.Fatalf("expect token %d of the burst to be available", i)
+		}
+	}
+	if tb.allowAt(now) {
+		t.Fatal("expect the bucket to be drained after the burst")
+	}
+	// 10 tokens per second refills one token every 100ms.
+	if !tb.allowAt(now.Add(100 * time.Millisecond)) {
+		t.Fatal("expect a token to be refilled after 100ms")
+	}
+	if tb.allowAt(now.Add(150 * time.Millisecond)) {
+		t.Fatal("expect no token to be refilled after another 50ms")
+	}
+	// the bucket never holds more than burst tokens.
+	now = now.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		if !tb.allowAt(now) {
+			t.Fatalf("expect token %d of the burst to be available", i)
+		}
+	}
+	if tb.allowAt(now) {
+		t.Fatal("expect the bucket to hold at most burst tokens")
+	}
+}
@@ -0,0 +1,180 @@
+// Copyright (c) 2021 Andy Pan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package snappy implements the block format of Snappy described in
+// https://github.com/google/snappy/blob/main/format_description.txt, which is just enough
+// for the Snappy framing codec, the encoder favors simplicity over the compression ratio.
+package snappy
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+const (
+	tagLiteral = 0x00
+	tagCopy1   = 0x01
+	tagCopy2   = 0x02
+	tagCopy4   = 0x03
+
+	// minMatch is the shortest match the encoder emits a copy for.
+	minMatch = 4
+	// maxCopyLength is the longest copy of a single element with a 2-byte offset.
+	maxCopyLength = 64
+	// maxOffset is the farthest offset of a copy with a 2-byte offset.
+	maxOffset = 1<<16 - 1
+	hashBits  = 14
+)
+
+// ErrCorrupt occurs when the input is not a valid Snappy block.
+var ErrCorrupt = errors.New("snappy: corrupt input")
+
+// Decode decodes the Snappy block src, maxLen is the limit of the decoded length.
+func Decode(src []byte, maxLen int) ([]byte, error) {
+	v, n := binary.Uvarint(src)
+	if n <= 0 || v > uint64(maxLen) {
+		return nil, ErrCorrupt
+	}
+	dst := make([]byte, 0, v)
+	for s := n; s < len(src); {
+		var length, offset int
+		tag := src[s]
+		switch tag & 0x03 {
+		case tagLiteral:
+			length = int(tag >> 2)
+			s++
+			if length >= 60 {
+				extra := length - 59
+				if s+extra > len(src) {
+					return nil, ErrCorrupt
+				}
+				length = 0
+				for i := extra - 1; i >= 0; i-- {
+					length = length<<8 | int(src[s+i])
+				}
+				s += extra
+			}
+			length++
+			if length > len(src)-s || length > cap(dst)-len(dst) {
+				return nil, ErrCorrupt
+			}
+			dst = append(dst, src[s:s+length]...)
+			s += length
+			continue
+		case tagCopy1:
+			if s+2 > len(src) {
+				return nil, ErrCorrupt
+			}
+			length = 4 + int(tag>>2)&0x07
+			offset = int(tag>>5)<<8 | int(src[s+1])
+			s += 2
+		case tagCopy2:
+			if s+3 > len(src) {
+				return nil, ErrCorrupt
+			}
+			length = 1 + int(tag>>2)
+			offset = int(binary.LittleEndian.Uint16(src[s+1:]))
+			s += 3
+		case tagCopy4:
+			if s+5 > len(src) {
+				return nil, ErrCorrupt
+			}
+			length = 1 + int(tag>>2)
+			offset = int(binary.LittleEndian.Uint32(src[s+1:]))
+			s += 5
+		}
+		if offset <= 0 || offset > len(dst) || length > cap(dst)-len(dst) {
+			return nil, ErrCorrupt
+		}
+		// The source and the destination of a copy may overlap, so it goes byte by byte.
+		for start := len(dst) - offset; length > 0; length-- {
+			dst = append(dst, dst[start])
+			start++
+		}
+	}
+	if len(dst) != int(v) {
+		return nil, ErrCorrupt
+	}
+	return dst, nil
+}
+
+// Encode encodes src into a Snappy block.
+func Encode(src []byte) []byte {
+	dst := binary.AppendUvarint(make([]byte, 0, len(src)+len(src)/6+16), uint64(len(src)))
+	if len(src) < minMatch {
+		return appendLiteral(dst, src)
+	}
+
+	var table [1 << hashBits]int32
+	literal := 0
+	for s := 0; s+minMatch <= len(src); {
+		h := hash(binary.LittleEndian.Uint32(src[s:]))
+		candidate := int(table[h]) - 1
+		table[h] = int32(s + 1)
+		if candidate < 0 || s-candidate > maxOffset ||
+			binary.LittleEndian.Uint32(src[candidate:]) != binary.LittleEndian.Uint32(src[s:]) {
+			s++
+			continue
+		}
+		dst = appendLiteral(dst, src[literal:s])
+		length := minMatch
+		for s+length < len(src) && src[candidate+length] == src[s+length] {
+			length++
+		}
+		dst = appendCopy(dst, s-candidate, length)
+		s += length
+		literal = s
+	}
+	return appendLiteral(dst, src[literal:])
+}
+
+func hash(u uint32) uint32 {
+	return (u * 0x1e35a7bd) >> (32 - hashBits)
+}
+
+func appendLiteral(dst, lit []byte) []byte {
+	if len(lit) == 0 {
+		return dst
+	}
+	switch n := len(lit) - 1; {
+	case n < 60:
+		dst = append(dst, byte(n)<<2|tagLiteral)
+	case n < 1<<8:
+		dst = append(dst, 60<<2|tagLiteral, byte(n))
+	case n < 1<<16:
+		dst = append(dst, 61<<2|tagLiteral, byte(n), byte(n>>8))
+	case n < 1<<24:
+		dst = append(dst, 62<<2|tagLiteral, byte(n), byte(n>>8), byte(n>>16))
+	default:
+		dst = append(dst, 63<<2|tagLiteral, byte(n), byte(n>>8), byte(n>>16), byte(n>>24))
+	}
+	return append(dst, lit...)
+}
+
+// appendCopy appends the copies of a match, each copy with a 2-byte offset covers up to maxCopyLength bytes.
+func appendCopy(dst []byte, offset, length int) []byte {
+	for length > 0 {
+		n := length
+		if n > maxCopyLength {
+			n = maxCopyLength
+			// Leave at least minMatch bytes for the last copy.
+			if length-n < minMatch {
+				n = length - minMatch
+			}
+		}
+		dst = append(dst, byte(n-1)<<2|tagCopy2, byte(offset), byte(offset>>8))
+		length -= n
+	}
+	return dst
+}
@@ -0,0 +1,69 @@
+// Copyright (c) 2021 Andy Pan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snappy
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	random := make([]byte, 5000)
+	rand.New(rand.NewSource(1)).Read(random)
+	for _, src := range [][]byte{
+		nil,
+		[]byte("abc"),
+		[]byte("hello, hello, hello, hello, world"),
+		bytes.Repeat([]byte{'a'}, 70000),
+		bytes.Repeat([]byte("0123456789"), 1000),
+		random,
+	} {
+		encoded := Encode(src)
+		decoded, err := Decode(encoded, len(src))
+		if err != nil {
+			t.Fatalf("failed to decode %d bytes: %v", len(src), err)
+		}
+		if !bytes.Equal(src, decoded) {
+			t.Fatalf("expect %d bytes decoded back, got %d", len(src), len(decoded))
+		}
+	}
+	if n := len(Encode(bytes.Repeat([]byte{'a'}, 70000))); n > 5000 {
+		t.Fatalf("expect the repeated bytes to be compressed, got %d bytes", n)
+	}
+}
+
+func TestDecode(t *testing.T) {
+	// "abcabcabca": a 3-byte literal followed by a 1-byte offset copy of 7 bytes overlapping itself.
+	decoded, err := Decode([]byte{10, 2 << 2, 'a', 'b', 'c', 3<<2 | tagCopy1, 3}, 10)
+	if err != nil || string(decoded) != "abcabcabca" {
+		t.Fatalf("expect abcabcabca, got %q, %v", decoded, err)
+	}
+	for _, src := range [][]byte{
+		{},
+		// the decoded length exceeds the limit.
+		{11, 0},
+		// the copy goes ahead of the decoded bytes.
+		{4, 3<<2 | tagCopy1, 1},
+		// the literal is truncated.
+		{3, 2 << 2, 'a'},
+		// the decoded length mismatches.
+		{4, 2 << 2, 'a', 'b', 'c'},
+	} {
+		if _, err := Decode(src, 10); err != ErrCorrupt {
+			t.Fatalf("expect ErrCorrupt for %v, got %v", src, err)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright (c) 2019 Andy Pan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gnet
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LoopStat is a snapshot of the load of an event-loop, see Engine.LoopStats.
+type LoopStat struct {
+	// Index is the index of the event-loop in the engine.
+	Index int
+	// Connections is the number of the active connections of the event-loop.
+	Connections int
+	// Frames is the number of the frames decoded on the connections of the event-loop by HandlerMux, DecodeBatch
+	// and DecodeContext, the frames decoded by calling ICodec.Decode directly are not counted.
+	Frames uint64
+	// FramesPerSecond is the rate of Frames over the period since the previous call of Engine.LoopStats
+	// that is at least a second ago, it's zero on the first call.
+	FramesPerSecond float64
+	// InboundBuffered is the number of bytes buffered by the inbound buffers of the connections of the event-loop.
+	InboundBuffered int
+	// OutboundBuffered is the number of bytes buffered by the outbound buffers of the connections of the event-loop.
+	OutboundBuffered int
+}
+
+// loopStats keeps the counters of an event-loop, its 64-bit words must stay at the beginning to be
+// 64-bit aligned for the atomic operations on 32-bit platforms.
+type loopStats struct {
+	frames   uint64
+	inbound  int64
+	outbound int64
+
+	mu            sync.Mutex
+	sampledAt     time.Time
+	sampledFrames uint64
+	rate          float64
+}
+
+// countFrames adds n frames decoded from c to the stats of its event-loop.
+func countFrames(c Conn, n int) {
+	if c, ok := c.(*conn); ok && c.loop != nil && n > 0 {
+		atomic.AddUint64(&c.loop.stats.frames, uint64(n))
+	}
+}
+
+// countBuffered brings the bytes buffered by c up to date in the stats of its event-loop,
+// it must be called in the event-loop after the buffers of c have changed.
+func (el *eventloop) countBuffered(c *conn) {
+	if !c.opened {
+		return
+	}
+	inbound, outbound := c.inboundBuffer.Buffered(), c.outboundBuffer.Buffered()
+	if inbound != c.inboundCounted {
+		atomic.AddInt64(&el.stats.inbound, int64(inbound-c.inboundCounted))
+		c.inboundCounted = inbound
+	}
+	if outbound != c.outboundCounted {
+		atomic.AddInt64(&el.stats.outbound, int64(outbound-c.outboundCounted))
+		c.outboundCounted = outbound
+	}
+}
+
+// uncountBuffered takes the bytes buffered by c out of the stats of its event-loop when c is closed.
+func (el *eventloop) uncountBuffered(c *conn) {
+	atomic.AddInt64(&el.stats.inbound, -int64(c.inboundCounted))
+	atomic.AddInt64(&el.stats.outbound, -int64(c.outboundCounted))
+	c.inboundCounted, c.outboundCounted = 0, 0
+}
+
+// stat returns the snapshot of the stats of the event-loop, it's safe to be called from any goroutine.
+func (el *eventloop) stat(now time.Time) LoopStat {
+	frames := atomic.LoadUint64(&el.stats.frames)
+	el.stats.mu.Lock()
+	if el.stats.sampledAt.IsZero() {
+		el.stats.sampledAt, el.stats.sampledFrames = now, frames
+	} else if elapsed := now.Sub(el.stats.sampledAt); elapsed >= time.Second {
+		el.stats.rate = float64(frames-el.stats.sampledFrames) / elapsed.Seconds()
+		el.stats.sampledAt, el.stats.sampledFrames = now, frames
+	}
+	rate := el.stats.rate
+	el.stats.mu.Unlock()
+	return LoopStat{
+		Index:            el.idx,
+		Connections:      int(el.loadConn()),
+		Frames:           frames,
+		FramesPerSecond:  rate,
+		InboundBuffered:  int(atomic.LoadInt64(&el.stats.inbound)),
+		OutboundBuffered: int(atomic.LoadInt64(&el.stats.outbound)),
+	}
+}
@@ -40,6 +40,27 @@ const (
 	TCPDelay
 )
 
+// OutboundBufferPolicy is the type of the policy applied when the outbound buffer of a connection
+// has reached Options.OutboundBufferCap.
+type OutboundBufferPolicy int
+
+// Available outbound buffer policies.
+const (
+	// OutboundGrow lets the outbound buffer grow up to OutboundBufferCap, the connection is closed with
+	// errors.ErrOutboundBufferFull by the write that would take it beyond the cap. This is the default policy.
+	OutboundGrow OutboundBufferPolicy = iota
+
+	// OutboundDrop rejects the write that would take the outbound buffer beyond OutboundBufferCap with
+	// errors.ErrOutboundBufferFull, the data of that write is dropped and the connection stays open.
+	OutboundDrop
+
+	// OutboundBlock applies backpressure to Conn.AsyncWrite and Conn.AsyncWritev, they block the calling
+	// goroutine until the pending data drops under OutboundBufferCap. The writes issued in the event-loop
+	// are never blocked nor dropped under this policy, thus the asynchronous writes must not be called
+	// in the event-loop goroutine, or it will get stuck.
+	OutboundBlock
+)
+
 // Options are configurations for the gnet application.
 type Options struct {
 	// ================================== Options for only server-side ==================================
@@ -63,6 +84,16 @@ type Options struct {
 	// ReusePort indicates whether to set up the SO_REUSEPORT socket option.
 	ReusePort bool
 
+	// AcceptRateLimit is the maximum number of new connections accepted per second, the connections beyond
+	// this rate are closed right after they are accepted and before they are registered with any event-loop,
+	// the OnAcceptThrottled of EventHandler fires for each of them if it implements AcceptThrottleHandler.
+	// Zero means no limit.
+	AcceptRateLimit int
+
+	// AcceptBurst is the maximum number of new connections that can be accepted at once under AcceptRateLimit,
+	// it defaults to AcceptRateLimit.
+	AcceptBurst int
+
 	// ============================= Options for both server-side and client-side =============================
 
 	// ReadBufferCap is the maximum number of bytes that can be read from the peer when the readable event comes.
@@ -81,6 +112,49 @@ type Options struct {
 	// or equal to its real amount.
 	WriteBufferCap int
 
+	// OutboundBufferCap is the maximum number of bytes pending in the outbound buffer of a connection,
+	// a write is checked against it only when there is pending data already, so a single write larger
+	// than the cap still goes through when the outbound buffer is empty. Zero means no limit, which is the default.
+	OutboundBufferCap int
+
+	// OutboundBufferPolicy determines what happens to a write that would take the outbound buffer beyond
+	// OutboundBufferCap, it defaults to OutboundGrow.
+	OutboundBufferPolicy OutboundBufferPolicy
+
+	// WritePacingRate is the rate in bytes per second at which the outbound data of each connection is sent,
+	// the data beyond it is held in the outbound buffer, zero means no pacing, which is the default.
+	WritePacingRate int
+
+	// WritePacingInitialRate is the rate at which a connection starts sending, it ramps up linearly to
+	// WritePacingRate during WritePacingRamp since the connection is opened, which prevents bulk pushes
+	// from overwhelming a just-connected client. It defaults to WritePacingRate, i.e. no ramp.
+	WritePacingInitialRate int
+
+	// WritePacingRamp is the duration of the ramp from WritePacingInitialRate to WritePacingRate.
+	WritePacingRamp time.Duration
+
+	// WriteCoalescing indicates whether to coalesce all writes made to a connection during an EventHandler.OnTraffic
+	// into the outbound buffer and flush them once OnTraffic returns, so that a handler writing several frames in
+	// response to one request produces a single write syscall. Conn.Flush still writes the coalesced data at once,
+	// and the coalesced data counts as the pending data against OutboundBufferCap.
+	WriteCoalescing bool
+
+	// WriteRetries is the number of times the outbound data of a connection is retried in a row after a write fails
+	// with a transient error, ENOBUFS or ENOMEM, under momentary pressure on the socket buffers, before the
+	// connection is closed with the error, zero means no retry, which is the default. The write interrupted by
+	// EINTR is always retried right away and EAGAIN always waits for the connection to be writable.
+	WriteRetries int
+
+	// WriteRetryBackoff is the delay before the first retry under WriteRetries, which is doubled on each retry.
+	WriteRetryBackoff time.Duration
+
+	// ArrivalTimestamps indicates whether to record the time the bytes read from a connection arrive at, which is
+	// returned by Conn.ArrivedAt, for the latency analysis, see DecodeWithArrival.
+	ArrivalTimestamps bool
+
+	// Codec is the default codec of all connections, a connection can be given another codec by Conn.SetCodec.
+	Codec ICodec
+
 	// LockOSThread is used to determine whether each I/O event-loop is associated to an OS thread, it is useful when you
 	// need some kind of mechanisms like thread local storage, or invoke certain C libraries (such as graphics lib: GLib)
 	// that require thread-level manipulation via cgo, or want all I/O event-loops to actually run in parallel for a
@@ -156,6 +230,54 @@ func WithWriteBufferCap(writeBufferCap int) Option {
 	}
 }
 
+// WithOutboundBufferPolicy sets up the cap of the outbound buffer and the policy applied when it's reached.
+func WithOutboundBufferPolicy(bufferCap int, policy OutboundBufferPolicy) Option {
+	return func(opts *Options) {
+		opts.OutboundBufferCap = bufferCap
+		opts.OutboundBufferPolicy = policy
+	}
+}
+
+// WithWritePacing paces the outbound data of each connection at rate bytes per second,
+// after ramping up from initialRate during ramp.
+func WithWritePacing(initialRate, rate int, ramp time.Duration) Option {
+	return func(opts *Options) {
+		opts.WritePacingInitialRate = initialRate
+		opts.WritePacingRate = rate
+		opts.WritePacingRamp = ramp
+	}
+}
+
+// WithArrivalTimestamps sets up ArrivalTimestamps in gnet engine.
+func WithArrivalTimestamps(arrivalTimestamps bool) Option {
+	return func(opts *Options) {
+		opts.ArrivalTimestamps = arrivalTimestamps
+	}
+}
+
+// WithWriteRetry retries the writes failing with the transient errors up to retries times,
+// backing off from backoff, see WriteRetries.
+func WithWriteRetry(retries int, backoff time.Duration) Option {
+	return func(opts *Options) {
+		opts.WriteRetries = retries
+		opts.WriteRetryBackoff = backoff
+	}
+}
+
+// WithWriteCoalescing sets up WriteCoalescing in gnet engine.
+func WithWriteCoalescing(coalescing bool) Option {
+	return func(opts *Options) {
+		opts.WriteCoalescing = coalescing
+	}
+}
+
+// WithCodec sets up the default codec of connections.
+func WithCodec(codec ICodec) Option {
+	return func(opts *Options) {
+		opts.Codec = codec
+	}
+}
+
 // WithLoadBalancing sets up the load-balancing algorithm in gnet engine.
 func WithLoadBalancing(lb LoadBalancing) Option {
 	return func(opts *Options) {
@@ -184,6 +306,14 @@ func WithReuseAddr(reuseAddr bool) Option {
 	}
 }
 
+// WithAcceptRateLimit limits the rate of accepting new connections to limit per second with burst.
+func WithAcceptRateLimit(limit, burst int) Option {
+	return func(opts *Options) {
+		opts.AcceptRateLimit = limit
+		opts.AcceptBurst = burst
+	}
+}
+
 // WithTCPKeepAlive sets up the SO_KEEPALIVE socket option with duration.
 func WithTCPKeepAlive(tcpKeepAlive time.Duration) Option {
 	return func(opts *Options) {
@@ -14,7 +14,10 @@
 
 package errors
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 var (
 	// ErrEngineShutdown occurs when server is closing.
@@ -39,4 +42,53 @@ var (
 	ErrUnsupportedOp = errors.New("unsupported operation")
 	// ErrNegativeSize occurs when trying to pass a negative size to a buffer.
 	ErrNegativeSize = errors.New("negative size is invalid")
+	// ErrWriteClosed occurs when trying to write to a connection whose writing side has been shut down.
+	ErrWriteClosed = errors.New("write side of the connection has been closed")
+	// ErrOutboundBufferFull occurs when a write would take the outbound buffer beyond its cap.
+	ErrOutboundBufferFull = errors.New("outbound buffer is full")
+	// ErrCloseHandshakeTimeout occurs when the peer doesn't finish the close handshake started by GracefulClose in time.
+	ErrCloseHandshakeTimeout = errors.New("close handshake timed out")
+	// ErrInboundBufferFull occurs when the inbound bytes held back from decoding exceed the limit.
+	ErrInboundBufferFull = errors.New("inbound buffer is full")
+	// ErrTooManyBytesToStrip occurs when the initial bytes to strip out exceed the length of the decoded frame.
+	ErrTooManyBytesToStrip = errors.New("initial bytes to strip exceed the frame length")
+	// ErrShortFrame occurs when a decoded frame is too short to contain the expected field.
+	ErrShortFrame = errors.New("frame is too short")
+	// ErrUnsupportedLength occurs when a field length other than the supported ones is specified.
+	ErrUnsupportedLength = errors.New("unsupported field length")
+	// ErrMalformedFrame occurs when the bytes being decoded violate the framing protocol.
+	ErrMalformedFrame = errors.New("malformed frame")
+	// ErrInvalidFrameLength occurs when the length of a frame declared by its header is negative,
+	// it's an ErrMalformedFrame as well.
+	ErrInvalidFrameLength = fmt.Errorf("%w: invalid frame length", ErrMalformedFrame)
+	// ErrFrameTooLarge occurs when the length of a frame declared by its header exceeds the limit.
+	ErrFrameTooLarge = errors.New("frame is too large")
+	// ErrEncodeLengthOverflow occurs when the length of a frame being encoded doesn't fit into its length field
+	// or exceeds the limit of the codec.
+	ErrEncodeLengthOverflow = errors.New("length overflows the length field")
+	// ErrBadLengthParity occurs when the parity bit of a length field doesn't match the other bits.
+	ErrBadLengthParity = errors.New("length field parity mismatch")
+	// ErrInvalidUTF8 occurs when a decoded text frame is not valid UTF-8.
+	ErrInvalidUTF8 = errors.New("frame is not valid UTF-8")
+	// ErrInvalidChecksum occurs when the checksum of a decoded frame doesn't match the one carried by the frame.
+	ErrInvalidChecksum = errors.New("frame checksum mismatch")
 )
+
+// FrameTooLargeError is the ErrFrameTooLarge carrying the length of the rejected frame and the limit it exceeds,
+// errors.Is(err, ErrFrameTooLarge) holds for it, use errors.As to get the lengths out.
+type FrameTooLargeError struct {
+	// Length is the length of the frame declared by its header.
+	Length int64
+	// Max is the limit exceeded by the frame.
+	Max int64
+}
+
+// Error implements error.
+func (e *FrameTooLargeError) Error() string {
+	return fmt.Sprintf("%v: %d-byte frame beyond %d bytes", ErrFrameTooLarge, e.Length, e.Max)
+}
+
+// Unwrap returns ErrFrameTooLarge.
+func (e *FrameTooLargeError) Unwrap() error {
+	return ErrFrameTooLarge
+}
@@ -0,0 +1,425 @@
+// Copyright (c) 2019 Andy Pan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package loopback provides an in-process gnet.Conn without any socket behind it and an echo handler,
+// they are meant for testing and benchmarking codecs and event handlers in isolation and deterministically.
+package loopback
+
+import (
+	"io"
+	"net"
+	"time"
+
+	"github.com/walkon/wsgnet"
+	"github.com/walkon/wsgnet/pkg/errors"
+	"github.com/walkon/wsgnet/pkg/logging"
+)
+
+// Addr is the address of both ends of a loopback Conn.
+type Addr struct{}
+
+// Network implements net.Addr.
+func (Addr) Network() string { return "loopback" }
+
+// String implements net.Addr.
+func (Addr) String() string { return "loopback" }
+
+var _ gnet.Conn = (*Conn)(nil)
+
+// Conn is a gnet.Conn backed by in-memory buffers, the inbound bytes are fed by Feed and the outbound bytes
+// are collected for Outbound, or looped back to the inbound buffer by Loop.
+//
+// The asynchronous methods are executed synchronously in the calling goroutine since there is no event-loop,
+// thus Conn is not concurrency-safe at all, and it's up to the caller to fire the events of gnet.EventHandler.
+type Conn struct {
+	inbound    []byte
+	start      int // read offset of inbound
+	outbound   []byte
+	ctx        interface{}
+	scratch    map[interface{}]interface{}
+	info       interface{}
+	labels     map[string]string
+	codec      gnet.ICodec
+	tee        io.Writer
+	isWebSock  bool
+	closeWrite bool
+	closed     bool
+	arrivedAt  time.Time
+}
+
+// New instantiates and returns an empty loopback Conn.
+func New() *Conn {
+	return new(Conn)
+}
+
+// Feed appends b to the inbound buffer, as if b has arrived from the peer.
+func (c *Conn) Feed(b []byte) {
+	if c.start == len(c.inbound) {
+		c.inbound, c.start = c.inbound[:0], 0
+	}
+	c.inbound = append(c.inbound, b...)
+	c.arrivedAt = time.Now()
+}
+
+// Outbound returns the bytes written to c so far, which are valid until the next write or ResetOutbound.
+func (c *Conn) Outbound() []byte {
+	return c.outbound
+}
+
+// ResetOutbound discards the bytes written to c, the underlying buffer is retained for the following writes.
+func (c *Conn) ResetOutbound() {
+	c.outbound = c.outbound[:0]
+}
+
+// Loop moves the bytes written to c into its inbound buffer, as if the peer has written them back.
+func (c *Conn) Loop() {
+	c.Feed(c.outbound)
+	c.ResetOutbound()
+}
+
+// Closed reports whether c has been closed.
+func (c *Conn) Closed() bool {
+	return c.closed
+}
+
+func (c *Conn) buffered() []byte {
+	return c.inbound[c.start:]
+}
+
+func (c *Conn) write(p []byte) (int, error) {
+	if c.closed {
+		return -1, net.ErrClosed
+	}
+	if c.closeWrite {
+		return -1, errors.ErrWriteClosed
+	}
+	c.outbound = append(c.outbound, p...)
+	if c.tee != nil {
+		_, _ = c.tee.Write(p)
+	}
+	return len(p), nil
+}
+
+// ================================== Reader ==================================
+
+// Read implements io.Reader.
+func (c *Conn) Read(p []byte) (int, error) {
+	n := copy(p, c.buffered())
+	c.start += n
+	return n, nil
+}
+
+// WriteTo implements io.WriterTo.
+func (c *Conn) WriteTo(w io.Writer) (int64, error) {
+	n, err := w.Write(c.buffered())
+	c.start += n
+	return int64(n), err
+}
+
+// Next implements gnet.Reader.
+func (c *Conn) Next(n int) ([]byte, error) {
+	buf, err := c.Peek(n)
+	if err != nil {
+		return nil, err
+	}
+	c.start += len(buf)
+	return buf, nil
+}
+
+// Peek implements gnet.Reader.
+func (c *Conn) Peek(n int) ([]byte, error) {
+	in := c.buffered()
+	if n > len(in) {
+		return nil, io.ErrShortBuffer
+	} else if n <= 0 {
+		n = len(in)
+	}
+	return in[:n], nil
+}
+
+// Discard implements gnet.Reader.
+func (c *Conn) Discard(n int) (int, error) {
+	if buffered := len(c.buffered()); n <= 0 || n > buffered {
+		n = buffered
+	}
+	c.start += n
+	return n, nil
+}
+
+// InboundBuffered implements gnet.Reader.
+func (c *Conn) InboundBuffered() int {
+	return len(c.buffered())
+}
+
+// ================================== Writer ==================================
+
+// Write implements io.Writer.
+func (c *Conn) Write(p []byte) (int, error) {
+	return c.write(p)
+}
+
+// ReadFrom implements io.ReaderFrom.
+func (c *Conn) ReadFrom(r io.Reader) (int64, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return 0, err
+	}
+	n, err := c.write(b)
+	return int64(n), err
+}
+
+// Writev implements gnet.Writer.
+func (c *Conn) Writev(bs [][]byte) (n int, err error) {
+	for _, b := range bs {
+		var m int
+		if m, err = c.write(b); err != nil {
+			return -1, err
+		}
+		n += m
+	}
+	return
+}
+
+// WriteString implements gnet.Writer.
+func (c *Conn) WriteString(s string) (int, error) {
+	if c.codec == nil {
+		return c.write([]byte(s))
+	}
+	if err := gnet.WriteFrame(c, c.codec, []byte(s)); err != nil {
+		return -1, err
+	}
+	return len(s), nil
+}
+
+// Flush implements gnet.Writer, there is nothing to flush.
+func (c *Conn) Flush() error {
+	return nil
+}
+
+// OutboundBuffered implements gnet.Writer, it is always zero since the bytes written are never pending.
+func (c *Conn) OutboundBuffered() int {
+	return 0
+}
+
+// AsyncWrite implements gnet.Writer, the write is done before it returns.
+func (c *Conn) AsyncWrite(buf []byte, callback gnet.AsyncCallback) error {
+	if _, err := c.write(buf); err != nil {
+		return err
+	}
+	if callback != nil {
+		_ = callback(c)
+	}
+	return nil
+}
+
+// AsyncWritev implements gnet.Writer, the write is done before it returns.
+func (c *Conn) AsyncWritev(bs [][]byte, callback gnet.AsyncCallback) error {
+	if _, err := c.Writev(bs); err != nil {
+		return err
+	}
+	if callback != nil {
+		_ = callback(c)
+	}
+	return nil
+}
+
+// ================================== Socket ==================================
+
+// Fd implements gnet.Socket, there is no file descriptor behind a loopback Conn.
+func (c *Conn) Fd() int { return -1 }
+
+// Dup implements gnet.Socket.
+func (c *Conn) Dup() (int, error) { return -1, errors.ErrUnsupportedOp }
+
+// SetReadBuffer implements gnet.Socket.
+func (c *Conn) SetReadBuffer(_ int) error { return errors.ErrUnsupportedOp }
+
+// SetWriteBuffer implements gnet.Socket.
+func (c *Conn) SetWriteBuffer(_ int) error { return errors.ErrUnsupportedOp }
+
+// SetLinger implements gnet.Socket.
+func (c *Conn) SetLinger(_ int) error { return errors.ErrUnsupportedOp }
+
+// SetKeepAlivePeriod implements gnet.Socket.
+func (c *Conn) SetKeepAlivePeriod(_ time.Duration) error { return errors.ErrUnsupportedOp }
+
+// SetNoDelay implements gnet.Socket.
+func (c *Conn) SetNoDelay(_ bool) error { return errors.ErrUnsupportedOp }
+
+// GetNoDelay implements gnet.Socket.
+func (c *Conn) GetNoDelay() (int, error) { return 0, errors.ErrUnsupportedOp }
+
+// CloseWrite implements gnet.Socket, the following writes fail with errors.ErrWriteClosed.
+func (c *Conn) CloseWrite() error {
+	c.closeWrite = true
+	return nil
+}
+
+// ================================== Conn ==================================
+
+// Context implements gnet.Conn.
+func (c *Conn) Context() interface{} { return c.ctx }
+
+// SetContext implements gnet.Conn.
+func (c *Conn) SetContext(ctx interface{}) { c.ctx = ctx }
+
+// ProtocolInfo implements gnet.Conn.
+func (c *Conn) ProtocolInfo() interface{} { return c.info }
+
+// SetProtocolInfo implements gnet.Conn.
+func (c *Conn) SetProtocolInfo(info interface{}) { c.info = info }
+
+// ArrivedAt implements gnet.Conn, it's the time of the latest Feed.
+func (c *Conn) ArrivedAt() time.Time { return c.arrivedAt }
+
+// CodecScratch implements gnet.Conn.
+func (c *Conn) CodecScratch(key interface{}) interface{} { return c.scratch[key] }
+
+// SetCodecScratch implements gnet.Conn.
+func (c *Conn) SetCodecScratch(key, value interface{}) {
+	if c.scratch == nil {
+		c.scratch = make(map[interface{}]interface{})
+	}
+	c.scratch[key] = value
+}
+
+// SetLabel implements gnet.Conn.
+func (c *Conn) SetLabel(key, value string) {
+	if value == "" {
+		delete(c.labels, key)
+		return
+	}
+	if c.labels == nil {
+		c.labels = make(map[string]string)
+	}
+	c.labels[key] = value
+}
+
+// GetLabels implements gnet.Conn.
+func (c *Conn) GetLabels() map[string]string { return c.labels }
+
+// Codec implements gnet.Conn.
+func (c *Conn) Codec() gnet.ICodec { return c.codec }
+
+// SetCodec implements gnet.Conn.
+func (c *Conn) SetCodec(codec gnet.ICodec) { c.codec = codec }
+
+// SpliceFrameTo implements gnet.Conn.
+func (c *Conn) SpliceFrameTo(dst gnet.Conn) (int, error) {
+	fp, ok := c.codec.(gnet.FramePeeker)
+	if !ok {
+		return 0, errors.ErrUnsupportedOp
+	}
+	n, err := fp.PeekFrameLength(c)
+	if n == 0 {
+		if err == io.ErrShortBuffer {
+			err = nil
+		}
+		return 0, err
+	}
+	if _, err = dst.Writev([][]byte{c.buffered()[:n]}); err != nil {
+		return 0, err
+	}
+	if err = fp.DiscardFrame(c, n); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// SetTeeWriter implements gnet.Conn.
+func (c *Conn) SetTeeWriter(w io.Writer) { c.tee = w }
+
+// LocalAddr implements gnet.Conn.
+func (c *Conn) LocalAddr() net.Addr { return Addr{} }
+
+// RemoteAddr implements gnet.Conn.
+func (c *Conn) RemoteAddr() net.Addr { return Addr{} }
+
+// SetDeadline implements gnet.Conn.
+func (c *Conn) SetDeadline(_ time.Time) error { return errors.ErrUnsupportedOp }
+
+// SetReadDeadline implements gnet.Conn.
+func (c *Conn) SetReadDeadline(_ time.Time) error { return errors.ErrUnsupportedOp }
+
+// SetWriteDeadline implements gnet.Conn.
+func (c *Conn) SetWriteDeadline(_ time.Time) error { return errors.ErrUnsupportedOp }
+
+// Wake implements gnet.Conn, the callback is invoked before it returns, it's up to the caller
+// to fire EventHandler.OnTraffic.
+func (c *Conn) Wake(callback gnet.AsyncCallback) error {
+	if callback != nil {
+		_ = callback(c)
+	}
+	return nil
+}
+
+// CloseWithCallback implements gnet.Conn.
+func (c *Conn) CloseWithCallback(callback gnet.AsyncCallback) error {
+	c.closed = true
+	if callback != nil {
+		_ = callback(c)
+	}
+	return nil
+}
+
+// GracefulClose implements gnet.Conn, it writes closeFrame and leaves c open since there is no event-loop
+// to time the handshake out, it's up to the caller to close c.
+func (c *Conn) GracefulClose(closeFrame []byte, _ time.Duration) error {
+	_, err := c.write(closeFrame)
+	return err
+}
+
+// Close implements gnet.Conn.
+func (c *Conn) Close() error {
+	c.closed = true
+	return nil
+}
+
+// SetWebSock implements gnet.Conn.
+func (c *Conn) SetWebSock(ws bool) { c.isWebSock = ws }
+
+// IsWebSock implements gnet.Conn.
+func (c *Conn) IsWebSock() bool { return c.isWebSock }
+
+// EchoHandler is a gnet.EventHandler that decodes the frames from a connection with Codec
+// and writes each of them back encoded by the same Codec.
+type EchoHandler struct {
+	*gnet.BuiltinEventEngine
+
+	// Codec decodes and encodes the frames.
+	Codec gnet.ICodec
+}
+
+// NewEchoHandler instantiates and returns an EchoHandler with codec.
+func NewEchoHandler(codec gnet.ICodec) *EchoHandler {
+	return &EchoHandler{Codec: codec}
+}
+
+// OnTraffic echoes all complete frames buffered in c.
+func (h *EchoHandler) OnTraffic(c gnet.Conn) gnet.Action {
+	for {
+		frame, err := h.Codec.Decode(c)
+		if err == io.ErrShortBuffer || (err == nil && frame == nil) {
+			return gnet.None
+		}
+		if err != nil {
+			logging.Errorf("failed to decode frame from %v: %v", c.RemoteAddr(), err)
+			return gnet.Close
+		}
+		if err = gnet.WriteFrame(c, h.Codec, frame); err != nil {
+			logging.Errorf("failed to write frame to %v: %v", c.RemoteAddr(), err)
+			return gnet.Close
+		}
+	}
+}
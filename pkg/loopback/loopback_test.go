@@ -0,0 +1,145 @@
+// Copyright (c) 2019 Andy Pan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package loopback
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/walkon/wsgnet"
+	"github.com/walkon/wsgnet/pkg/errors"
+)
+
+func newLengthFieldCodec() *gnet.LengthFieldBasedFrameCodec {
+	return gnet.NewLengthFieldBasedFrameCodec(
+		gnet.EncoderConfig{ByteOrder: binary.BigEndian, LengthFieldLength: 4},
+		gnet.DecoderConfig{ByteOrder: binary.BigEndian, LengthFieldLength: 4},
+	)
+}
+
+func TestConn(t *testing.T) {
+	c := New()
+	c.Feed([]byte("hello "))
+	c.Feed([]byte("loopback"))
+	_, err := c.Peek(15)
+	assert.ErrorIs(t, err, io.ErrShortBuffer)
+	buf, err := c.Next(6)
+	require.NoError(t, err)
+	assert.Equal(t, "hello ", string(buf))
+	n, _ := c.Discard(100)
+	assert.Equal(t, 8, n)
+	assert.Zero(t, c.InboundBuffered())
+
+	_, err = c.Writev([][]byte{[]byte("ping"), []byte("pong")})
+	require.NoError(t, err)
+	assert.Equal(t, "pingpong", string(c.Outbound()))
+	c.Loop()
+	assert.Empty(t, c.Outbound())
+	buf, _ = c.Peek(-1)
+	assert.Equal(t, "pingpong", string(buf))
+
+	n, err = c.WriteString("raw")
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+	c.SetCodec(newLengthFieldCodec())
+	n, err = c.WriteString("framed")
+	require.NoError(t, err)
+	assert.Equal(t, 6, n)
+	assert.Equal(t, append([]byte("raw\x00\x00\x00\x06"), "framed"...), c.Outbound())
+	c.ResetOutbound()
+
+	require.NoError(t, c.GracefulClose([]byte("bye"), time.Second))
+	assert.Equal(t, "bye", string(c.Outbound()))
+	assert.False(t, c.Closed(), "the close handshake is left to the caller")
+
+	require.NoError(t, c.CloseWrite())
+	_, err = c.Write([]byte("late"))
+	assert.ErrorIs(t, err, errors.ErrWriteClosed)
+	require.NoError(t, c.Close())
+	assert.True(t, c.Closed())
+}
+
+func TestEchoHandler(t *testing.T) {
+	codec := newLengthFieldCodec()
+	h := NewEchoHandler(codec)
+	c := New()
+	var expected []byte
+	for _, payload := range []string{"first", "second", ""} {
+		out, err := codec.Encode(c, []byte(payload))
+		require.NoError(t, err)
+		expected = append(expected, out...)
+	}
+	c.Feed(expected[:len(expected)-1])
+	assert.Equal(t, gnet.None, h.OnTraffic(c))
+	c.Feed(expected[len(expected)-1:])
+	assert.Equal(t, gnet.None, h.OnTraffic(c))
+	assert.Equal(t, expected, c.Outbound())
+	assert.Zero(t, c.InboundBuffered())
+}
+
+func BenchmarkEchoLengthFieldBasedFrameCodec(b *testing.B) {
+	for _, size := range []int{64, 4096, 65536} {
+		b.Run(fmt.Sprintf("%dB", size), func(b *testing.B) {
+			codec := newLengthFieldCodec()
+			h := NewEchoHandler(codec)
+			c := New()
+			out, err := codec.Encode(c, bytes.Repeat([]byte{'b'}, size))
+			if err != nil {
+				b.Fatal(err)
+			}
+			c.Feed(out)
+
+			b.SetBytes(int64(size))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				// the frame echoed by the last round is decoded and echoed again.
+				if h.OnTraffic(c) != gnet.None {
+					b.Fatal("unexpected action")
+				}
+				c.Loop()
+			}
+		})
+	}
+}
+
+func TestSpliceFrameTo(t *testing.T) {
+	codec := newLengthFieldCodec()
+	src, dst := New(), New()
+	_, err := src.SpliceFrameTo(dst)
+	assert.ErrorIs(t, err, errors.ErrUnsupportedOp, "the codec of src must be a gnet.FramePeeker")
+
+	src.SetCodec(codec)
+	frame, err := codec.Encode(src, []byte("forwarded"))
+	require.NoError(t, err)
+	src.Feed(frame)
+	src.Feed(frame[:5])
+	n, err := src.SpliceFrameTo(dst)
+	require.NoError(t, err)
+	assert.Equal(t, len(frame), n)
+	assert.Equal(t, frame, dst.Outbound(), "the frame is forwarded as it is on the wire")
+
+	n, err = src.SpliceFrameTo(dst)
+	require.NoError(t, err)
+	assert.Zero(t, n, "the next frame is incomplete")
+	assert.Equal(t, 5, src.InboundBuffered())
+}
@@ -0,0 +1,135 @@
+// Copyright (c) 2019 Andy Pan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gnet
+
+import (
+	"container/heap"
+	"sync"
+
+	"github.com/walkon/wsgnet/pkg/errors"
+)
+
+// PriorityDispatcher defers the frames decoded in EventHandler.OnTraffic and dispatches them to its FrameHandler
+// in the order of their priorities, the higher first, across all the connections of an event-loop, instead of
+// in the order the connections become readable. The frames of the same priority are dispatched in the order
+// they are queued.
+//
+// The frames queued while an event-loop is processing a batch of network events are dispatched after the batch,
+// at most budget frames at once, the rest are dispatched after the next batch, so that the network events and
+// the frames of lower priorities are not starved, the Action returned by the FrameHandler is applied to the
+// connection of the frame. The frames of the connections closed meanwhile are dropped.
+//
+// A PriorityDispatcher can be shared between the event-loops, each of which gets a queue of its own.
+type PriorityDispatcher struct {
+	handler FrameHandler
+	budget  int
+	mu      sync.Mutex
+	queues  map[*eventloop]*priorityQueue
+}
+
+// NewPriorityDispatcher instantiates and returns a PriorityDispatcher that dispatches up to budget frames
+// after each batch of network events to handler, zero means no limit.
+func NewPriorityDispatcher(handler FrameHandler, budget int) *PriorityDispatcher {
+	return &PriorityDispatcher{handler: handler, budget: budget, queues: make(map[*eventloop]*priorityQueue)}
+}
+
+// Dispatch queues frame of the given priority decoded from c and returns None, it must be called in the event-loop
+// goroutine, typically in EventHandler.OnTraffic. Since the frame is dispatched later on, it must be owned by
+// the PriorityDispatcher, i.e. not borrowed from the inbound buffer of c. The frame is dispatched right away and
+// the Action of the FrameHandler is returned if c is not served by an event-loop.
+func (pd *PriorityDispatcher) Dispatch(c Conn, frame []byte, priority uint32) Action {
+	gc, ok := c.(*conn)
+	if !ok {
+		return pd.handler(c, frame)
+	}
+	q := pd.queue(gc.loop)
+	q.seq++
+	heap.Push(q, &priorityFrame{c: gc, frame: frame, priority: priority, seq: q.seq})
+	if !q.scheduled {
+		q.scheduled = true
+		if err := gc.loop.poller.Trigger(pd.drain, q); err != nil {
+			q.scheduled = false
+			gc.loop.getLogger().Errorf("failed to schedule the prioritized frames: %v", err)
+		}
+	}
+	return None
+}
+
+func (pd *PriorityDispatcher) queue(el *eventloop) *priorityQueue {
+	pd.mu.Lock()
+	defer pd.mu.Unlock()
+	q, ok := pd.queues[el]
+	if !ok {
+		q = &priorityQueue{el: el}
+		pd.queues[el] = q
+	}
+	return q
+}
+
+// drain dispatches the queued frames of an event-loop in the event-loop goroutine.
+func (pd *PriorityDispatcher) drain(arg interface{}) error {
+	q := arg.(*priorityQueue)
+	q.scheduled = false
+	for n := 0; q.Len() > 0 && (pd.budget <= 0 || n < pd.budget); n++ {
+		pf := heap.Pop(q).(*priorityFrame)
+		if q.el.connections[pf.c.fd] != pf.c {
+			continue
+		}
+		if err := q.el.handleAction(pf.c, pd.handler(pf.c, pf.frame)); err == errors.ErrEngineShutdown {
+			return err
+		}
+	}
+	if q.Len() > 0 {
+		q.scheduled = true
+		return q.el.poller.Trigger(pd.drain, q)
+	}
+	return nil
+}
+
+type priorityFrame struct {
+	c        *conn
+	frame    []byte
+	priority uint32
+	seq      uint64
+}
+
+// priorityQueue is the heap of the frames queued on an event-loop.
+type priorityQueue struct {
+	el        *eventloop
+	frames    []*priorityFrame
+	seq       uint64
+	scheduled bool
+}
+
+func (q *priorityQueue) Len() int { return len(q.frames) }
+
+func (q *priorityQueue) Less(i, j int) bool {
+	if q.frames[i].priority != q.frames[j].priority {
+		return q.frames[i].priority > q.frames[j].priority
+	}
+	return q.frames[i].seq < q.frames[j].seq
+}
+
+func (q *priorityQueue) Swap(i, j int) { q.frames[i], q.frames[j] = q.frames[j], q.frames[i] }
+
+func (q *priorityQueue) Push(x interface{}) { q.frames = append(q.frames, x.(*priorityFrame)) }
+
+func (q *priorityQueue) Pop() interface{} {
+	n := len(q.frames)
+	pf := q.frames[n-1]
+	q.frames[n-1] = nil
+	q.frames = q.frames[:n-1]
+	return pf
+}